@@ -2,7 +2,10 @@ module hackodisha/backend
 
 go 1.21
 
-require github.com/lib/pq v1.10.6
+require (
+	github.com/gin-gonic/gin v1.10.1
+	github.com/lib/pq v1.10.6
+)
 
 require (
 	github.com/bytedance/sonic v1.11.6 // indirect
@@ -11,7 +14,6 @@ require (
 	github.com/cloudwego/iasm v0.2.0 // indirect
 	github.com/gabriel-vasile/mimetype v1.4.3 // indirect
 	github.com/gin-contrib/sse v0.1.0 // indirect
-	github.com/gin-gonic/gin v1.10.1 // indirect
 	github.com/go-playground/locales v0.14.1 // indirect
 	github.com/go-playground/universal-translator v0.18.1 // indirect
 	github.com/go-playground/validator/v10 v10.20.0 // indirect
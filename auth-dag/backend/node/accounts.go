@@ -0,0 +1,99 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandlerAccountKey serves an account's current key for local nodes
+// verifying client signatures: the user_pub of its latest rotate event, or
+// the registered one before any rotation. retired_keys are every earlier key,
+// so a node can tell a signature by a rotated-away key from a bad one.
+// status is "revoked" once any revoke event is in the DAG.
+func HandlerAccountKey(c *gin.Context) {
+	ctx := c.Request.Context()
+	publicID := c.Param("public_id")
+
+	var accountID, userPub string
+	var createdAt time.Time
+	err := DB.QueryRowContext(ctx, `
+		SELECT id, user_pub, created_at FROM accounts WHERE public_id=$1
+	`, publicID).Scan(&accountID, &userPub, &createdAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "account_not_found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_lookup_account", "details": err.Error()})
+		return
+	}
+
+	var revoked bool
+	if err := DB.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM dag_nodes WHERE account_id=$1 AND event_type='revoke')
+	`, accountID).Scan(&revoked); err != nil {
+		c.JSON(500, gin.H{"error": "db_lookup_events", "details": err.Error()})
+		return
+	}
+
+	// keys in the order they were in use, ending with the current one
+	keys := []string{userPub}
+	var rotatedAt time.Time
+	rows, err := DB.QueryContext(ctx, `
+		SELECT COALESCE(payload->>'previous_user_pub',''), COALESCE(payload->>'user_pub',''), created_at
+		FROM dag_nodes
+		WHERE account_id=$1 AND event_type='rotate'
+		ORDER BY created_at, id
+	`, accountID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_lookup_events", "details": err.Error()})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var prev, next string
+		if err := rows.Scan(&prev, &next, &rotatedAt); err != nil {
+			c.JSON(500, gin.H{"error": "db_scan_event", "details": err.Error()})
+			return
+		}
+		if prev != "" {
+			keys = append(keys, prev)
+		}
+		if next != "" {
+			keys = append(keys, next)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(500, gin.H{"error": "db_lookup_events", "details": err.Error()})
+		return
+	}
+	current := keys[len(keys)-1]
+	seen := map[string]bool{current: true}
+	retired := []string{}
+	for _, k := range keys {
+		if !seen[k] {
+			seen[k] = true
+			retired = append(retired, k)
+		}
+	}
+
+	status := "active"
+	if revoked {
+		status = "revoked"
+	}
+	resp := gin.H{
+		"ok":           true,
+		"public_id":    publicID,
+		"user_pub":     current,
+		"status":       status,
+		"retired_keys": retired,
+		"created_at":   createdAt.UTC().Format(time.RFC3339),
+	}
+	if !rotatedAt.IsZero() {
+		resp["rotated_at"] = rotatedAt.UTC().Format(time.RFC3339)
+	}
+	c.JSON(200, resp)
+}
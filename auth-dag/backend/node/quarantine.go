@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"hackodisha/backend/membership"
+	tpm "hackodisha/backend/tpm"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// QuarantineQuorum is how many distinct global verifiers must have signed a
+// quarantine notice before this node applies it.
+var QuarantineQuorum = 3
+
+// Members is the configured node set (MEMBERSHIP_FILE): quarantine votes
+// only count from global verifiers under their pinned parent key, and local
+// nodes are identified by theirs.
+var Members *membership.Set
+
+// loadMembers reads MEMBERSHIP_FILE.
+func loadMembers() {
+	path := os.Getenv("MEMBERSHIP_FILE")
+	if path == "" {
+		log.Printf("membership: MEMBERSHIP_FILE not set, no quarantine notice will verify")
+		return
+	}
+	s, err := membership.Load(path)
+	if err != nil {
+		log.Fatal("load membership failed:", err)
+	}
+	Members = s
+}
+
+// quarantineVote and quarantineNotice mirror the global node's. Voter is a
+// TPM-signed envelope over "quarantine|node_id|action|epoch".
+type quarantineVote struct {
+	NodeID string `json:"node_id"`
+	Action string `json:"action"` // quarantine | clear
+	Epoch  int64  `json:"epoch"`
+	Voter  struct {
+		NodeID       string          `json:"node_id"`
+		ParentPubB64 string          `json:"parent_pub_b64"`
+		Attestation  json.RawMessage `json:"attestation"`
+		SigB64       string          `json:"sig_b64"`
+	} `json:"voter"`
+}
+
+type quarantineNotice struct {
+	NodeID string           `json:"node_id"`
+	Action string           `json:"action"`
+	Epoch  int64            `json:"epoch"`
+	Reason string           `json:"reason,omitempty"`
+	Votes  []quarantineVote `json:"votes"`
+}
+
+func quarantineMessage(nodeID, action string, epoch int64) []byte {
+	return []byte(fmt.Sprintf("quarantine|%s|%s|%d", nodeID, action, epoch))
+}
+
+// verifyQuarantineNotice requires QuarantineQuorum distinct configured
+// global verifiers to have signed the notice's decision under their pinned
+// parent keys.
+func verifyQuarantineNotice(n quarantineNotice) error {
+	if n.Action != "quarantine" && n.Action != "clear" {
+		return fmt.Errorf("unknown action %q", n.Action)
+	}
+	seen := map[string]bool{}
+	for _, v := range n.Votes {
+		if v.NodeID != n.NodeID || v.Action != n.Action || v.Epoch != n.Epoch || seen[v.Voter.NodeID] {
+			continue
+		}
+		m, ok := Members.GlobalMember(v.Voter.NodeID)
+		if !ok || m.Check(v.Voter.ParentPubB64) != nil {
+			continue
+		}
+		parentPub, err := base64.StdEncoding.DecodeString(m.ParentPubB64)
+		if err != nil {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(v.Voter.SigB64)
+		if err != nil {
+			continue
+		}
+		var att tpm.Attestation
+		if err := json.Unmarshal(v.Voter.Attestation, &att); err != nil {
+			continue
+		}
+		if tpm.VerifyChain(parentPub, quarantineMessage(v.NodeID, v.Action, v.Epoch), sig, att) != nil {
+			continue
+		}
+		seen[v.Voter.NodeID] = true
+	}
+	if len(seen) < QuarantineQuorum {
+		return fmt.Errorf("insufficient_votes: have %d need %d", len(seen), QuarantineQuorum)
+	}
+	return nil
+}
+
+// attestedIdentities returns the cluster_id/node_id keys, as quarantine
+// notices name them, of every configured local node pinned to parentPubB64.
+// The key comes from the TPM parent, so a node cannot shed its identity by
+// claiming another node_id or cluster_id.
+func attestedIdentities(parentPubB64 string) []string {
+	var ids []string
+	if Members == nil || parentPubB64 == "" {
+		return nil
+	}
+	for cluster, ms := range Members.Clusters {
+		for _, m := range ms {
+			if m.ParentPubB64 == parentPubB64 {
+				ids = append(ids, cluster+"/"+m.NodeID)
+			}
+		}
+	}
+	return ids
+}
+
+// isNodeQuarantined reports whether any of ids (cluster_id/node_id) is under
+// a quarantine notice.
+func isNodeQuarantined(ctx context.Context, ids []string) (bool, error) {
+	var q bool
+	err := DB.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM node_quarantine WHERE node_id = ANY($1) AND status='quarantined')
+	`, pq.Array(ids)).Scan(&q)
+	return q, err
+}
+
+// HandlerPeerQuarantine applies a quarantine or clear notice from the global
+// layer; stale epochs are acknowledged and ignored.
+func HandlerPeerQuarantine(c *gin.Context) {
+	var n quarantineNotice
+	if err := c.BindJSON(&n); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request", "details": err.Error()})
+		return
+	}
+	if err := verifyQuarantineNotice(n); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "quarantine_verification_failed", "reason": err.Error()})
+		return
+	}
+	status := "quarantined"
+	if n.Action == "clear" {
+		status = "clear"
+	}
+	res, err := DB.ExecContext(c.Request.Context(), `
+		INSERT INTO node_quarantine (node_id, status, epoch, reason, updated_at)
+		VALUES ($1,$2,$3,$4,NOW())
+		ON CONFLICT (node_id) DO UPDATE
+		  SET status=EXCLUDED.status, epoch=EXCLUDED.epoch, reason=EXCLUDED.reason, updated_at=NOW()
+		  WHERE node_quarantine.epoch < EXCLUDED.epoch
+	`, n.NodeID, status, n.Epoch, n.Reason)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_store_quarantine", "details": err.Error()})
+		return
+	}
+	if applied, _ := res.RowsAffected(); applied > 0 {
+		log.Printf("quarantine: node=%s status=%s epoch=%d", n.NodeID, status, n.Epoch)
+	}
+	c.JSON(200, gin.H{"ok": true})
+}
@@ -18,7 +18,14 @@ CREATE TABLE IF NOT EXISTS nodes_registry (
     attestation JSONB NOT NULL,      -- parent-signed attestation (with signed_payload_b64 inside)
     attestation_hash TEXT NOT NULL,  -- SHA256 of attestation JSON
     attestation_verified_at TIMESTAMPTZ, -- when monitor last verified
-    attestation_counter BIGINT       -- monotonic counter from attestation
+    attestation_counter BIGINT,      -- monotonic counter from attestation
+
+    -- liveness (set by the monitor's sweeper)
+    status_reason TEXT,              -- missed_heartbeats | verification_failures | quarantined | ...
+    status_changed_at TIMESTAMPTZ DEFAULT now(),
+    failed_heartbeats INT NOT NULL DEFAULT 0, -- consecutive failed verifications, of heartbeats signed by node_pub_key
+    last_failure_at TIMESTAMPTZ,
+    last_failure_reason TEXT
 );
 
 -- Ensure uniqueness of child public keys across cluster
@@ -41,9 +48,31 @@ CREATE TABLE IF NOT EXISTS nodes_registry_history (
     node_id TEXT NOT NULL REFERENCES nodes_registry(node_id),
     attestation JSONB NOT NULL,
     attestation_hash TEXT NOT NULL,
+    node_pub_key TEXT,
+    parent_pub_b64 TEXT,
+    attestation_counter BIGINT,
+    changes TEXT[] NOT NULL DEFAULT '{}', -- registered | node_pub_key | parent_pub_b64 | attestation_hash | attestation_counter | counter_rollback
     recorded_at TIMESTAMPTZ DEFAULT now()
 );
 
+CREATE INDEX IF NOT EXISTS idx_nodes_registry_history_node
+    ON nodes_registry_history (node_id, event_id);
+
+-------------------------------------------------
+-- Status Transitions (append-only)
+-------------------------------------------------
+CREATE TABLE IF NOT EXISTS node_status_transitions (
+    id BIGSERIAL PRIMARY KEY,
+    node_id TEXT NOT NULL REFERENCES nodes_registry(node_id),
+    from_status TEXT NOT NULL,
+    to_status TEXT NOT NULL,
+    reason TEXT,
+    changed_at TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_node_status_transitions_node
+    ON node_status_transitions (node_id, changed_at);
+
 -------------------------------------------------
 -- Tamper Alerts
 -- Alerts raised when signatures/attestations mismatch
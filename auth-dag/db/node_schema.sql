@@ -82,3 +82,17 @@ CREATE TABLE IF NOT EXISTS node_attestations (
   verified_at TIMESTAMPTZ,
   details JSONB
 );
+
+-------------------------------------------------
+-- Node Quarantine
+-- Notices from the global layer, each backed by QUARANTINE_QUORUM signed
+-- verifier votes. Attestation events from a quarantined node, identified by
+-- its pinned parent key, are refused until a later epoch clears it.
+-------------------------------------------------
+CREATE TABLE IF NOT EXISTS node_quarantine (
+  node_id TEXT PRIMARY KEY,          -- cluster_id/node_id as the global layer names it
+  status TEXT NOT NULL CHECK (status IN ('quarantined','clear')),
+  epoch BIGINT NOT NULL,
+  reason TEXT,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,137 @@
+// Package epochproof checks /api/epochs/proof/:local_dag_hash responses
+// offline: the global DAG entry's leaf, its audit path up to the epoch root,
+// and a quorum of global verifiers' TPM signatures over that root.
+package epochproof
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"hackodisha/backend/merkle"
+	tpm "hackodisha/backend/tpm"
+)
+
+// Signer is one verifier's signature envelope over an epoch.
+type Signer struct {
+	NodeID       string          `json:"node_id"`
+	ParentPubB64 string          `json:"parent_pub_b64"`
+	Attestation  json.RawMessage `json:"attestation"`
+	SigB64       string          `json:"sig_b64"`
+}
+
+// Epoch is the published head of one closed epoch. It covers the global DAG
+// entries created in [StartUnixMs, EndUnixMs), ordered by (created_at,
+// global_tx_hash). PrevRoot is the root of the previous published epoch, so
+// the latest epoch commits to the whole history.
+type Epoch struct {
+	Epoch       int64    `json:"epoch"`
+	StartUnixMs int64    `json:"start_unix_ms"`
+	EndUnixMs   int64    `json:"end_unix_ms"`
+	Size        uint64   `json:"size"`
+	Root        string   `json:"root"`
+	PrevRoot    string   `json:"prev_root"`
+	Signatures  []Signer `json:"signatures"`
+}
+
+// Proof is the body of GET /api/epochs/proof/:local_dag_hash.
+type Proof struct {
+	LocalDagHash string   `json:"local_dag_hash"`
+	GlobalTxHash string   `json:"global_tx_hash"`
+	LeafIndex    uint64   `json:"leaf_index"`
+	Path         []string `json:"path"` // hex, leaf up
+	Epoch        Epoch    `json:"epoch"`
+}
+
+// LeafData is what an epoch tree stores for a global DAG entry.
+func LeafData(globalTxHash string) []byte { return []byte("gtx|" + globalTxHash) }
+
+// Message is the exact message a verifier signs for an epoch.
+func Message(epoch, startUnixMs, endUnixMs int64, size uint64, rootHex, prevRootHex string) []byte {
+	return []byte(fmt.Sprintf("epoch|%d|%d|%d|%d|%s|%s", epoch, startUnixMs, endUnixMs, size, rootHex, prevRootHex))
+}
+
+var (
+	ErrBadPath      = errors.New("inclusion path does not lead to epoch root")
+	ErrNoQuorum     = errors.New("epoch root lacks a signing quorum")
+	ErrBadSignature = errors.New("epoch signature invalid")
+	ErrNoTrustedKey = errors.New("no trusted parent keys given")
+)
+
+// Verify checks p end to end. quorum is how many distinct trusted parent
+// keys must have signed the epoch; trustedParents works as in VerifyEpoch.
+func Verify(p Proof, quorum int, trustedParents []string) error {
+	root, err := hex.DecodeString(p.Epoch.Root)
+	if err != nil {
+		return fmt.Errorf("bad root: %w", err)
+	}
+	path := make([][]byte, len(p.Path))
+	for i, h := range p.Path {
+		if path[i], err = hex.DecodeString(h); err != nil {
+			return fmt.Errorf("bad path element %d: %w", i, err)
+		}
+	}
+	leaf := merkle.LeafHash(LeafData(p.GlobalTxHash))
+	if !merkle.VerifyInclusion(leaf, p.LeafIndex, p.Epoch.Size, path, root) {
+		return ErrBadPath
+	}
+	return VerifyEpoch(p.Epoch, quorum, trustedParents)
+}
+
+// VerifyEpoch counts the signers with a valid chain over e under one of
+// trustedParents, the base64 TPM parent keys of the global verifiers (e.g.
+// membership.ParentKeys). Signers are counted once per parent key, whatever
+// node_id they claim. A chain that is merely internally valid proves
+// nothing about who signed it, so an empty list is rejected.
+func VerifyEpoch(e Epoch, quorum int, trustedParents []string) error {
+	msg := Message(e.Epoch, e.StartUnixMs, e.EndUnixMs, e.Size, e.Root, e.PrevRoot)
+	trusted := map[string]bool{}
+	for _, k := range trustedParents {
+		if k != "" {
+			trusted[k] = true
+		}
+	}
+	if len(trusted) == 0 {
+		return ErrNoTrustedKey
+	}
+	signers := map[string]bool{} // by parent key
+	var lastErr error
+	for _, s := range e.Signatures {
+		if signers[s.ParentPubB64] || !trusted[s.ParentPubB64] {
+			continue
+		}
+		if err := verifySigner(s, msg); err != nil {
+			lastErr = err
+			continue
+		}
+		signers[s.ParentPubB64] = true
+	}
+	if len(signers) < quorum {
+		if lastErr != nil {
+			return fmt.Errorf("%w: %d/%d (%v)", ErrNoQuorum, len(signers), quorum, lastErr)
+		}
+		return fmt.Errorf("%w: %d/%d", ErrNoQuorum, len(signers), quorum)
+	}
+	return nil
+}
+
+func verifySigner(s Signer, msg []byte) error {
+	parentPub, err := base64.StdEncoding.DecodeString(s.ParentPubB64)
+	if err != nil {
+		return fmt.Errorf("%w: parent key: %v", ErrBadSignature, err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(s.SigB64)
+	if err != nil {
+		return fmt.Errorf("%w: signature: %v", ErrBadSignature, err)
+	}
+	var att tpm.Attestation
+	if err := json.Unmarshal(s.Attestation, &att); err != nil {
+		return fmt.Errorf("%w: attestation: %v", ErrBadSignature, err)
+	}
+	if err := tpm.VerifyChain(parentPub, msg, sig, att); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadSignature, err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+// Package membership loads the configured node set (MEMBERSHIP_FILE): the
+// nodes of every local cluster, the global verifiers and the auth nodes, each
+// pinned to the base64 TPM parent key its signatures must chain to. Anything
+// signed by a node outside the set, or under a different parent key, is not
+// counted towards any quorum.
+package membership
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	ErrNotMember        = errors.New("signer is not a configured member")
+	ErrParentKeyMissing = errors.New("member has no configured parent key")
+	ErrParentKeyPinned  = errors.New("parent key does not match the member's pinned key")
+)
+
+// Member is one configured node. Address is its base URL, used wherever we
+// call the node back.
+type Member struct {
+	NodeID       string `json:"node_id"`
+	ParentPubB64 string `json:"parent_pub_b64"`
+	Address      string `json:"address,omitempty"`
+}
+
+// Set is the whole membership file.
+type Set struct {
+	Clusters map[string][]Member `json:"clusters"`
+	Global   []Member            `json:"global"`
+	Auth     []Member            `json:"auth"`
+}
+
+// Load reads and validates a membership file. Members may be listed without
+// a parent key (e.g. before their first boot); they are known but not
+// trusted until one is filled in.
+func Load(path string) (*Set, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Set
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("membership: %w", err)
+	}
+	for cluster, ms := range s.Clusters {
+		if err := validate("cluster "+cluster, ms); err != nil {
+			return nil, err
+		}
+	}
+	if err := validate("global", s.Global); err != nil {
+		return nil, err
+	}
+	if err := validate("auth", s.Auth); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func validate(group string, ms []Member) error {
+	seen := map[string]bool{}
+	for _, m := range ms {
+		if m.NodeID == "" {
+			return fmt.Errorf("membership: %s: member without node_id", group)
+		}
+		if seen[m.NodeID] {
+			return fmt.Errorf("membership: %s: duplicate node_id %q", group, m.NodeID)
+		}
+		seen[m.NodeID] = true
+		if m.ParentPubB64 == "" {
+			continue
+		}
+		pub, err := base64.StdEncoding.DecodeString(m.ParentPubB64)
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			return fmt.Errorf("membership: %s: bad parent_pub_b64 for %q", group, m.NodeID)
+		}
+	}
+	return nil
+}
+
+func find(ms []Member, nodeID string) (Member, bool) {
+	for _, m := range ms {
+		if m.NodeID == nodeID {
+			return m, true
+		}
+	}
+	return Member{}, false
+}
+
+// Cluster returns the members of a local cluster. A nil Set has no members.
+func (s *Set) Cluster(clusterID string) []Member {
+	if s == nil {
+		return nil
+	}
+	return s.Clusters[clusterID]
+}
+
+// ClusterMember looks up nodeID in clusterID.
+func (s *Set) ClusterMember(clusterID, nodeID string) (Member, bool) {
+	return find(s.Cluster(clusterID), nodeID)
+}
+
+// GlobalMember looks up a global verifier.
+func (s *Set) GlobalMember(nodeID string) (Member, bool) {
+	if s == nil {
+		return Member{}, false
+	}
+	return find(s.Global, nodeID)
+}
+
+// AuthMember looks up an auth node.
+func (s *Set) AuthMember(nodeID string) (Member, bool) {
+	if s == nil {
+		return Member{}, false
+	}
+	return find(s.Auth, nodeID)
+}
+
+// Check returns nil if parentPubB64 is m's pinned parent key.
+func (m Member) Check(parentPubB64 string) error {
+	if m.ParentPubB64 == "" {
+		return ErrParentKeyMissing
+	}
+	if m.ParentPubB64 != parentPubB64 {
+		return ErrParentKeyPinned
+	}
+	return nil
+}
+
+// ParentKeys lists the configured parent keys of ms, skipping members that
+// have none.
+func ParentKeys(ms []Member) []string {
+	var out []string
+	for _, m := range ms {
+		if m.ParentPubB64 != "" {
+			out = append(out, m.ParentPubB64)
+		}
+	}
+	return out
+}
@@ -8,13 +8,16 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"hackodisha/backend/membership"
 	tpm "hackodisha/backend/tpm"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 // Heartbeat request payload (includes parent_pub_b64)
@@ -40,6 +43,44 @@ type attestation struct {
 	SignedPayloadB64 string `json:"signed_payload_b64,omitempty"`
 }
 
+// Quarantine notice pushed by the global layer (matches node quarantineNotice).
+// Each vote's voter is a TPM-signed envelope over "quarantine|node|action|epoch".
+type quarantineVote struct {
+	NodeID string `json:"node_id"`
+	Action string `json:"action"`
+	Epoch  int64  `json:"epoch"`
+	Voter  struct {
+		NodeID       string          `json:"node_id"`
+		ParentPubB64 string          `json:"parent_pub_b64"`
+		Attestation  json.RawMessage `json:"attestation"`
+		SigB64       string          `json:"sig_b64"`
+	} `json:"voter"`
+}
+
+// One nodes_registry_history row. Changes lists what differs from the
+// previous attestation: registered, node_pub_key, parent_pub_b64,
+// attestation_hash, attestation_counter, counter_rollback.
+type historyEvent struct {
+	EventID            int64           `json:"event_id"`
+	Attestation        json.RawMessage `json:"attestation"`
+	AttestationHash    string          `json:"attestation_hash"`
+	NodePubKey         string          `json:"node_pub_key"`
+	ParentPubB64       string          `json:"parent_pub_b64"`
+	AttestationCounter *int64          `json:"attestation_counter,omitempty"`
+	Changes            []string        `json:"changes"`
+	KeyChanged         bool            `json:"key_changed"`
+	RecordedAt         time.Time       `json:"recorded_at"`
+}
+
+type quarantineNotice struct {
+	NodeID  string           `json:"node_id"` // cluster_id/node_id
+	DagType string           `json:"dag_type"`
+	Action  string           `json:"action"` // quarantine | clear
+	Epoch   int64            `json:"epoch"`
+	Reason  string           `json:"reason,omitempty"`
+	Votes   []quarantineVote `json:"votes"`
+}
+
 func main() {
 	// TPM init (monitor optionally uses its own TPM parent for reference)
 	storage := getenvDefault("FAKE_TPM_STORAGE", "/data/tpm")
@@ -59,12 +100,44 @@ func main() {
 		log.Fatalf("postgres unreachable: %v", err)
 	}
 
+	quarantineQuorum := 3
+	if v, err := strconv.Atoi(os.Getenv("QUARANTINE_QUORUM")); err == nil {
+		quarantineQuorum = v
+	}
+
+	// quarantine votes only count from configured global verifiers
+	var members *membership.Set
+	if path := os.Getenv("MEMBERSHIP_FILE"); path != "" {
+		if members, err = membership.Load(path); err != nil {
+			log.Fatalf("load membership failed: %v", err)
+		}
+	} else {
+		log.Printf("membership: MEMBERSHIP_FILE not set, no quarantine notice will verify")
+	}
+
+	// liveness: unreachable after missedHeartbeats intervals without a valid
+	// heartbeat, suspect after suspectFailures consecutive failed ones
+	heartbeatInterval := 5 * time.Second
+	if v, err := time.ParseDuration(os.Getenv("HEARTBEAT_INTERVAL")); err == nil && v > 0 {
+		heartbeatInterval = v
+	}
+	missedHeartbeats := 3
+	if v, err := strconv.Atoi(os.Getenv("MISSED_HEARTBEATS")); err == nil && v > 0 {
+		missedHeartbeats = v
+	}
+	suspectFailures := 3
+	if v, err := strconv.Atoi(os.Getenv("SUSPECT_AFTER_FAILURES")); err == nil && v > 0 {
+		suspectFailures = v
+	}
+
 	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		log.Fatalf("open db failed: %v", err)
 	}
 	defer db.Close()
 
+	go livenessSweeper(db, heartbeatInterval, missedHeartbeats, suspectFailures)
+
 	r := gin.Default()
 
 	r.GET("/health", func(c *gin.Context) {
@@ -86,6 +159,26 @@ func main() {
 		verified := false
 		reason := ""
 
+		// count failed verifications against a registered node; the sweeper
+		// turns a run of them into suspect. Only a heartbeat signed by the
+		// node's registered key counts, so nobody can fail one on its behalf.
+		defer func() {
+			if reason == "" || reason == "db_upsert_failed" {
+				return
+			}
+			if !signedByRegisteredKey(context.Background(), db, hb) {
+				log.Printf("heartbeat: node=%s failure_ignored=unauthenticated", node)
+				return
+			}
+			if _, err := db.ExecContext(context.Background(), `
+    UPDATE nodes_registry
+       SET failed_heartbeats=failed_heartbeats+1, last_failure_at=NOW(), last_failure_reason=$2
+     WHERE node_id=$1
+`, node, reason); err != nil {
+				log.Printf("heartbeat: node=%s failure_record_failed=%v", node, err)
+			}
+		}()
+
 		// 1) attestation hash check
 		calcHash := sha256.Sum256(hb.Attestation)
 		calcHashHex := fmt.Sprintf("%x", calcHash[:])
@@ -190,27 +283,26 @@ func main() {
 			return
 		}
 
-		// 6) Upsert into DB (include parent_pub_b64 and counter)
-		_, err = db.ExecContext(context.Background(), `
-    INSERT INTO nodes_registry (
-        node_id, dag_type, address, status,
-        node_pub_key, parent_pub_b64, attestation, attestation_hash,
-        attestation_verified_at, attestation_counter, last_seen
-    )
-    VALUES ($1,$2,$3,$4,$5,$6,$7::jsonb,$8,NOW(),$9,NOW())
-    ON CONFLICT (node_id) DO UPDATE
-      SET address=$3, status=$4, node_pub_key=$5, parent_pub_b64=$6,
-          attestation=$7::jsonb, attestation_hash=$8,
-          attestation_verified_at=NOW(), attestation_counter=$9, last_seen=NOW()
-`, hb.NodeID, hb.DagType, hb.Address, hb.Status,
-			hb.NodePubKey, hb.ParentPubB64, string(hb.Attestation), hb.AttestationHash, att.Counter)
-		if err != nil {
+		// 6) Upsert into DB (include parent_pub_b64 and counter), keeping the
+		// previous attestation in nodes_registry_history if it changed
+		if err := upsertNode(context.Background(), db, hb, att.Counter); err != nil {
 			reason = "db_upsert_failed"
 			log.Printf("heartbeat: node=%s verified=%v reason=%s", node, verified, reason)
 			c.JSON(500, gin.H{"error": reason})
 			return
 		}
 
+		// a valid heartbeat brings the node back, unless it is quarantined
+		// (only a clear notice lifts that)
+		var status, statusReason string
+		if err := db.QueryRowContext(context.Background(), `
+    SELECT status, COALESCE(status_reason,'') FROM nodes_registry WHERE node_id=$1
+`, hb.NodeID).Scan(&status, &statusReason); err == nil && status != "healthy" && statusReason != "quarantined" {
+			if _, _, err := setNodeStatus(context.Background(), db, hb.NodeID, "", "healthy", "heartbeat_ok"); err != nil {
+				log.Printf("heartbeat: node=%s revert_failed=%v", node, err)
+			}
+		}
+
 		// Success — single concise log
 		verified = true
 		log.Printf("heartbeat: node=%s verified=%v", node, verified)
@@ -221,6 +313,110 @@ func main() {
 		})
 	})
 
+	// Quarantine notice from the global layer — marks the node suspect (or
+	// healthy again on clear). Heartbeats do not lift a suspect status.
+	r.POST("/quarantine", func(c *gin.Context) {
+		var n quarantineNotice
+		if err := c.BindJSON(&n); err != nil {
+			c.JSON(400, gin.H{"error": "bad quarantine json"})
+			return
+		}
+		if err := verifyQuarantineNotice(n, members, quarantineQuorum); err != nil {
+			log.Printf("quarantine: node=%s verified=false reason=%v", n.NodeID, err)
+			c.JSON(401, gin.H{"error": "quarantine_verification_failed", "reason": err.Error()})
+			return
+		}
+
+		// registry rows hold the bare node id
+		node := n.NodeID
+		if i := strings.LastIndex(node, "/"); i >= 0 {
+			node = node[i+1:]
+		}
+		status, statusReason := "suspect", "quarantined"
+		if n.Action == "clear" {
+			status, statusReason = "healthy", "quarantine_cleared"
+		}
+		_, registered, err := setNodeStatus(c.Request.Context(), db, node, n.DagType, status, statusReason)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db_update_failed"})
+			return
+		}
+		if registered && n.Action == "quarantine" {
+			evidence, _ := json.Marshal(gin.H{"node_id": n.NodeID, "epoch": n.Epoch, "votes": len(n.Votes)})
+			if _, err := db.ExecContext(c.Request.Context(), `
+    INSERT INTO tamper_alerts (offending_node, description, evidence)
+    VALUES ($1,$2,$3::jsonb)
+`, node, "quarantined: "+n.Reason, string(evidence)); err != nil {
+				log.Printf("quarantine: node=%s alert_failed=%v", node, err)
+			}
+		}
+		log.Printf("quarantine: node=%s status=%s registered=%v", node, status, registered)
+		c.JSON(200, gin.H{"ok": true, "registered": registered})
+	})
+
+	// Attestation history of one node, newest first. ?before=<event_id> pages
+	// (pass next_before), ?limit= caps at 500. key_changed marks a new node or
+	// parent key.
+	r.GET("/nodes/:node_id/history", func(c *gin.Context) {
+		node := c.Param("node_id")
+		limit := 50
+		if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 && v <= 500 {
+			limit = v
+		}
+		var before int64
+		if v := c.Query("before"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 {
+				c.JSON(400, gin.H{"error": "invalid_before"})
+				return
+			}
+			before = n
+		}
+		rows, err := db.QueryContext(c.Request.Context(), `
+    SELECT event_id, attestation, attestation_hash, COALESCE(node_pub_key,''), COALESCE(parent_pub_b64,''),
+           attestation_counter, changes, recorded_at
+      FROM nodes_registry_history
+     WHERE node_id=$1 AND ($2 = 0 OR event_id < $2)
+     ORDER BY event_id DESC
+     LIMIT $3
+`, node, before, limit)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db_query_failed"})
+			return
+		}
+		defer rows.Close()
+		out := []historyEvent{}
+		for rows.Next() {
+			var e historyEvent
+			var att string
+			var counter sql.NullInt64
+			if err := rows.Scan(&e.EventID, &att, &e.AttestationHash, &e.NodePubKey, &e.ParentPubB64,
+				&counter, pq.Array(&e.Changes), &e.RecordedAt); err != nil {
+				c.JSON(500, gin.H{"error": "db_scan_failed"})
+				return
+			}
+			e.Attestation = json.RawMessage(att)
+			if counter.Valid {
+				e.AttestationCounter = &counter.Int64
+			}
+			for _, ch := range e.Changes {
+				if ch == "node_pub_key" || ch == "parent_pub_b64" {
+					e.KeyChanged = true
+				}
+			}
+			out = append(out, e)
+		}
+		if err := rows.Err(); err != nil {
+			c.JSON(500, gin.H{"error": "db_query_failed"})
+			return
+		}
+		resp := gin.H{"ok": true, "node_id": node, "history": out}
+		if len(out) == limit {
+			resp["next_before"] = out[len(out)-1].EventID
+		}
+		c.JSON(200, resp)
+	})
+
 	port := getenvDefault("PORT", "8080")
 	log.Fatal(r.Run(":" + port))
 }
@@ -236,6 +432,211 @@ func getenvDefault(key, def string) string {
 	return def
 }
 
+// verifyQuarantineNotice requires quorum distinct configured global
+// verifiers with a valid TPM chain, under their pinned parent key, over the
+// notice's decision.
+func verifyQuarantineNotice(n quarantineNotice, members *membership.Set, quorum int) error {
+	if n.Action != "quarantine" && n.Action != "clear" {
+		return fmt.Errorf("unknown action %q", n.Action)
+	}
+	msg := []byte(fmt.Sprintf("quarantine|%s|%s|%d", n.NodeID, n.Action, n.Epoch))
+	seen := map[string]bool{}
+	for _, v := range n.Votes {
+		if v.NodeID != n.NodeID || v.Action != n.Action || v.Epoch != n.Epoch || seen[v.Voter.NodeID] {
+			continue
+		}
+		m, ok := members.GlobalMember(v.Voter.NodeID)
+		if !ok || m.Check(v.Voter.ParentPubB64) != nil {
+			continue
+		}
+		parentPub, err := base64.StdEncoding.DecodeString(m.ParentPubB64)
+		if err != nil {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(v.Voter.SigB64)
+		if err != nil {
+			continue
+		}
+		var att tpm.Attestation
+		if err := json.Unmarshal(v.Voter.Attestation, &att); err != nil {
+			continue
+		}
+		if tpm.VerifyChain(parentPub, msg, sig, att) != nil {
+			continue
+		}
+		seen[v.Voter.NodeID] = true
+	}
+	if len(seen) < quorum {
+		return fmt.Errorf("insufficient_votes: have %d need %d", len(seen), quorum)
+	}
+	return nil
+}
+
+// signedByRegisteredKey reports whether hb's child signature verifies under
+// the node key registered for hb.NodeID, i.e. the heartbeat came from that
+// node whatever else is wrong with it.
+func signedByRegisteredKey(ctx context.Context, db *sql.DB, hb heartbeatPayload) bool {
+	var pubB64 string
+	if err := db.QueryRowContext(ctx, `
+    SELECT node_pub_key FROM nodes_registry WHERE node_id=$1
+`, hb.NodeID).Scan(&pubB64); err != nil {
+		return false
+	}
+	pub, err := base64.StdEncoding.DecodeString(pubB64)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(hb.ChildSigB64)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(pub, []byte("heartbeat:"+hb.NodeID), sig)
+}
+
+// upsertNode stores a verified heartbeat and appends a history row when the
+// node is new or its attestation hash, keys or counter changed.
+func upsertNode(ctx context.Context, db *sql.DB, hb heartbeatPayload, counter uint64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var prevKey, prevParent, prevHash string
+	var prevCounter sql.NullInt64
+	var changes []string
+	err = tx.QueryRowContext(ctx, `
+    SELECT node_pub_key, parent_pub_b64, attestation_hash, attestation_counter
+      FROM nodes_registry WHERE node_id=$1 FOR UPDATE
+`, hb.NodeID).Scan(&prevKey, &prevParent, &prevHash, &prevCounter)
+	switch {
+	case err == sql.ErrNoRows:
+		changes = []string{"registered"}
+	case err != nil:
+		return err
+	default:
+		if prevKey != hb.NodePubKey {
+			changes = append(changes, "node_pub_key")
+		}
+		if prevParent != hb.ParentPubB64 {
+			changes = append(changes, "parent_pub_b64")
+		}
+		if prevHash != hb.AttestationHash {
+			changes = append(changes, "attestation_hash")
+		}
+		if !prevCounter.Valid || uint64(prevCounter.Int64) != counter {
+			changes = append(changes, "attestation_counter")
+			if prevCounter.Valid && counter < uint64(prevCounter.Int64) {
+				changes = append(changes, "counter_rollback")
+			}
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+    INSERT INTO nodes_registry (
+        node_id, dag_type, address, status,
+        node_pub_key, parent_pub_b64, attestation, attestation_hash,
+        attestation_verified_at, attestation_counter, last_seen
+    )
+    VALUES ($1,$2,$3,$4,$5,$6,$7::jsonb,$8,NOW(),$9,NOW())
+    ON CONFLICT (node_id) DO UPDATE
+      SET address=$3, node_pub_key=$5, parent_pub_b64=$6,
+          attestation=$7::jsonb, attestation_hash=$8,
+          attestation_verified_at=NOW(), attestation_counter=$9, last_seen=NOW(),
+          failed_heartbeats=0
+`, hb.NodeID, hb.DagType, hb.Address, hb.Status,
+		hb.NodePubKey, hb.ParentPubB64, string(hb.Attestation), hb.AttestationHash, counter); err != nil {
+		return err
+	}
+	if len(changes) > 0 {
+		if _, err := tx.ExecContext(ctx, `
+    INSERT INTO nodes_registry_history (
+        node_id, attestation, attestation_hash, node_pub_key, parent_pub_b64, attestation_counter, changes
+    )
+    VALUES ($1,$2::jsonb,$3,$4,$5,$6,$7)
+`, hb.NodeID, string(hb.Attestation), hb.AttestationHash, hb.NodePubKey, hb.ParentPubB64, counter, pq.Array(changes)); err != nil {
+			return err
+		}
+		log.Printf("history: node=%s changes=%s", hb.NodeID, strings.Join(changes, ","))
+	}
+	return tx.Commit()
+}
+
+// setNodeStatus moves a node (of dagType, unless empty) to status and records
+// the transition if the status actually changed. It returns whether it
+// changed and whether the node is registered.
+func setNodeStatus(ctx context.Context, db *sql.DB, nodeID, dagType, status, reason string) (bool, bool, error) {
+	var from string
+	err := db.QueryRowContext(ctx, `
+    WITH prev AS (
+        SELECT node_id, status FROM nodes_registry
+         WHERE node_id=$1 AND ($2 = '' OR dag_type=$2)
+         FOR UPDATE
+    ), upd AS (
+        UPDATE nodes_registry n
+           SET status=$3, status_reason=$4,
+               status_changed_at=CASE WHEN prev.status<>$3 THEN NOW() ELSE n.status_changed_at END
+          FROM prev WHERE n.node_id=prev.node_id
+        RETURNING n.node_id, prev.status AS from_status
+    ), ins AS (
+        INSERT INTO node_status_transitions (node_id, from_status, to_status, reason)
+        SELECT node_id, from_status, $3, $4 FROM upd WHERE from_status<>$3
+    )
+    SELECT from_status FROM upd
+`, nodeID, dagType, status, reason).Scan(&from)
+	if err == sql.ErrNoRows {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	if from != status {
+		log.Printf("status: node=%s %s->%s reason=%s", nodeID, from, status, reason)
+	}
+	return from != status, true, nil
+}
+
+// livenessSweeper runs every heartbeat interval: nodes silent for missed
+// intervals become unreachable, nodes with suspectFailures consecutive failed
+// heartbeats become suspect. Valid heartbeats revert both (see /heartbeat).
+func livenessSweeper(db *sql.DB, interval time.Duration, missed, suspectFailures int) {
+	for {
+		time.Sleep(interval)
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		rows, err := db.QueryContext(ctx, `
+    SELECT node_id,
+           CASE WHEN failed_heartbeats >= $2 THEN 'suspect' ELSE 'unreachable' END
+      FROM nodes_registry
+     WHERE (failed_heartbeats >= $2 AND status <> 'suspect')
+        OR (status = 'healthy' AND last_seen < NOW() - $1 * interval '1 millisecond')
+`, (time.Duration(missed) * interval).Milliseconds(), suspectFailures)
+		if err != nil {
+			log.Printf("sweeper: query failed: %v", err)
+			cancel()
+			continue
+		}
+		type change struct{ node, status string }
+		var changes []change
+		for rows.Next() {
+			var ch change
+			if err := rows.Scan(&ch.node, &ch.status); err == nil {
+				changes = append(changes, ch)
+			}
+		}
+		rows.Close()
+		for _, ch := range changes {
+			reason := "missed_heartbeats"
+			if ch.status == "suspect" {
+				reason = "verification_failures"
+			}
+			if _, _, err := setNodeStatus(ctx, db, ch.node, "", ch.status, reason); err != nil {
+				log.Printf("sweeper: node=%s status=%s failed: %v", ch.node, ch.status, err)
+			}
+		}
+		cancel()
+	}
+}
+
 func waitForPostgres(dsn string, timeout time.Duration) error {
 	deadline := time.Now().Add(timeout)
 	var lastErr error
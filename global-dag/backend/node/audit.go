@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"hackodisha/backend/txproof"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Audits: every AuditInterval we take up to AuditBatchSize validated
+// settlements we have not audited yet, oldest first, so every settlement is
+// audited in turn. We challenge the local node that settled each, at its
+// configured membership address, to return the tx, its signatures and
+// quorum votes, and its tx log inclusion proof, signed over our nonce. A
+// wrong answer raises audit_failed; no answer to any of AuditAttempts tries
+// of AuditTimeout each raises audit_timeout. Both are node alerts and count
+// towards quarantine.
+var (
+	AuditInterval  = time.Minute // 0 disables audits
+	AuditBatchSize = 20
+	AuditTimeout   = 10 * time.Second
+	AuditAttempts  = 3
+)
+
+// auditChallenge and auditResponse mirror the local node's.
+type auditChallenge struct {
+	LocalDagHash string         `json:"local_dag_hash"`
+	Nonce        string         `json:"nonce"`
+	Auditor      signedEnvelope `json:"auditor"`
+}
+
+type auditResponse struct {
+	Item      settlementItem `json:"item"`
+	Proof     txproof.Proof  `json:"proof"`
+	Responder signedEnvelope `json:"responder"`
+}
+
+func auditMessage(localDagHash, nonce string) []byte {
+	return []byte(fmt.Sprintf("audit|%s|%s", localDagHash, nonce))
+}
+
+func auditResponseMessage(localDagHash, nonce string, txBody []byte) []byte {
+	h := sha256.Sum256(txBody)
+	return []byte(fmt.Sprintf("audit-response|%s|%s|%s", localDagHash, nonce, hex.EncodeToString(h[:])))
+}
+
+// auditLoop challenges the next batch of unaudited settlements every
+// interval.
+func auditLoop(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		rows, err := DB.QueryContext(ctx, `
+			SELECT s.local_dag_hash FROM global_settlements s
+			WHERE s.status='validated'
+			  AND NOT EXISTS (SELECT 1 FROM global_audits a WHERE a.local_dag_hash=s.local_dag_hash AND a.auditor=$1)
+			ORDER BY s.created_at, s.local_dag_hash
+			LIMIT $2
+		`, NodeID, AuditBatchSize)
+		if err != nil {
+			log.Printf("audit: batch failed: %v", err)
+			cancel()
+			continue
+		}
+		var batch []string
+		for rows.Next() {
+			var h string
+			if err := rows.Scan(&h); err == nil {
+				batch = append(batch, h)
+			}
+		}
+		rows.Close()
+		cancel()
+		// each challenge bounds itself; a shared deadline would turn our
+		// slowness into the node's timeouts
+		for _, h := range batch {
+			if _, err := auditSettlement(context.Background(), h); err != nil {
+				log.Printf("audit: settlement=%s audit_error=%v", h, err)
+			}
+		}
+	}
+}
+
+// auditRecord is one row of global_audits.
+type auditRecord struct {
+	ID              int64      `json:"id"`
+	LocalDagHash    string     `json:"local_dag_hash"`
+	OriginatingNode string     `json:"originating_node"`
+	Auditor         string     `json:"auditor"`
+	Nonce           string     `json:"nonce"`
+	Status          string     `json:"status"` // open | passed | failed | timeout
+	Reason          string     `json:"reason,omitempty"`
+	ChallengedAt    time.Time  `json:"challenged_at"`
+	AnsweredAt      *time.Time `json:"answered_at,omitempty"`
+}
+
+// auditSettlement challenges the originating node of localDagHash once and
+// records the outcome. The error is for our own failures only.
+func auditSettlement(ctx context.Context, localDagHash string) (auditRecord, error) {
+	rec := auditRecord{LocalDagHash: localDagHash, Auditor: NodeID, Status: "open"}
+	var proofJSON []byte
+	err := DB.QueryRowContext(ctx, `
+		SELECT originating_node, validation_proof FROM global_settlements WHERE local_dag_hash=$1
+	`, localDagHash).Scan(&rec.OriginatingNode, &proofJSON)
+	if err != nil {
+		return rec, err
+	}
+	address := localNodeAddress(rec.OriginatingNode)
+	if address == "" {
+		return rec, fmt.Errorf("no member address for %s", rec.OriginatingNode)
+	}
+	var stored settlementProof
+	if err := json.Unmarshal(proofJSON, &stored); err != nil {
+		return rec, err
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return rec, err
+	}
+	rec.Nonce = hex.EncodeToString(nonce)
+	env, err := signEnvelope(auditMessage(localDagHash, rec.Nonce))
+	if err != nil {
+		return rec, err
+	}
+	if err := DB.QueryRowContext(ctx, `
+		INSERT INTO global_audits (local_dag_hash, originating_node, auditor, nonce, status, challenged_at)
+		VALUES ($1,$2,$3,$4,'open',NOW())
+		RETURNING id, challenged_at
+	`, localDagHash, rec.OriginatingNode, NodeID, rec.Nonce).Scan(&rec.ID, &rec.ChallengedAt); err != nil {
+		return rec, err
+	}
+
+	resp, outcome := challengeNode(ctx, address, auditChallenge{LocalDagHash: localDagHash, Nonce: rec.Nonce, Auditor: env})
+	if err := ctx.Err(); err != nil {
+		// we gave up, not the node: drop the challenge so it is retried
+		_, _ = DB.ExecContext(context.Background(), `DELETE FROM global_audits WHERE id=$1`, rec.ID)
+		return rec, err
+	}
+	switch outcome {
+	case "timeout", "failed":
+		rec.Status, rec.Reason = outcome, string(resp)
+	default:
+		var ar auditResponse
+		if err := json.Unmarshal(resp, &ar); err != nil {
+			rec.Status, rec.Reason = "failed", "invalid_response_json"
+		} else if err := verifyAuditResponse(ctx, stored, rec.Nonce, ar); err != nil {
+			rec.Status, rec.Reason = "failed", err.Error()
+		} else {
+			rec.Status = "passed"
+		}
+	}
+
+	var answeredAt time.Time
+	if err := DB.QueryRowContext(ctx, `
+		UPDATE global_audits SET status=$2, reason=NULLIF($3,''), answered_at=NOW() WHERE id=$1
+		RETURNING answered_at
+	`, rec.ID, rec.Status, rec.Reason).Scan(&answeredAt); err != nil {
+		return rec, err
+	}
+	rec.AnsweredAt = &answeredAt
+	log.Printf("audit: settlement=%s node=%s status=%s reason=%s", localDagHash, rec.OriginatingNode, rec.Status, rec.Reason)
+	if rec.Status != "passed" {
+		raiseNodeAlert(ctx, rec.OriginatingNode, localDagHash, "audit_"+rec.Status, map[string]any{
+			"auditor": NodeID,
+			"nonce":   rec.Nonce,
+			"reason":  rec.Reason,
+		})
+	}
+	return rec, nil
+}
+
+// challengeNode posts ch to /peer/audit at the local node's address. It
+// returns the response body with an empty outcome once the node answered
+// 200, or a reason with outcome "timeout" (no answer in AuditAttempts tries)
+// or "failed" (the node refused). Transport errors and 5xx are retried with
+// backoff; the same nonce may be answered more than once.
+func challengeNode(ctx context.Context, address string, ch auditChallenge) ([]byte, string) {
+	body, _ := json.Marshal(ch)
+	url := strings.TrimRight(address, "/") + "/peer/audit"
+	reason := "no_attempt"
+	backoff := time.Second
+	for i := 0; i < AuditAttempts; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return []byte(reason), "timeout"
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		resp, status, err := postAuditChallenge(ctx, url, body)
+		switch {
+		case err != nil:
+			reason = err.Error()
+		case status >= 500:
+			reason = fmt.Sprintf("status_%d", status)
+		case status != http.StatusOK:
+			return []byte(fmt.Sprintf("status_%d", status)), "failed"
+		default:
+			return resp, ""
+		}
+	}
+	return []byte(reason), "timeout"
+}
+
+// postAuditChallenge makes one attempt of challengeNode, bounded by
+// AuditTimeout.
+func postAuditChallenge(ctx context.Context, url string, body []byte) ([]byte, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, AuditTimeout)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, errors.New("net_error")
+	}
+	defer resp.Body.Close()
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(resp.Body); err != nil {
+		return nil, 0, errors.New("read_error")
+	}
+	return buf.Bytes(), resp.StatusCode, nil
+}
+
+// verifyAuditResponse checks an answer against what the node settled: the
+// same tx body, a valid node signature and quorum, an inclusion proof signed
+// by the same node under its configured parent key, and the node's
+// signature over our nonce.
+func verifyAuditResponse(ctx context.Context, stored settlementProof, nonce string, ar auditResponse) error {
+	hash := stored.Item.TxHash
+	if responder := localNodeID(stored.ClusterID, ar.Responder.NodeID); responder != stored.OriginatingNode {
+		return fmt.Errorf("responder_mismatch: %s", responder)
+	}
+	if err := verifyLocalEnvelope(ctx, stored.ClusterID, ar.Responder, auditResponseMessage(hash, nonce, ar.Item.TxBody)); err != nil {
+		return fmt.Errorf("responder_signature: %w", err)
+	}
+	if ar.Item.TxHash != hash || !bytes.Equal(ar.Item.TxBody, stored.Item.TxBody) {
+		return fmt.Errorf("tx_body_mismatch")
+	}
+	p := stored
+	p.Item = ar.Item
+	if err := verifySettlementProof(ctx, p); err != nil {
+		return err
+	}
+	if ar.Proof.TxHash != hash || ar.Proof.SignedRoot.Signer.NodeID != ar.Responder.NodeID {
+		return fmt.Errorf("inclusion_proof_mismatch")
+	}
+	m, ok := Members.ClusterMember(stored.ClusterID, ar.Responder.NodeID)
+	if !ok {
+		return fmt.Errorf("responder_not_member")
+	}
+	if err := txproof.Verify(ar.Proof, []string{m.ParentPubB64}); err != nil {
+		return fmt.Errorf("inclusion_proof: %w", err)
+	}
+	return nil
+}
+
+// === Handlers ===
+
+// HandlerListAudits lists our audits, newest first; ?status= and
+// ?node_id= (cluster_id/node_id) filter, ?limit= caps at 500.
+func HandlerListAudits(c *gin.Context) {
+	limit := 100
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 && v <= 500 {
+		limit = v
+	}
+	rows, err := DB.QueryContext(c.Request.Context(), `
+		SELECT id, local_dag_hash, originating_node, auditor, nonce, status, COALESCE(reason,''), challenged_at, answered_at
+		FROM global_audits
+		WHERE ($1 = '' OR status = $1) AND ($2 = '' OR originating_node = $2)
+		ORDER BY id DESC
+		LIMIT $3
+	`, c.Query("status"), c.Query("node_id"), limit)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_list_audits", "details": err.Error()})
+		return
+	}
+	defer rows.Close()
+	out := []auditRecord{}
+	for rows.Next() {
+		var a auditRecord
+		var answered sql.NullTime
+		if err := rows.Scan(&a.ID, &a.LocalDagHash, &a.OriginatingNode, &a.Auditor, &a.Nonce, &a.Status, &a.Reason, &a.ChallengedAt, &answered); err != nil {
+			c.JSON(500, gin.H{"error": "db_scan_audit", "details": err.Error()})
+			return
+		}
+		if answered.Valid {
+			a.AnsweredAt = &answered.Time
+		}
+		out = append(out, a)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(500, gin.H{"error": "db_list_audits", "details": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true, "audits": out})
+}
+
+// HandlerAdminAudit audits one settlement now: {"local_dag_hash": "..."}.
+func HandlerAdminAudit(c *gin.Context) {
+	var req struct {
+		LocalDagHash string `json:"local_dag_hash"`
+	}
+	if err := c.BindJSON(&req); err != nil || req.LocalDagHash == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "local_dag_hash_required"})
+		return
+	}
+	rec, err := auditSettlement(c.Request.Context(), req.LocalDagHash)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "settlement_not_found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "audit_error", "details": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true, "audit": rec})
+}
@@ -0,0 +1,780 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"hackodisha/backend/epochproof"
+	"hackodisha/backend/merkle"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Global time is cut into fixed epochs of EpochInterval, aligned to the unix
+// epoch and keyed by global_dag_nodes.created_at, which replicas copy from
+// the signed entry. Once an epoch is EpochGrace past its end every verifier
+// closes it: the Merkle root over its entries is signed and exchanged, and
+// the epoch is published once a supermajority signed the same root. Epochs
+// without entries are skipped; PrevRoot links each to the last one closed.
+//
+// Replicas can disagree about an epoch when an entry reaches some of them
+// only after they closed it. A late entry for an epoch we have not
+// published reopens it (checkEpochOpen); one for a published epoch is kept
+// but marked late and left out of every tree. If a supermajority publishes
+// a head without entries we included, adoptEpochHead marks those late and
+// takes the head, so our chain of prev_roots does not stay pending forever.
+var (
+	EpochInterval = time.Minute
+	EpochGrace    = 15 * time.Second
+)
+
+// epochLockKey serialises closing an epoch against inserting entries into
+// it (pg_advisory_xact_lock).
+const epochLockKey int64 = 0x65706f6368
+
+var errEpochClosed = errors.New("entry falls in a closed epoch")
+
+// epochEntriesQuery lists the entries of [start, end) in tree order.
+const epochEntriesQuery = `
+	SELECT global_tx_hash FROM global_dag_nodes
+	WHERE created_at >= $1 AND created_at < $2 AND NOT late
+	ORDER BY created_at, global_tx_hash
+`
+
+// epochEntries runs epochEntriesQuery on q.
+func epochEntries(ctx context.Context, q interface {
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+}, start, end time.Time) ([]string, error) {
+	rows, err := q.QueryContext(ctx, epochEntriesQuery, start.UTC(), end.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var hashes []string
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, rows.Err()
+}
+
+// epochSignature is one verifier's TPM-signed epoch head.
+type epochSignature struct {
+	Epoch       int64          `json:"epoch"`
+	StartUnixMs int64          `json:"start_unix_ms"`
+	EndUnixMs   int64          `json:"end_unix_ms"`
+	Size        uint64         `json:"size"`
+	Root        string         `json:"root"`
+	PrevRoot    string         `json:"prev_root"`
+	Signer      signedEnvelope `json:"signer"`
+}
+
+func epochMessage(s epochSignature) []byte {
+	return epochproof.Message(s.Epoch, s.StartUnixMs, s.EndUnixMs, s.Size, s.Root, s.PrevRoot)
+}
+
+// epochTree returns the leaf data of globalTxHashes, in order.
+func epochTree(globalTxHashes []string) [][]byte {
+	leaves := make([][]byte, len(globalTxHashes))
+	for i, h := range globalTxHashes {
+		leaves[i] = epochproof.LeafData(h)
+	}
+	return leaves
+}
+
+// memNodes serves perfect subtree hashes of leaves from memory.
+func memNodes(leaves [][]byte) merkle.NodeFunc {
+	levels := [][][]byte{make([][]byte, len(leaves))}
+	for i, l := range leaves {
+		levels[0][i] = merkle.LeafHash(l)
+	}
+	for prev := levels[0]; len(prev) > 1; prev = levels[len(levels)-1] {
+		next := make([][]byte, len(prev)/2)
+		for i := range next {
+			next[i] = merkle.NodeHash(prev[2*i], prev[2*i+1])
+		}
+		levels = append(levels, next)
+	}
+	return func(level uint, index uint64) ([]byte, error) {
+		if int(level) >= len(levels) || index >= uint64(len(levels[level])) {
+			return nil, fmt.Errorf("no subtree at level %d index %d", level, index)
+		}
+		return levels[level][index], nil
+	}
+}
+
+// === Closing ===
+
+// epochLoop closes every epoch that is due, re-sends our signatures on
+// epochs still short of a supermajority and nets the next settled window.
+func epochLoop(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		ctx, cancel := context.WithTimeout(context.Background(), interval*4)
+		for {
+			closed, err := closeNextEpoch(ctx)
+			if err != nil {
+				log.Printf("epoch: close failed: %v", err)
+			}
+			if !closed {
+				break
+			}
+		}
+		if err := adoptEpochHead(ctx); err != nil {
+			log.Printf("epoch: adopt failed: %v", err)
+		}
+		if err := rebroadcastEpochSignatures(ctx); err != nil {
+			log.Printf("epoch: rebroadcast failed: %v", err)
+		}
+		if err := proposeNetting(ctx); err != nil {
+			log.Printf("netting: propose failed: %v", err)
+		}
+		cancel()
+	}
+}
+
+// closeNextEpoch closes the oldest due epoch with entries after the last
+// closed one, then signs it. It reports false when nothing is due.
+func closeNextEpoch(ctx context.Context) (bool, error) {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, epochLockKey); err != nil {
+		return false, err
+	}
+
+	last := int64(-1)
+	lastEnd := time.UnixMilli(0).UTC()
+	prevRoot := ""
+	err = tx.QueryRowContext(ctx, `
+		SELECT epoch, end_at, root FROM global_epochs ORDER BY epoch DESC LIMIT 1
+	`).Scan(&last, &lastEnd, &prevRoot)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+
+	cutoff := time.Now().Add(-EpochGrace)
+	var first sql.NullTime
+	if err := tx.QueryRowContext(ctx, `
+		SELECT MIN(created_at) FROM global_dag_nodes WHERE created_at >= $1 AND created_at < $2 AND NOT late
+	`, lastEnd, cutoff).Scan(&first); err != nil {
+		return false, err
+	}
+	if !first.Valid {
+		return false, nil
+	}
+	ivl := EpochInterval.Milliseconds()
+	n := first.Time.UnixMilli() / ivl
+	start, end := n*ivl, (n+1)*ivl
+	if end > cutoff.UnixMilli() {
+		return false, nil
+	}
+	if n <= last {
+		return false, fmt.Errorf("epoch %d already closed; was EPOCH_INTERVAL changed?", n)
+	}
+
+	hashes, err := epochEntries(ctx, tx, time.UnixMilli(start), time.UnixMilli(end))
+	if err != nil {
+		return false, err
+	}
+
+	s := epochSignature{
+		Epoch:       n,
+		StartUnixMs: start,
+		EndUnixMs:   end,
+		Size:        uint64(len(hashes)),
+		Root:        hex.EncodeToString(merkle.Root(epochTree(hashes))),
+		PrevRoot:    prevRoot,
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO global_epochs (epoch, start_at, end_at, size, root, prev_root, status, closed_at)
+		VALUES ($1,$2,$3,$4,$5,$6,'pending',NOW())
+	`, s.Epoch, time.UnixMilli(start).UTC(), time.UnixMilli(end).UTC(), s.Size, s.Root, s.PrevRoot); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	log.Printf("epoch: closed epoch=%d size=%d root=%s", s.Epoch, s.Size, s.Root)
+
+	if s.Signer, err = signEnvelope(epochMessage(s)); err != nil {
+		return true, err
+	}
+	if err := storeEpochSignature(ctx, s); err != nil {
+		return true, err
+	}
+	go broadcastEpochSignature(s, PeersList)
+	return true, nil
+}
+
+// checkEpochOpen prepares the insert of an entry created at createdAt and
+// reports whether it is late. Call it inside the insert's transaction; it
+// holds the epoch lock, shared unless the entry falls behind a closed epoch,
+// until that commits.
+//
+// If only pending epochs lie at or after createdAt, they are reopened: our
+// heads and signatures for them are dropped and closeNextEpoch closes them
+// again with the entry. If one is published, the entry is late. The rare
+// epoch closed while we waited for the shared lock returns errEpochClosed;
+// senders retry.
+func checkEpochOpen(ctx context.Context, tx *sql.Tx, createdAt time.Time) (bool, error) {
+	var behind bool
+	if err := tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM global_epochs WHERE end_at > $1)
+	`, createdAt).Scan(&behind); err != nil {
+		return false, err
+	}
+	lock := `SELECT pg_advisory_xact_lock_shared($1)`
+	if behind {
+		lock = `SELECT pg_advisory_xact_lock($1)`
+	}
+	if _, err := tx.ExecContext(ctx, lock, epochLockKey); err != nil {
+		return false, err
+	}
+
+	var pending, published int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FILTER (WHERE status='pending'), COUNT(*) FILTER (WHERE status='published')
+		FROM global_epochs WHERE end_at > $1
+	`, createdAt).Scan(&pending, &published); err != nil {
+		return false, err
+	}
+	switch {
+	case published > 0:
+		return true, nil
+	case pending == 0:
+		return false, nil
+	case !behind:
+		return false, errEpochClosed
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM global_epoch_signatures
+		WHERE node_id=$2 AND epoch IN (SELECT epoch FROM global_epochs WHERE end_at > $1)
+	`, createdAt, NodeID); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM global_epochs WHERE end_at > $1`, createdAt); err != nil {
+		return false, err
+	}
+	log.Printf("epoch: reopened %d pending epoch(s) for a late entry at %s", pending, createdAt.Format(time.RFC3339Nano))
+	return false, nil
+}
+
+// adoptEpochHead repairs our oldest pending epoch when a supermajority of
+// verifiers signed a different head for it on the same prev_root. That
+// happens when entries reached them after they closed it: we fetch the
+// head's entries from one of its signers, mark the ones of ours it lacks
+// late, and take the head (re-signing it) once our tree matches its root.
+func adoptEpochHead(ctx context.Context) error {
+	var n int64
+	var start, end time.Time
+	var root, prevRoot string
+	err := DB.QueryRowContext(ctx, `
+		SELECT epoch, start_at, end_at, root, prev_root FROM global_epochs
+		WHERE status='pending' ORDER BY epoch LIMIT 1
+	`).Scan(&n, &start, &end, &root, &prevRoot)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var size uint64
+	var headRoot string
+	var signers []string
+	err = DB.QueryRowContext(ctx, `
+		SELECT size, root, array_agg(node_id ORDER BY node_id) FROM global_epoch_signatures
+		WHERE epoch=$1 AND start_at=$2 AND end_at=$3 AND prev_root=$4 AND root <> $5
+		GROUP BY size, root
+		HAVING COUNT(*) >= $6
+		LIMIT 1
+	`, n, start, end, prevRoot, root, Supermajority).Scan(&size, &headRoot, pq.Array(&signers))
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	keep, err := fetchEpochEntries(ctx, n, signers)
+	if err != nil {
+		return fmt.Errorf("epoch %d: %w", n, err)
+	}
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, epochLockKey); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `
+		UPDATE global_dag_nodes SET late=true
+		WHERE created_at >= $1 AND created_at < $2 AND NOT late AND NOT (global_tx_hash = ANY($3))
+	`, start.UTC(), end.UTC(), pq.Array(keep))
+	if err != nil {
+		return err
+	}
+	late, _ := res.RowsAffected()
+	hashes, err := epochEntries(ctx, tx, start, end)
+	if err != nil {
+		return err
+	}
+	if uint64(len(hashes)) != size || hex.EncodeToString(merkle.Root(epochTree(hashes))) != headRoot {
+		// entries of the head have not been replicated to us yet
+		return fmt.Errorf("epoch %d: have %d of %d entries for head %s", n, len(hashes), size, headRoot)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM global_epoch_signatures WHERE node_id=$2 AND epoch >= $1
+	`, n, NodeID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM global_epochs WHERE epoch > $1`, n); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE global_epochs SET size=$2, root=$3, closed_at=NOW() WHERE epoch=$1
+	`, n, size, headRoot); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("epoch: adopted epoch=%d root=%s late=%d", n, headRoot, late)
+
+	s := epochSignature{
+		Epoch:       n,
+		StartUnixMs: start.UnixMilli(),
+		EndUnixMs:   end.UnixMilli(),
+		Size:        size,
+		Root:        headRoot,
+		PrevRoot:    prevRoot,
+	}
+	if s.Signer, err = signEnvelope(epochMessage(s)); err != nil {
+		return err
+	}
+	if err := storeEpochSignature(ctx, s); err != nil {
+		return err
+	}
+	go broadcastEpochSignature(s, PeersList)
+	return nil
+}
+
+// fetchEpochEntries asks signers, at their configured addresses, for the
+// entries of their head for epoch.
+func fetchEpochEntries(ctx context.Context, epoch int64, signers []string) ([]string, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	for _, id := range signers {
+		m, ok := Members.GlobalMember(id)
+		if !ok || m.Address == "" || id == NodeID {
+			continue
+		}
+		url := fmt.Sprintf("%s/peer/epoch/%d/entries", strings.TrimRight(m.Address, "/"), epoch)
+		req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			continue
+		}
+		var body struct {
+			Hashes []string `json:"hashes"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		_ = resp.Body.Close()
+		if resp.StatusCode == http.StatusOK && err == nil {
+			return body.Hashes, nil
+		}
+	}
+	return nil, errors.New("no signer served the epoch entries")
+}
+
+// === Signatures ===
+
+// storeEpochSignature persists a verified signature and publishes the epoch
+// once a supermajority signed our head. A verifier signing two heads for
+// one epoch raises an epoch_equivocation alert.
+func storeEpochSignature(ctx context.Context, s epochSignature) error {
+	details, _ := json.Marshal(map[string]any{
+		"parent_pub_b64": s.Signer.ParentPubB64,
+		"attestation":    s.Signer.Attestation,
+	})
+	// A verifier may replace its head after a repair: a reopened epoch only
+	// grows, a re-closed one follows a new prev_root, and an adopted one is
+	// a head others signed. Anything else is equivocation.
+	res, err := DB.ExecContext(ctx, `
+		INSERT INTO global_epoch_signatures (epoch, node_id, start_at, end_at, size, root, prev_root, signature, details, signed_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9::jsonb,NOW())
+		ON CONFLICT (epoch, node_id) DO UPDATE
+		  SET start_at=EXCLUDED.start_at, end_at=EXCLUDED.end_at, size=EXCLUDED.size, root=EXCLUDED.root,
+		      prev_root=EXCLUDED.prev_root, signature=EXCLUDED.signature, details=EXCLUDED.details, signed_at=NOW()
+		  WHERE global_epoch_signatures.size < EXCLUDED.size
+		     OR global_epoch_signatures.prev_root <> EXCLUDED.prev_root
+		     OR EXISTS (
+		          SELECT 1 FROM global_epoch_signatures o
+		          WHERE o.epoch=EXCLUDED.epoch AND o.node_id <> EXCLUDED.node_id
+		            AND o.root=EXCLUDED.root AND o.prev_root=EXCLUDED.prev_root AND o.size=EXCLUDED.size
+		        )
+	`, s.Epoch, s.Signer.NodeID, time.UnixMilli(s.StartUnixMs).UTC(), time.UnixMilli(s.EndUnixMs).UTC(),
+		s.Size, s.Root, s.PrevRoot, s.Signer.SigB64, string(details))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var root, prev string
+		if err := DB.QueryRowContext(ctx, `
+			SELECT root, prev_root FROM global_epoch_signatures WHERE epoch=$1 AND node_id=$2
+		`, s.Epoch, s.Signer.NodeID).Scan(&root, &prev); err != nil {
+			return err
+		}
+		if root != s.Root || prev != s.PrevRoot {
+			raiseGlobalTamperAlert(ctx, fmt.Sprintf("epoch:%d", s.Epoch), "epoch_equivocation", map[string]any{
+				"verifier": s.Signer.NodeID,
+				"roots":    []string{root, s.Root},
+			})
+		}
+		return nil
+	}
+	return tallyEpoch(ctx, s.Epoch)
+}
+
+// tallyEpoch publishes epoch once Supermajority signatures match our head.
+func tallyEpoch(ctx context.Context, epoch int64) error {
+	res, err := DB.ExecContext(ctx, `
+		UPDATE global_epochs e SET status='published', published_at=NOW()
+		WHERE e.epoch=$1 AND e.status='pending' AND (
+			SELECT COUNT(*) FROM global_epoch_signatures s
+			WHERE s.epoch=e.epoch AND s.start_at=e.start_at AND s.end_at=e.end_at
+			  AND s.size=e.size AND s.root=e.root AND s.prev_root=e.prev_root
+		) >= $2
+	`, epoch, Supermajority)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("epoch: published epoch=%d", epoch)
+	}
+	return nil
+}
+
+// loadEpochSigners returns the signatures that match epoch's head.
+func loadEpochSigners(ctx context.Context, e *epochproof.Epoch) error {
+	rows, err := DB.QueryContext(ctx, `
+		SELECT node_id, signature, details FROM global_epoch_signatures
+		WHERE epoch=$1 AND size=$2 AND root=$3 AND prev_root=$4
+		ORDER BY node_id
+	`, e.Epoch, e.Size, e.Root, e.PrevRoot)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	e.Signatures = []epochproof.Signer{}
+	for rows.Next() {
+		var s epochproof.Signer
+		var details []byte
+		if err := rows.Scan(&s.NodeID, &s.SigB64, &details); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(details, &s); err != nil {
+			return err
+		}
+		e.Signatures = append(e.Signatures, s)
+	}
+	return rows.Err()
+}
+
+// rebroadcastEpochSignatures re-sends our signature on every pending epoch,
+// so a sibling that was down when we closed it still counts us.
+func rebroadcastEpochSignatures(ctx context.Context) error {
+	rows, err := DB.QueryContext(ctx, `
+		SELECT e.epoch, e.start_at, e.end_at, e.size, e.root, e.prev_root, s.signature, s.details
+		FROM global_epochs e
+		JOIN global_epoch_signatures s ON s.epoch=e.epoch AND s.node_id=$1
+		WHERE e.status='pending'
+		ORDER BY e.epoch
+		LIMIT 50
+	`, NodeID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s epochSignature
+		var start, end time.Time
+		var details []byte
+		if err := rows.Scan(&s.Epoch, &start, &end, &s.Size, &s.Root, &s.PrevRoot, &s.Signer.SigB64, &details); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(details, &s.Signer); err != nil {
+			return err
+		}
+		s.StartUnixMs, s.EndUnixMs = start.UnixMilli(), end.UnixMilli()
+		s.Signer.NodeID = NodeID
+		broadcastEpochSignature(s, PeersList)
+	}
+	return rows.Err()
+}
+
+// broadcastEpochSignature sends s to every global sibling (single try).
+func broadcastEpochSignature(s epochSignature, peers []string) {
+	client := &http.Client{Timeout: 3 * time.Second}
+	body, _ := json.Marshal(s)
+	for _, p := range peers {
+		go func(peer string) {
+			url := strings.TrimRight(peer, "/") + "/peer/epoch/signature"
+			req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := client.Do(req)
+			if err != nil {
+				return
+			}
+			_ = resp.Body.Close()
+		}(p)
+	}
+}
+
+// === Handlers ===
+
+// HandlerPeerEpochSignature accepts a sibling verifier's signed epoch head.
+func HandlerPeerEpochSignature(c *gin.Context) {
+	var s epochSignature
+	if err := c.BindJSON(&s); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request", "details": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	if err := verifyEnvelope(ctx, s.Signer, epochMessage(s)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "epoch_verification_failed", "reason": err.Error()})
+		return
+	}
+	if err := storeEpochSignature(ctx, s); err != nil {
+		c.JSON(500, gin.H{"error": "db_store_epoch_signature", "details": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true})
+}
+
+const epochColumns = `epoch, start_at, end_at, size, root, prev_root, status`
+
+func scanEpoch(row interface{ Scan(...any) error }) (epochproof.Epoch, string, error) {
+	var e epochproof.Epoch
+	var start, end time.Time
+	var status string
+	if err := row.Scan(&e.Epoch, &start, &end, &e.Size, &e.Root, &e.PrevRoot, &status); err != nil {
+		return e, "", err
+	}
+	e.StartUnixMs, e.EndUnixMs = start.UnixMilli(), end.UnixMilli()
+	return e, status, nil
+}
+
+// HandlerListEpochs pages through published epochs, newest first:
+// ?limit= (max 100) and ?before=<epoch> from the previous page's
+// next_before. ?include_pending=true also lists epochs still collecting
+// signatures.
+func HandlerListEpochs(c *gin.Context) {
+	ctx := c.Request.Context()
+	limit := 20
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 && v <= 100 {
+		limit = v
+	}
+	before := int64(1<<63 - 1)
+	if v := c.Query("before"); v != "" {
+		b, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_before"})
+			return
+		}
+		before = b
+	}
+	rows, err := DB.QueryContext(ctx, `
+		SELECT `+epochColumns+` FROM global_epochs
+		WHERE epoch < $1 AND (status='published' OR $2)
+		ORDER BY epoch DESC
+		LIMIT $3
+	`, before, c.Query("include_pending") == "true", limit)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_list_epochs", "details": err.Error()})
+		return
+	}
+	type listed struct {
+		epochproof.Epoch
+		Status string `json:"status"`
+	}
+	out := []listed{}
+	for rows.Next() {
+		e, status, err := scanEpoch(rows)
+		if err != nil {
+			rows.Close()
+			c.JSON(500, gin.H{"error": "db_scan_epoch", "details": err.Error()})
+			return
+		}
+		out = append(out, listed{e, status})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		c.JSON(500, gin.H{"error": "db_list_epochs", "details": err.Error()})
+		return
+	}
+	for i := range out {
+		if err := loadEpochSigners(ctx, &out[i].Epoch); err != nil {
+			c.JSON(500, gin.H{"error": "db_load_epoch_signatures", "details": err.Error()})
+			return
+		}
+	}
+	resp := gin.H{"ok": true, "epochs": out, "quorum": Supermajority}
+	if len(out) == limit {
+		resp["next_before"] = out[len(out)-1].Epoch.Epoch
+	}
+	c.JSON(200, resp)
+}
+
+// HandlerGetEpoch returns one epoch head with its matching signatures.
+func HandlerGetEpoch(c *gin.Context) {
+	n, err := strconv.ParseInt(c.Param("epoch"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_epoch"})
+		return
+	}
+	ctx := c.Request.Context()
+	e, status, err := scanEpoch(DB.QueryRowContext(ctx, `SELECT `+epochColumns+` FROM global_epochs WHERE epoch=$1`, n))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "epoch_not_found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_lookup_epoch", "details": err.Error()})
+		return
+	}
+	if err := loadEpochSigners(ctx, &e); err != nil {
+		c.JSON(500, gin.H{"error": "db_load_epoch_signatures", "details": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true, "epoch": e, "status": status, "quorum": Supermajority})
+}
+
+// HandlerPeerEpochEntries lists the entries of our head for an epoch, so a
+// replica that closed it differently can adopt it (adoptEpochHead).
+func HandlerPeerEpochEntries(c *gin.Context) {
+	n, err := strconv.ParseInt(c.Param("epoch"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_epoch"})
+		return
+	}
+	ctx := c.Request.Context()
+	e, _, err := scanEpoch(DB.QueryRowContext(ctx, `SELECT `+epochColumns+` FROM global_epochs WHERE epoch=$1`, n))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "epoch_not_found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_lookup_epoch", "details": err.Error()})
+		return
+	}
+	hashes, err := epochEntries(ctx, DB, time.UnixMilli(e.StartUnixMs), time.UnixMilli(e.EndUnixMs))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_load_epoch_entries", "details": err.Error()})
+		return
+	}
+	if uint64(len(hashes)) != e.Size {
+		c.JSON(http.StatusConflict, gin.H{"error": "epoch_entries_changed"})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true, "epoch": n, "root": e.Root, "hashes": hashes})
+}
+
+// HandlerSettlementProof proves a settlement's global DAG entry is in a
+// published epoch; epochproof.Verify checks the response offline.
+func HandlerSettlementProof(c *gin.Context) {
+	ctx := c.Request.Context()
+	localDagHash := c.Param("local_dag_hash")
+
+	var globalTxHash string
+	var createdAt time.Time
+	var late bool
+	err := DB.QueryRowContext(ctx, `
+		SELECT d.global_tx_hash, d.created_at, d.late
+		FROM global_settlements s JOIN global_dag_nodes d ON d.settlement_id = s.id
+		WHERE s.local_dag_hash=$1
+	`, localDagHash).Scan(&globalTxHash, &createdAt, &late)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "settlement_not_found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_lookup_settlement", "details": err.Error()})
+		return
+	}
+
+	e, status, err := scanEpoch(DB.QueryRowContext(ctx, `
+		SELECT `+epochColumns+` FROM global_epochs WHERE start_at <= $1 AND end_at > $1
+	`, createdAt))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "epoch_not_closed"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_lookup_epoch", "details": err.Error()})
+		return
+	}
+	if status != "published" {
+		c.JSON(http.StatusConflict, gin.H{"error": "epoch_not_published", "epoch": e.Epoch})
+		return
+	}
+
+	hashes, err := epochEntries(ctx, DB, time.UnixMilli(e.StartUnixMs), time.UnixMilli(e.EndUnixMs))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_load_epoch_entries", "details": err.Error()})
+		return
+	}
+	index := -1
+	for i, h := range hashes {
+		if h == globalTxHash {
+			index = i
+		}
+	}
+	if late {
+		c.JSON(http.StatusNotFound, gin.H{"error": "entry_late", "epoch": e.Epoch})
+		return
+	}
+	if uint64(len(hashes)) != e.Size || index < 0 {
+		c.JSON(500, gin.H{"error": "epoch_entries_changed", "epoch": e.Epoch})
+		return
+	}
+
+	path, err := merkle.InclusionPath(uint64(index), e.Size, memNodes(epochTree(hashes)))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "epoch_path", "details": err.Error()})
+		return
+	}
+	if err := loadEpochSigners(ctx, &e); err != nil {
+		c.JSON(500, gin.H{"error": "db_load_epoch_signatures", "details": err.Error()})
+		return
+	}
+	p := epochproof.Proof{
+		LocalDagHash: localDagHash,
+		GlobalTxHash: globalTxHash,
+		LeafIndex:    uint64(index),
+		Path:         make([]string, len(path)),
+		Epoch:        e,
+	}
+	for i, h := range path {
+		p.Path[i] = hex.EncodeToString(h)
+	}
+	c.JSON(200, p)
+}
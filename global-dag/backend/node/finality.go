@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// FinalityRetryInterval is how often undelivered finality notices are retried.
+var FinalityRetryInterval = 10 * time.Second
+
+// finalityNotice tells the originating local node how its settlement ended.
+// Verdicts are the supermajority that decided it, so the local node need not
+// trust the notifier alone.
+type finalityNotice struct {
+	LocalDagHash string          `json:"local_dag_hash"`
+	Outcome      string          `json:"outcome"` // finalized | rejected
+	Verdicts     []globalVerdict `json:"verdicts"`
+	Notifier     signedEnvelope  `json:"notifier"`
+}
+
+func finalityMessage(localDagHash, outcome string) []byte {
+	return []byte(fmt.Sprintf("finality|%s|%s", localDagHash, outcome))
+}
+
+// notifyFinality pushes a signed finality notice to the originating node's
+// configured address and marks it delivered on success.
+func notifyFinality(localDagHash string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var status, origin string
+	err := DB.QueryRowContext(ctx, `
+		SELECT status, originating_node FROM global_settlements WHERE local_dag_hash=$1
+	`, localDagHash).Scan(&status, &origin)
+	if err != nil || (status != "validated" && status != "rejected") {
+		return
+	}
+	callback := localNodeAddress(origin)
+	if callback == "" {
+		log.Printf("finality: settlement=%s delivered=false reason=no_member_address node=%s", localDagHash, origin)
+		return
+	}
+	outcome := "finalized"
+	if status == "rejected" {
+		outcome = "rejected"
+	}
+
+	verdicts, err := loadDecidingVerdicts(ctx, localDagHash, status == "validated")
+	if err != nil {
+		log.Printf("finality: settlement=%s load_verdicts_failed=%v", localDagHash, err)
+		return
+	}
+	env, err := signEnvelope(finalityMessage(localDagHash, outcome))
+	if err != nil {
+		log.Printf("finality: settlement=%s sign_failed=%v", localDagHash, err)
+		return
+	}
+	body, _ := json.Marshal(finalityNotice{
+		LocalDagHash: localDagHash,
+		Outcome:      outcome,
+		Verdicts:     verdicts,
+		Notifier:     env,
+	})
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	url := strings.TrimRight(callback, "/") + "/peer/finality"
+	req, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("finality: settlement=%s delivered=false reason=net_error", localDagHash)
+		return
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("finality: settlement=%s delivered=false reason=status_%d", localDagHash, resp.StatusCode)
+		return
+	}
+	_, _ = DB.ExecContext(ctx, `
+		UPDATE global_settlements SET finality_notified_at=NOW() WHERE local_dag_hash=$1
+	`, localDagHash)
+	log.Printf("finality: settlement=%s outcome=%s delivered=true", localDagHash, outcome)
+}
+
+// loadDecidingVerdicts returns each verifier's latest verdict that agrees
+// with the final outcome.
+func loadDecidingVerdicts(ctx context.Context, localDagHash string, validated bool) ([]globalVerdict, error) {
+	rows, err := DB.QueryContext(ctx, `
+		SELECT * FROM (
+			SELECT DISTINCT ON (node_id) node_id, validation_round, validated, signature, details
+			FROM global_attestations
+			WHERE local_dag_hash=$1
+			ORDER BY node_id, validation_round DESC
+		) latest WHERE validated=$2
+	`, localDagHash, validated)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []globalVerdict
+	for rows.Next() {
+		v := globalVerdict{LocalDagHash: localDagHash}
+		var details []byte
+		if err := rows.Scan(&v.Verifier.NodeID, &v.Round, &v.Validated, &v.Verifier.SigB64, &details); err != nil {
+			return nil, err
+		}
+		var d struct {
+			Reason       string          `json:"reason"`
+			ParentPubB64 string          `json:"parent_pub_b64"`
+			Attestation  json.RawMessage `json:"attestation"`
+		}
+		if err := json.Unmarshal(details, &d); err != nil {
+			return nil, err
+		}
+		v.Reason = d.Reason
+		v.Verifier.ParentPubB64 = d.ParentPubB64
+		v.Verifier.Attestation = d.Attestation
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+// finalityLoop retries notices for decided settlements not yet delivered.
+func finalityLoop(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		rows, err := DB.QueryContext(ctx, `
+			SELECT local_dag_hash FROM global_settlements
+			WHERE status IN ('validated','rejected') AND finality_notified_at IS NULL
+			ORDER BY created_at
+			LIMIT 100
+		`)
+		if err != nil {
+			cancel()
+			log.Printf("finality: sweep failed: %v", err)
+			continue
+		}
+		var hashes []string
+		for rows.Next() {
+			var h string
+			if err := rows.Scan(&h); err == nil {
+				hashes = append(hashes, h)
+			}
+		}
+		rows.Close()
+		cancel()
+		for _, h := range hashes {
+			notifyFinality(h)
+		}
+	}
+}
@@ -0,0 +1,800 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Netting: each published epoch with settlements is a settlement window.
+// Once none of its settlements is pending, the lowest-named verifier that
+// signed the epoch proposes a netting report; siblings recompute it and
+// co-sign, and with a supermajority the proposer records it as a "netting"
+// global DAG entry and broadcasts the commit. A verifier co-signs at most
+// one proposer per window, so only one report entry can reach a quorum.
+//
+// A validated settlement moving value from an account settled by cluster A
+// to an account whose home is cluster B is an obligation of A to B, in the
+// currency the recipient is credited. An account's home is the cluster of
+// the first validated settlement it sent before the window ended;
+// recipients with no home yet are reported as unresolved, not netted.
+
+// netTxTypes move value between accounts.
+var netTxTypes = map[string]bool{
+	"transfer":      true,
+	"bulk_transfer": true,
+	"trade_cash":    true,
+	"fx_transfer":   true,
+}
+
+var (
+	errNettingNotReady  = errors.New("settlement window not ready for netting")
+	errNettingConflict  = errors.New("already co-signed another netting proposal for this window")
+	errDuplicateNetting = errors.New("window already netted")
+)
+
+func creditAmount(t localTx) int64 {
+	if t.TxType == "fx_transfer" {
+		return t.CounterAmount
+	}
+	return t.Amount
+}
+
+func creditCurrency(t localTx) string {
+	if t.TxType == "fx_transfer" {
+		return t.CounterCurrency
+	}
+	return t.Currency
+}
+
+// nettingReport is the canonical body of a window's netting; its sha256 is
+// the report hash verifiers sign.
+type nettingReport struct {
+	Epoch       int64 `json:"epoch"`
+	StartUnixMs int64 `json:"start_unix_ms"`
+	EndUnixMs   int64 `json:"end_unix_ms"`
+	Settlements int   `json:"settlements"` // cross-cluster, netted
+	Internal    int   `json:"internal"`    // same cluster, not netted
+	Expired     int   `json:"expired"`     // no quorum either way, not netted
+
+	Pairs      []nettingPair       `json:"pairs"`
+	Positions  []nettingPosition   `json:"positions"`
+	Unresolved []nettingUnresolved `json:"unresolved"`
+}
+
+// nettingPair is the bilateral net between two clusters (A < B); a
+// positive Net means A owes B.
+type nettingPair struct {
+	ClusterA string `json:"cluster_a"`
+	ClusterB string `json:"cluster_b"`
+	Currency string `json:"currency"`
+	GrossAB  int64  `json:"gross_a_to_b"`
+	GrossBA  int64  `json:"gross_b_to_a"`
+	Net      int64  `json:"net"`
+}
+
+// nettingPosition is a cluster's multilateral position; a negative Net is
+// what it pays in, a positive one what it receives.
+type nettingPosition struct {
+	ClusterID string `json:"cluster_id"`
+	Currency  string `json:"currency"`
+	Paid      int64  `json:"paid"`
+	Received  int64  `json:"received"`
+	Net       int64  `json:"net"`
+}
+
+type nettingUnresolved struct {
+	ClusterID string `json:"cluster_id"` // debtor
+	Currency  string `json:"currency"`
+	Gross     int64  `json:"gross"`
+	Count     int    `json:"count"`
+}
+
+func nettingMessage(epoch int64, reportHash, proposer string) []byte {
+	return []byte(fmt.Sprintf("netting|%d|%s|%s", epoch, reportHash, proposer))
+}
+
+// nettingProposal asks a sibling to co-sign our report for a window.
+type nettingProposal struct {
+	Epoch      int64          `json:"epoch"`
+	ReportHash string         `json:"report_hash"`
+	Signature  signedEnvelope `json:"signature"`
+}
+
+// nettingCommit carries a quorum-signed report and its global DAG entry.
+type nettingCommit struct {
+	Report     []byte           `json:"report"`
+	ReportHash string           `json:"report_hash"`
+	Signatures []signedEnvelope `json:"signatures"`
+	Entry      replicatedEntry  `json:"entry"`
+}
+
+// === Computing ===
+
+// computeNetting nets epoch's validated settlements. It fails with
+// errNettingNotReady until the epoch is published and nothing it reads is
+// pending: no settlement in the window, and no earlier one sent by a
+// window recipient, whose validation could still move that recipient's
+// home. Expired settlements are final; they are counted, not netted, so a
+// settlement that never reaches a quorum cannot hold up later windows.
+func computeNetting(ctx context.Context, epoch int64) (nettingReport, error) {
+	r := nettingReport{Epoch: epoch}
+	var start, end time.Time
+	var status string
+	err := DB.QueryRowContext(ctx, `
+		SELECT start_at, end_at, status FROM global_epochs WHERE epoch=$1
+	`, epoch).Scan(&start, &end, &status)
+	if err == sql.ErrNoRows || (err == nil && status != "published") {
+		return r, errNettingNotReady
+	}
+	if err != nil {
+		return r, err
+	}
+	r.StartUnixMs, r.EndUnixMs = start.UnixMilli(), end.UnixMilli()
+
+	types := make([]string, 0, len(netTxTypes))
+	for tt := range netTxTypes {
+		types = append(types, tt)
+	}
+
+	var pending bool
+	if err := DB.QueryRowContext(ctx, `
+		SELECT EXISTS (
+		  SELECT 1 FROM global_settlements p
+		  WHERE p.status='pending' AND p.created_at < $2 AND (
+		    p.created_at >= $1 OR p.from_public_id IN (
+		      SELECT s.to_public_id FROM global_settlements s
+		      WHERE s.created_at >= $1 AND s.created_at < $2 AND s.tx_type = ANY($3)
+		    )
+		  )
+		)
+	`, start, end, pq.Array(types)).Scan(&pending); err != nil {
+		return r, err
+	}
+	if pending {
+		return r, errNettingNotReady
+	}
+	if err := DB.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM global_settlements
+		WHERE created_at >= $1 AND created_at < $2 AND status='expired' AND tx_type = ANY($3)
+	`, start, end, pq.Array(types)).Scan(&r.Expired); err != nil {
+		return r, err
+	}
+	rows, err := DB.QueryContext(ctx, `
+		SELECT s.cluster_id, s.credit_currency, s.credit_amount,
+		       COALESCE((
+		         SELECT h.cluster_id FROM global_settlements h
+		         WHERE h.from_public_id = s.to_public_id AND h.status='validated' AND h.created_at < $2
+		         ORDER BY h.created_at, h.local_dag_hash LIMIT 1
+		       ), '')
+		FROM global_settlements s
+		WHERE s.created_at >= $1 AND s.created_at < $2
+		  AND s.status='validated' AND s.tx_type = ANY($3)
+		ORDER BY s.created_at, s.local_dag_hash
+	`, start, end, pq.Array(types))
+	if err != nil {
+		return r, err
+	}
+	defer rows.Close()
+
+	type key struct{ debtor, creditor, currency string }
+	gross := map[key]int64{}
+	unresolved := map[key]*nettingUnresolved{}
+	for rows.Next() {
+		var debtor, currency, creditor string
+		var amount int64
+		if err := rows.Scan(&debtor, &currency, &amount, &creditor); err != nil {
+			return r, err
+		}
+		switch {
+		case creditor == "":
+			k := key{debtor, "", currency}
+			if unresolved[k] == nil {
+				unresolved[k] = &nettingUnresolved{ClusterID: debtor, Currency: currency}
+			}
+			unresolved[k].Gross += amount
+			unresolved[k].Count++
+		case creditor == debtor:
+			r.Internal++
+		default:
+			gross[key{debtor, creditor, currency}] += amount
+			r.Settlements++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return r, err
+	}
+
+	pairs := map[key]*nettingPair{}
+	positions := map[key]*nettingPosition{}
+	position := func(cluster, currency string) *nettingPosition {
+		k := key{cluster, "", currency}
+		if positions[k] == nil {
+			positions[k] = &nettingPosition{ClusterID: cluster, Currency: currency}
+		}
+		return positions[k]
+	}
+	for k, amt := range gross {
+		a, b := k.debtor, k.creditor
+		if b < a {
+			a, b = b, a
+		}
+		pk := key{a, b, k.currency}
+		if pairs[pk] == nil {
+			pairs[pk] = &nettingPair{ClusterA: a, ClusterB: b, Currency: k.currency}
+		}
+		if k.debtor == a {
+			pairs[pk].GrossAB += amt
+		} else {
+			pairs[pk].GrossBA += amt
+		}
+		position(k.debtor, k.currency).Paid += amt
+		position(k.creditor, k.currency).Received += amt
+	}
+
+	r.Pairs = make([]nettingPair, 0, len(pairs))
+	for _, p := range pairs {
+		p.Net = p.GrossAB - p.GrossBA
+		r.Pairs = append(r.Pairs, *p)
+	}
+	sort.Slice(r.Pairs, func(i, j int) bool {
+		pi, pj := r.Pairs[i], r.Pairs[j]
+		if pi.ClusterA != pj.ClusterA {
+			return pi.ClusterA < pj.ClusterA
+		}
+		if pi.ClusterB != pj.ClusterB {
+			return pi.ClusterB < pj.ClusterB
+		}
+		return pi.Currency < pj.Currency
+	})
+	r.Positions = make([]nettingPosition, 0, len(positions))
+	for _, p := range positions {
+		p.Net = p.Received - p.Paid
+		r.Positions = append(r.Positions, *p)
+	}
+	sort.Slice(r.Positions, func(i, j int) bool {
+		if r.Positions[i].ClusterID != r.Positions[j].ClusterID {
+			return r.Positions[i].ClusterID < r.Positions[j].ClusterID
+		}
+		return r.Positions[i].Currency < r.Positions[j].Currency
+	})
+	r.Unresolved = make([]nettingUnresolved, 0, len(unresolved))
+	for _, u := range unresolved {
+		r.Unresolved = append(r.Unresolved, *u)
+	}
+	sort.Slice(r.Unresolved, func(i, j int) bool {
+		if r.Unresolved[i].ClusterID != r.Unresolved[j].ClusterID {
+			return r.Unresolved[i].ClusterID < r.Unresolved[j].ClusterID
+		}
+		return r.Unresolved[i].Currency < r.Unresolved[j].Currency
+	})
+	return r, nil
+}
+
+// nettingBody returns the canonical JSON of r and its hash.
+func nettingBody(r nettingReport) ([]byte, string, error) {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return nil, "", err
+	}
+	h := sha256.Sum256(body)
+	return body, hex.EncodeToString(h[:]), nil
+}
+
+// === Signatures ===
+
+// coSignNetting signs proposer's report for epoch, or returns the signature
+// we already gave it. It refuses a second proposer or report for the window.
+func coSignNetting(ctx context.Context, epoch int64, reportHash, proposer string) (signedEnvelope, error) {
+	var env signedEnvelope
+	var prevHash, prevProposer string
+	var details []byte
+	err := DB.QueryRowContext(ctx, `
+		SELECT report_hash, proposer, signature, details FROM global_netting_signatures
+		WHERE epoch=$1 AND node_id=$2
+	`, epoch, NodeID).Scan(&prevHash, &prevProposer, &env.SigB64, &details)
+	if err == nil {
+		if prevHash != reportHash || prevProposer != proposer {
+			return env, errNettingConflict
+		}
+		env.NodeID = NodeID
+		return env, json.Unmarshal(details, &env)
+	}
+	if err != sql.ErrNoRows {
+		return env, err
+	}
+	if env, err = signEnvelope(nettingMessage(epoch, reportHash, proposer)); err != nil {
+		return env, err
+	}
+	if err := storeNettingSignature(ctx, epoch, reportHash, proposer, env); err != nil {
+		return env, err
+	}
+	return env, nil
+}
+
+func storeNettingSignature(ctx context.Context, epoch int64, reportHash, proposer string, env signedEnvelope) error {
+	details, _ := json.Marshal(map[string]any{
+		"parent_pub_b64": env.ParentPubB64,
+		"attestation":    env.Attestation,
+	})
+	_, err := DB.ExecContext(ctx, `
+		INSERT INTO global_netting_signatures (epoch, node_id, report_hash, proposer, signature, details, signed_at)
+		VALUES ($1,$2,$3,$4,$5,$6::jsonb,NOW())
+		ON CONFLICT (epoch, node_id) DO NOTHING
+	`, epoch, env.NodeID, reportHash, proposer, env.SigB64, string(details))
+	return err
+}
+
+// verifyNettingQuorum needs Supermajority verifiers to have signed
+// proposer's report for epoch. Signatures are checked against the members'
+// configured keys and counted once per parent key, so one TPM listed under
+// several ids, or a key learned on first use, adds nothing.
+func verifyNettingQuorum(ctx context.Context, epoch int64, reportHash, proposer string, sigs []signedEnvelope) error {
+	msg := nettingMessage(epoch, reportHash, proposer)
+	signers := map[string]bool{}
+	for _, s := range sigs {
+		m, ok := Members.GlobalMember(s.NodeID)
+		if !ok || signers[m.ParentPubB64] {
+			continue
+		}
+		if err := verifyEnvelope(ctx, s, msg); err != nil {
+			continue
+		}
+		signers[m.ParentPubB64] = true
+	}
+	if len(signers) < Supermajority {
+		return fmt.Errorf("quorum_not_met: %d/%d", len(signers), Supermajority)
+	}
+	return nil
+}
+
+// loadNettingSignatures returns the stored signatures on epoch's report.
+func loadNettingSignatures(ctx context.Context, epoch int64, reportHash, proposer string) ([]signedEnvelope, error) {
+	rows, err := DB.QueryContext(ctx, `
+		SELECT node_id, signature, details FROM global_netting_signatures
+		WHERE epoch=$1 AND report_hash=$2 AND proposer=$3
+		ORDER BY node_id
+	`, epoch, reportHash, proposer)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sigs := []signedEnvelope{}
+	for rows.Next() {
+		var s signedEnvelope
+		var details []byte
+		if err := rows.Scan(&s.NodeID, &s.SigB64, &details); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(details, &s); err != nil {
+			return nil, err
+		}
+		sigs = append(sigs, s)
+	}
+	return sigs, rows.Err()
+}
+
+// === Proposing ===
+
+// proposeNetting nets the oldest published window that has settlements and
+// no report yet, if we are its proposer.
+func proposeNetting(ctx context.Context) error {
+	var epoch int64
+	var proposer sql.NullString
+	err := DB.QueryRowContext(ctx, `
+		SELECT e.epoch, (
+		         SELECT MIN(s.node_id) FROM global_epoch_signatures s
+		         WHERE s.epoch=e.epoch AND s.size=e.size AND s.root=e.root AND s.prev_root=e.prev_root
+		       )
+		FROM global_epochs e
+		WHERE e.status='published'
+		  AND NOT EXISTS (SELECT 1 FROM global_netting_reports n WHERE n.epoch=e.epoch)
+		  AND EXISTS (SELECT 1 FROM global_settlements s WHERE s.created_at >= e.start_at AND s.created_at < e.end_at)
+		ORDER BY e.epoch
+		LIMIT 1
+	`).Scan(&epoch, &proposer)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if proposer.String != NodeID {
+		return nil
+	}
+
+	report, err := computeNetting(ctx, epoch)
+	if err == errNettingNotReady {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	body, reportHash, err := nettingBody(report)
+	if err != nil {
+		return err
+	}
+	own, err := coSignNetting(ctx, epoch, reportHash, NodeID)
+	if err != nil {
+		return err
+	}
+	sigs := append([]signedEnvelope{own}, collectNettingSignatures(ctx, nettingProposal{Epoch: epoch, ReportHash: reportHash, Signature: own})...)
+	if err := verifyNettingQuorum(ctx, epoch, reportHash, NodeID, sigs); err != nil {
+		log.Printf("netting: epoch=%d report=%s not committed: %v", epoch, reportHash, err)
+		return nil
+	}
+
+	parents, err := selectGlobalParents(ctx)
+	if err != nil {
+		return err
+	}
+	entry := globalEntry{
+		Kind:         "netting",
+		Parents:      parents,
+		TsUnixMs:     time.Now().UnixMilli(),
+		NettingEpoch: epoch,
+		ReportHash:   reportHash,
+	}
+	entryBody, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	h := sha256.Sum256(entryBody)
+	re := replicatedEntry{EntryBody: entryBody, GlobalTxHash: hex.EncodeToString(h[:])}
+	if re.Creator, err = signEnvelope(globalTxSignMessage(re.GlobalTxHash)); err != nil {
+		return err
+	}
+	cm := nettingCommit{Report: body, ReportHash: reportHash, Signatures: sigs, Entry: re}
+	if err := insertNetting(ctx, cm, entry); err != nil && err != errDuplicateNetting {
+		return err
+	}
+	log.Printf("netting: epoch=%d report=%s pairs=%d settlements=%d global_tx=%s",
+		epoch, reportHash, len(report.Pairs), report.Settlements, re.GlobalTxHash)
+	go broadcastNettingCommit(cm, PeersList)
+	return nil
+}
+
+// insertNetting stores the report, its signatures and its global DAG entry.
+func insertNetting(ctx context.Context, cm nettingCommit, entry globalEntry) error {
+	createdAt := time.UnixMilli(entry.TsUnixMs).UTC()
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	late, err := checkEpochOpen(ctx, tx, createdAt)
+	if err != nil {
+		return err
+	}
+
+	var epoch int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO global_netting_reports (epoch, report_hash, report_body, proposer, global_tx_hash, committed_at)
+		VALUES ($1,$2,$3,$4,$5,NOW())
+		ON CONFLICT (epoch) DO NOTHING
+		RETURNING epoch
+	`, entry.NettingEpoch, cm.ReportHash, string(cm.Report), cm.Entry.Creator.NodeID, cm.Entry.GlobalTxHash).Scan(&epoch)
+	if err == sql.ErrNoRows {
+		return errDuplicateNetting
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO global_dag_nodes (global_tx_hash, parents, dag_type, node_id, node_signature, entry_body, created_at, late)
+		VALUES ($1,$2,'global',$3,$4,$5,$6,$7)
+	`, cm.Entry.GlobalTxHash, pq.Array(entry.Parents), cm.Entry.Creator.NodeID, cm.Entry.Creator.SigB64, string(cm.Entry.EntryBody), createdAt, late); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, s := range cm.Signatures {
+		if err := storeNettingSignature(ctx, entry.NettingEpoch, cm.ReportHash, cm.Entry.Creator.NodeID, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectNettingSignatures asks every sibling to co-sign p.
+func collectNettingSignatures(ctx context.Context, p nettingProposal) []signedEnvelope {
+	client := &http.Client{Timeout: 10 * time.Second}
+	body, _ := json.Marshal(p)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var sigs []signedEnvelope
+	for _, peer := range PeersList {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			url := strings.TrimRight(peer, "/") + "/peer/netting"
+			req, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := client.Do(req)
+			if err != nil {
+				return
+			}
+			defer resp.Body.Close()
+			var out struct {
+				Signature *signedEnvelope `json:"signature"`
+				Error     string          `json:"error"`
+			}
+			_ = json.NewDecoder(resp.Body).Decode(&out)
+			if resp.StatusCode != http.StatusOK || out.Signature == nil {
+				log.Printf("netting: epoch=%d peer=%s declined: %d %s", p.Epoch, peer, resp.StatusCode, out.Error)
+				return
+			}
+			mu.Lock()
+			sigs = append(sigs, *out.Signature)
+			mu.Unlock()
+		}(peer)
+	}
+	wg.Wait()
+	return sigs
+}
+
+// broadcastNettingCommit pushes cm to every global sibling, retrying while
+// the entry's parents are still in flight.
+func broadcastNettingCommit(cm nettingCommit, peers []string) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	body, _ := json.Marshal(cm)
+	for _, p := range peers {
+		go func(peer string) {
+			url := strings.TrimRight(peer, "/") + "/peer/netting/commit"
+			backoff := time.Millisecond * 200
+			for i := 0; i < 5; i++ {
+				req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				resp, err := client.Do(req)
+				if err == nil {
+					_ = resp.Body.Close()
+					if resp.StatusCode == http.StatusOK {
+						return
+					}
+				}
+				time.Sleep(backoff + time.Duration(rand.Int63n(int64(backoff))))
+				backoff *= 2
+			}
+			log.Printf("netting: global_tx=%s peer=%s replicated=false", cm.Entry.GlobalTxHash, peer)
+		}(p)
+	}
+}
+
+// === Handlers ===
+
+// HandlerPeerNetting co-signs a sibling's netting proposal if our own
+// netting of the window gives the same report.
+func HandlerPeerNetting(c *gin.Context) {
+	var p nettingProposal
+	if err := c.BindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request", "details": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	proposer := p.Signature.NodeID
+	if err := verifyEnvelope(ctx, p.Signature, nettingMessage(p.Epoch, p.ReportHash, proposer)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "netting_verification_failed", "reason": err.Error()})
+		return
+	}
+	report, err := computeNetting(ctx, p.Epoch)
+	if err == errNettingNotReady {
+		c.JSON(http.StatusConflict, gin.H{"error": "netting_not_ready"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_compute_netting", "details": err.Error()})
+		return
+	}
+	_, reportHash, err := nettingBody(report)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "netting_encode", "details": err.Error()})
+		return
+	}
+	if reportHash != p.ReportHash {
+		raiseGlobalTamperAlert(ctx, fmt.Sprintf("netting:%d", p.Epoch), "netting_report_mismatch", map[string]any{
+			"proposer":        proposer,
+			"proposed_report": p.ReportHash,
+			"local_report":    reportHash,
+		})
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "netting_report_mismatch", "local_report_hash": reportHash})
+		return
+	}
+	if err := storeNettingSignature(ctx, p.Epoch, p.ReportHash, proposer, p.Signature); err != nil {
+		c.JSON(500, gin.H{"error": "db_store_netting_signature", "details": err.Error()})
+		return
+	}
+	own, err := coSignNetting(ctx, p.Epoch, p.ReportHash, proposer)
+	if err == errNettingConflict {
+		c.JSON(http.StatusConflict, gin.H{"error": "netting_conflict"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "sign_failed", "details": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true, "signature": own})
+}
+
+// HandlerPeerNettingCommit stores a netting report once it carries a quorum.
+func HandlerPeerNettingCommit(c *gin.Context) {
+	var cm nettingCommit
+	if err := c.BindJSON(&cm); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request", "details": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	entry, err := verifyReplicatedEntry(ctx, cm.Entry)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "entry_verification_failed", "reason": err.Error()})
+		return
+	}
+	h := sha256.Sum256(cm.Report)
+	var report nettingReport
+	if entry.Kind != "netting" || entry.ReportHash != cm.ReportHash || hex.EncodeToString(h[:]) != cm.ReportHash ||
+		json.Unmarshal(cm.Report, &report) != nil || report.Epoch != entry.NettingEpoch {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "netting_malformed"})
+		return
+	}
+	if err := verifyNettingQuorum(ctx, entry.NettingEpoch, cm.ReportHash, cm.Entry.Creator.NodeID, cm.Signatures); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "netting_verification_failed", "reason": err.Error()})
+		return
+	}
+	missing, err := missingGlobalParents(ctx, entry.Parents)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_check_parents", "details": err.Error()})
+		return
+	}
+	if len(missing) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "parents_unknown", "missing": missing})
+		return
+	}
+	err = insertNetting(ctx, cm, entry)
+	if err == errEpochClosed {
+		c.JSON(http.StatusConflict, gin.H{"error": "epoch_closed", "global_tx_hash": cm.Entry.GlobalTxHash})
+		return
+	}
+	if err != nil && err != errDuplicateNetting {
+		c.JSON(500, gin.H{"error": "db_insert_netting", "details": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true})
+}
+
+// HandlerListNetting pages through netted windows, newest first: ?limit=
+// (max 100) and ?before=<epoch> from the previous page's next_before.
+func HandlerListNetting(c *gin.Context) {
+	limit := 20
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 && v <= 100 {
+		limit = v
+	}
+	before := int64(1<<63 - 1)
+	if v := c.Query("before"); v != "" {
+		b, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_before"})
+			return
+		}
+		before = b
+	}
+	rows, err := DB.QueryContext(c.Request.Context(), `
+		SELECT epoch, report_hash, proposer, global_tx_hash, committed_at
+		FROM global_netting_reports
+		WHERE epoch < $1
+		ORDER BY epoch DESC
+		LIMIT $2
+	`, before, limit)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_list_netting", "details": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	type header struct {
+		Epoch        int64     `json:"epoch"`
+		ReportHash   string    `json:"report_hash"`
+		Proposer     string    `json:"proposer"`
+		GlobalTxHash string    `json:"global_tx_hash"`
+		CommittedAt  time.Time `json:"committed_at"`
+	}
+	out := []header{}
+	for rows.Next() {
+		var h header
+		if err := rows.Scan(&h.Epoch, &h.ReportHash, &h.Proposer, &h.GlobalTxHash, &h.CommittedAt); err != nil {
+			c.JSON(500, gin.H{"error": "db_scan_netting", "details": err.Error()})
+			return
+		}
+		out = append(out, h)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(500, gin.H{"error": "db_list_netting", "details": err.Error()})
+		return
+	}
+	resp := gin.H{"ok": true, "windows": out}
+	if len(out) == limit {
+		resp["next_before"] = out[len(out)-1].Epoch
+	}
+	c.JSON(200, resp)
+}
+
+// HandlerNettingReport serves one window's netting report with the
+// signatures behind it. ?cluster_id= keeps only that cluster's pairs,
+// position and unresolved totals; report_hash always covers the full report.
+func HandlerNettingReport(c *gin.Context) {
+	epoch, err := strconv.ParseInt(c.Param("epoch"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_epoch"})
+		return
+	}
+	ctx := c.Request.Context()
+	var reportHash, body, proposer, globalTxHash string
+	var committedAt time.Time
+	err = DB.QueryRowContext(ctx, `
+		SELECT report_hash, report_body, proposer, global_tx_hash, committed_at
+		FROM global_netting_reports WHERE epoch=$1
+	`, epoch).Scan(&reportHash, &body, &proposer, &globalTxHash, &committedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "netting_not_found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_lookup_netting", "details": err.Error()})
+		return
+	}
+	var report nettingReport
+	if err := json.Unmarshal([]byte(body), &report); err != nil {
+		c.JSON(500, gin.H{"error": "netting_decode", "details": err.Error()})
+		return
+	}
+	if cluster := c.Query("cluster_id"); cluster != "" {
+		pairs := []nettingPair{}
+		for _, p := range report.Pairs {
+			if p.ClusterA == cluster || p.ClusterB == cluster {
+				pairs = append(pairs, p)
+			}
+		}
+		positions := []nettingPosition{}
+		for _, p := range report.Positions {
+			if p.ClusterID == cluster {
+				positions = append(positions, p)
+			}
+		}
+		unresolved := []nettingUnresolved{}
+		for _, u := range report.Unresolved {
+			if u.ClusterID == cluster {
+				unresolved = append(unresolved, u)
+			}
+		}
+		report.Pairs, report.Positions, report.Unresolved = pairs, positions, unresolved
+	}
+	sigs, err := loadNettingSignatures(ctx, epoch, reportHash, proposer)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_load_netting_signatures", "details": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{
+		"ok":             true,
+		"report":         report,
+		"report_hash":    reportHash,
+		"proposer":       proposer,
+		"global_tx_hash": globalTxHash,
+		"committed_at":   committedAt,
+		"signatures":     sigs,
+	})
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A local node is quarantined once tamper alerts naming it since it was last
+// cleared add up to QuarantineThreshold incidents. Alerts about the same
+// local hash are one incident, and incidents first seen within the same
+// QuarantineIncidentWindow count once, so a single bad batch cannot
+// quarantine a node on its own. Each global verifier decides on
+// its own evidence and signs a quarantine vote; a supermajority of votes for
+// the same epoch makes it effective here and is pushed, as a notice, to
+// QuarantinePeers (local and auth nodes) and QuarantineMonitors. Only an
+// admin clear, voted the same way, lifts it.
+var (
+	QuarantineThreshold      = 3
+	QuarantineIncidentWindow = time.Minute
+	QuarantinePeers          []string
+	QuarantineMonitors       []string
+)
+
+var errNodeQuarantined = errors.New("node_quarantined")
+
+// quarantineVote is one verifier's signed decision for NodeID at Epoch.
+// Epochs count quarantine and clear decisions, so an old notice can never
+// be replayed over a newer one.
+type quarantineVote struct {
+	NodeID string         `json:"node_id"` // cluster_id/node_id
+	Action string         `json:"action"`  // quarantine | clear
+	Epoch  int64          `json:"epoch"`
+	Reason string         `json:"reason,omitempty"`
+	Voter  signedEnvelope `json:"voter"`
+}
+
+// quarantineNotice is a decided vote together with the supermajority behind
+// it, so receivers need not trust the sender alone.
+type quarantineNotice struct {
+	NodeID  string           `json:"node_id"`
+	DagType string           `json:"dag_type"`
+	Action  string           `json:"action"`
+	Epoch   int64            `json:"epoch"`
+	Reason  string           `json:"reason,omitempty"`
+	Votes   []quarantineVote `json:"votes"`
+}
+
+func quarantineMessage(nodeID, action string, epoch int64) []byte {
+	return []byte(fmt.Sprintf("quarantine|%s|%s|%d", nodeID, action, epoch))
+}
+
+// quarantineState returns nodeID's current status (clear if never voted on)
+// and epoch.
+func quarantineState(ctx context.Context, nodeID string) (string, int64, error) {
+	var status string
+	var epoch int64
+	err := DB.QueryRowContext(ctx, `
+		SELECT status, epoch FROM global_node_quarantine WHERE node_id=$1
+	`, nodeID).Scan(&status, &epoch)
+	if err == sql.ErrNoRows {
+		return "clear", 0, nil
+	}
+	return status, epoch, err
+}
+
+func isNodeQuarantined(ctx context.Context, nodeID string) (bool, error) {
+	status, _, err := quarantineState(ctx, nodeID)
+	return status == "quarantined", err
+}
+
+// raiseNodeAlert records misbehaviour attributable to nodeID (once per
+// local hash and description) and re-evaluates its quarantine.
+func raiseNodeAlert(ctx context.Context, nodeID, localDagHash, description string, evidence map[string]any) {
+	evb, _ := json.Marshal(evidence)
+	res, err := DB.ExecContext(ctx, `
+		INSERT INTO global_tamper_alerts (offending_local_hash, offending_node, description, evidence)
+		SELECT $1,$2,$3,$4::jsonb
+		WHERE NOT EXISTS (
+			SELECT 1 FROM global_tamper_alerts
+			WHERE offending_node=$2 AND offending_local_hash=$1 AND description=$3
+		)
+	`, localDagHash, nodeID, description, string(evb))
+	if err != nil {
+		log.Printf("tamper alert insert failed: node=%s local_hash=%s err=%v", nodeID, localDagHash, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return
+	}
+	if err := evaluateQuarantine(ctx, nodeID); err != nil {
+		log.Printf("quarantine: node=%s evaluate_failed=%v", nodeID, err)
+	}
+}
+
+// evaluateQuarantine votes to quarantine nodeID once enough incidents name
+// it.
+func evaluateQuarantine(ctx context.Context, nodeID string) error {
+	status, epoch, err := quarantineState(ctx, nodeID)
+	if err != nil || status == "quarantined" {
+		return err
+	}
+	var n int
+	var descriptions []byte
+	err = DB.QueryRowContext(ctx, `
+		WITH recent AS (
+			SELECT offending_local_hash, description, detected_at
+			FROM global_tamper_alerts
+			WHERE offending_node=$1
+			  AND detected_at > COALESCE((SELECT updated_at FROM global_node_quarantine WHERE node_id=$1), '-infinity')
+		), incidents AS (
+			SELECT MIN(detected_at) AS first_at FROM recent GROUP BY offending_local_hash
+		)
+		SELECT (SELECT COUNT(DISTINCT floor(extract(epoch FROM first_at) / $2)) FROM incidents),
+		       (SELECT COALESCE(json_agg(DISTINCT description), '[]') FROM recent)
+	`, nodeID, max(QuarantineIncidentWindow.Seconds(), 0.001)).Scan(&n, &descriptions)
+	if err != nil || n < QuarantineThreshold {
+		return err
+	}
+	reason := fmt.Sprintf("%d tamper incidents: %s", n, descriptions)
+	_, err = castQuarantineVote(ctx, nodeID, "quarantine", epoch+1, reason)
+	return err
+}
+
+// castQuarantineVote signs, stores and broadcasts our vote.
+func castQuarantineVote(ctx context.Context, nodeID, action string, epoch int64, reason string) (quarantineVote, error) {
+	env, err := signEnvelope(quarantineMessage(nodeID, action, epoch))
+	if err != nil {
+		return quarantineVote{}, err
+	}
+	v := quarantineVote{NodeID: nodeID, Action: action, Epoch: epoch, Reason: reason, Voter: env}
+	if err := storeQuarantineVote(ctx, v); err != nil {
+		return v, err
+	}
+	log.Printf("quarantine: node=%s action=%s epoch=%d voted reason=%q", nodeID, action, epoch, reason)
+	go broadcastQuarantineVote(v, PeersList)
+	return v, nil
+}
+
+// storeQuarantineVote persists a verified vote and re-tallies its epoch.
+func storeQuarantineVote(ctx context.Context, v quarantineVote) error {
+	if v.Action != "quarantine" && v.Action != "clear" {
+		return fmt.Errorf("unknown action %q", v.Action)
+	}
+	_, err := DB.ExecContext(ctx, `
+		INSERT INTO global_quarantine_votes (node_id, action, epoch, voter, signature, reason, details)
+		VALUES ($1,$2,$3,$4,$5,$6,$7::jsonb)
+		ON CONFLICT (node_id, action, epoch, voter) DO NOTHING
+	`, v.NodeID, v.Action, v.Epoch, v.Voter.NodeID, v.Voter.SigB64, v.Reason, mustJSON(v.Voter))
+	if err != nil {
+		return err
+	}
+	return tallyQuarantine(ctx, v.NodeID, v.Action, v.Epoch)
+}
+
+// tallyQuarantine applies nodeID's decision for epoch once a supermajority
+// voted for it, then propagates the notice.
+func tallyQuarantine(ctx context.Context, nodeID, action string, epoch int64) error {
+	votes, err := loadQuarantineVotes(ctx, nodeID, action, epoch)
+	if err != nil || len(votes) < Supermajority {
+		return err
+	}
+	status := "quarantined"
+	if action == "clear" {
+		status = "clear"
+	}
+	reason := votes[0].Reason
+	res, err := DB.ExecContext(ctx, `
+		INSERT INTO global_node_quarantine (node_id, status, epoch, reason, updated_at)
+		VALUES ($1,$2,$3,$4,NOW())
+		ON CONFLICT (node_id) DO UPDATE
+		  SET status=EXCLUDED.status, epoch=EXCLUDED.epoch, reason=EXCLUDED.reason, updated_at=NOW()
+		  WHERE global_node_quarantine.epoch < EXCLUDED.epoch
+	`, nodeID, status, epoch, reason)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	log.Printf("quarantine: node=%s status=%s epoch=%d votes=%d", nodeID, status, epoch, len(votes))
+	go propagateQuarantine(quarantineNotice{
+		NodeID:  nodeID,
+		DagType: "local",
+		Action:  action,
+		Epoch:   epoch,
+		Reason:  reason,
+		Votes:   votes,
+	})
+	return nil
+}
+
+func loadQuarantineVotes(ctx context.Context, nodeID, action string, epoch int64) ([]quarantineVote, error) {
+	rows, err := DB.QueryContext(ctx, `
+		SELECT reason, details FROM global_quarantine_votes
+		WHERE node_id=$1 AND action=$2 AND epoch=$3
+		ORDER BY created_at
+	`, nodeID, action, epoch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []quarantineVote
+	for rows.Next() {
+		v := quarantineVote{NodeID: nodeID, Action: action, Epoch: epoch}
+		var voter []byte
+		if err := rows.Scan(&v.Reason, &voter); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(voter, &v.Voter); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+func mustJSON(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// === Propagation ===
+
+// broadcastQuarantineVote sends our vote to every global sibling (single
+// try; siblings with the same evidence vote on their own).
+func broadcastQuarantineVote(v quarantineVote, peers []string) {
+	client := &http.Client{Timeout: 3 * time.Second}
+	body, _ := json.Marshal(v)
+	for _, p := range peers {
+		go func(peer string) {
+			url := strings.TrimRight(peer, "/") + "/peer/quarantine/vote"
+			req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := client.Do(req)
+			if err != nil {
+				return
+			}
+			_ = resp.Body.Close()
+		}(p)
+	}
+}
+
+// propagateQuarantine pushes a decided notice to local and auth peers and to
+// every monitor, retrying with backoff.
+func propagateQuarantine(n quarantineNotice) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	body, _ := json.Marshal(n)
+	var wg sync.WaitGroup
+
+	send := func(url string) {
+		defer wg.Done()
+		backoff := time.Millisecond * 200
+		for i := 0; i < 5; i++ {
+			req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := client.Do(req)
+			if err == nil {
+				_ = resp.Body.Close()
+				if resp.StatusCode == http.StatusOK {
+					return
+				}
+			}
+			time.Sleep(backoff + time.Duration(rand.Int63n(int64(backoff))))
+			backoff *= 2
+		}
+		log.Printf("quarantine: node=%s epoch=%d target=%s delivered=false", n.NodeID, n.Epoch, url)
+	}
+	for _, p := range QuarantinePeers {
+		wg.Add(1)
+		go send(strings.TrimRight(p, "/") + "/peer/quarantine")
+	}
+	for _, m := range QuarantineMonitors {
+		wg.Add(1)
+		go send(strings.TrimRight(m, "/") + "/quarantine")
+	}
+	wg.Wait()
+}
+
+// === Handlers ===
+
+// HandlerPeerQuarantineVote accepts a sibling verifier's signed vote.
+func HandlerPeerQuarantineVote(c *gin.Context) {
+	var v quarantineVote
+	if err := c.BindJSON(&v); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request", "details": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	if err := verifyEnvelope(ctx, v.Voter, quarantineMessage(v.NodeID, v.Action, v.Epoch)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "vote_verification_failed", "reason": err.Error()})
+		return
+	}
+	if err := storeQuarantineVote(ctx, v); err != nil {
+		c.JSON(500, gin.H{"error": "db_store_quarantine_vote", "details": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true})
+}
+
+// HandlerListQuarantine lists every node that has been voted on.
+func HandlerListQuarantine(c *gin.Context) {
+	rows, err := DB.QueryContext(c.Request.Context(), `
+		SELECT q.node_id, q.status, q.epoch, COALESCE(q.reason,''), q.updated_at,
+		       (SELECT COUNT(*) FROM global_tamper_alerts a WHERE a.offending_node = q.node_id)
+		FROM global_node_quarantine q
+		ORDER BY q.updated_at DESC
+	`)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_list_quarantine", "details": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	type entry struct {
+		NodeID    string    `json:"node_id"`
+		Status    string    `json:"status"`
+		Epoch     int64     `json:"epoch"`
+		Reason    string    `json:"reason"`
+		UpdatedAt time.Time `json:"updated_at"`
+		Alerts    int       `json:"alerts"`
+	}
+	out := []entry{}
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.NodeID, &e.Status, &e.Epoch, &e.Reason, &e.UpdatedAt, &e.Alerts); err != nil {
+			c.JSON(500, gin.H{"error": "db_scan_quarantine", "details": err.Error()})
+			return
+		}
+		out = append(out, e)
+	}
+	c.JSON(200, gin.H{"ok": true, "nodes": out})
+}
+
+// HandlerAdminClearQuarantine votes to lift a quarantine. The request is
+// forwarded, with the caller's credentials, to every sibling so each casts
+// its own clear vote; the clear takes effect once a supermajority did.
+func HandlerAdminClearQuarantine(c *gin.Context) {
+	var req struct {
+		NodeID string `json:"node_id"`
+		Reason string `json:"reason"`
+	}
+	if err := c.BindJSON(&req); err != nil || req.NodeID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "node_id_required"})
+		return
+	}
+	ctx := c.Request.Context()
+	status, epoch, err := quarantineState(ctx, req.NodeID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_lookup_quarantine", "details": err.Error()})
+		return
+	}
+	if status != "quarantined" {
+		c.JSON(http.StatusConflict, gin.H{"error": "not_quarantined", "status": status})
+		return
+	}
+	reason := "admin_clear"
+	if req.Reason != "" {
+		reason += ": " + req.Reason
+	}
+	if _, err := castQuarantineVote(ctx, req.NodeID, "clear", epoch+1, reason); err != nil {
+		c.JSON(500, gin.H{"error": "quarantine_vote", "details": err.Error()})
+		return
+	}
+	if c.GetHeader("X-Admin-Forwarded") == "" {
+		go forwardAdminClear(c.GetHeader("Authorization"), req.NodeID, req.Reason)
+	}
+	c.JSON(200, gin.H{"ok": true, "node_id": req.NodeID, "epoch": epoch + 1})
+}
+
+func forwardAdminClear(auth, nodeID, reason string) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	body, _ := json.Marshal(map[string]string{"node_id": nodeID, "reason": reason})
+	for _, p := range PeersList {
+		req, _ := http.NewRequest("POST", strings.TrimRight(p, "/")+"/api/admin/quarantine/clear", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", auth)
+		req.Header.Set("X-Admin-Forwarded", NodeID)
+		resp, err := client.Do(req)
+		if err != nil {
+			log.Printf("quarantine: node=%s clear_forward peer=%s err=%v", nodeID, p, err)
+			continue
+		}
+		_ = resp.Body.Close()
+	}
+}
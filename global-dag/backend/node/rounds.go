@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Global validation parameters. With n global verifiers and up to f faulty
+// ones (n >= 3f+1), a settlement is final once 2f+1 agree on it.
+var (
+	GlobalClusterSize      int
+	GlobalFaultTolerance   int
+	Supermajority          int
+	ValidationInterval     = 5 * time.Second
+	ValidationRoundTimeout = 15 * time.Second
+	MaxValidationRounds    = 3
+)
+
+// globalVerdict is one verifier's TPM-signed verdict on a settlement.
+type globalVerdict struct {
+	LocalDagHash string         `json:"local_dag_hash"`
+	Round        int            `json:"round"`
+	Validated    bool           `json:"validated"`
+	Reason       string         `json:"reason,omitempty"`
+	Verifier     signedEnvelope `json:"verifier"`
+}
+
+func verdictMessage(localDagHash string, round int, validated bool) []byte {
+	return []byte(fmt.Sprintf("verdict|%s|%d|%t", localDagHash, round, validated))
+}
+
+func configureValidation() {
+	if Members != nil {
+		GlobalClusterSize = len(Members.Global)
+	}
+	if GlobalClusterSize == 0 {
+		GlobalClusterSize = len(PeersList) + 1
+	}
+	GlobalFaultTolerance = getenvInt("FAULT_TOLERANCE", (GlobalClusterSize-1)/3)
+	if GlobalClusterSize < 3*GlobalFaultTolerance+1 {
+		log.Fatalf("validation: cluster of %d cannot tolerate %d faulty nodes (need n >= 3f+1)", GlobalClusterSize, GlobalFaultTolerance)
+	}
+	Supermajority = 2*GlobalFaultTolerance + 1
+	ValidationInterval = getenvDuration("VALIDATION_INTERVAL", ValidationInterval)
+	ValidationRoundTimeout = getenvDuration("VALIDATION_ROUND_TIMEOUT", ValidationRoundTimeout)
+	MaxValidationRounds = getenvInt("VALIDATION_MAX_ROUNDS", MaxValidationRounds)
+	log.Printf("validation: n=%d f=%d supermajority=%d", GlobalClusterSize, GlobalFaultTolerance, Supermajority)
+}
+
+// === Rounds ===
+
+// validationLoop re-validates every pending settlement each round: we vote
+// once in round 1, and open a new round if no supermajority formed within
+// ValidationRoundTimeout.
+func validationLoop(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		ctx, cancel := context.WithTimeout(context.Background(), interval*4)
+		if err := runValidationRound(ctx); err != nil {
+			log.Printf("validation: round failed: %v", err)
+		}
+		cancel()
+	}
+}
+
+func runValidationRound(ctx context.Context) error {
+	rows, err := DB.QueryContext(ctx, `
+		SELECT s.local_dag_hash, s.validation_proof,
+		       COALESCE(a.round, 0), COALESCE(a.validated_at < NOW() - $2 * interval '1 second', false)
+		FROM global_settlements s
+		LEFT JOIN LATERAL (
+			SELECT validation_round AS round, validated_at FROM global_attestations
+			WHERE local_dag_hash=s.local_dag_hash AND node_id=$1
+			ORDER BY validation_round DESC LIMIT 1
+		) a ON true
+		WHERE s.status='pending'
+		ORDER BY s.created_at
+		LIMIT 500
+	`, NodeID, ValidationRoundTimeout.Seconds())
+	if err != nil {
+		return err
+	}
+	type pending struct {
+		hash    string
+		proof   []byte
+		round   int
+		expired bool
+	}
+	var todo []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.hash, &p.proof, &p.round, &p.expired); err != nil {
+			rows.Close()
+			return err
+		}
+		todo = append(todo, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range todo {
+		next := p.round + 1
+		if p.expired && p.round >= MaxValidationRounds {
+			expireSettlement(ctx, p.hash, p.round)
+			continue
+		}
+		if p.round > 0 && !p.expired {
+			continue
+		}
+		reason := ""
+		var proof settlementProof
+		if err := json.Unmarshal(p.proof, &proof); err != nil {
+			reason = "invalid_proof_json"
+		} else if err := verifySettlementProof(ctx, proof); err != nil {
+			reason = err.Error()
+		}
+		if _, err := castVerdict(ctx, p.hash, next, reason); err != nil {
+			log.Printf("validation: settlement=%s round=%d vote_failed=%v", p.hash, next, err)
+		}
+	}
+	return nil
+}
+
+// expireSettlement gives up on a settlement that reached no supermajority in
+// MaxValidationRounds: it becomes 'expired', which is final like rejected but
+// without a finality notice, since no quorum backs either outcome.
+func expireSettlement(ctx context.Context, localDagHash string, rounds int) {
+	res, err := DB.ExecContext(ctx, `
+		UPDATE global_settlements SET status='expired', validated=false
+		WHERE local_dag_hash=$1 AND status='pending'
+	`, localDagHash)
+	if err != nil {
+		log.Printf("validation: settlement=%s expire_failed=%v", localDagHash, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("validation: settlement=%s expired after %d rounds", localDagHash, rounds)
+		raiseGlobalTamperAlert(ctx, localDagHash, "validation_expired", map[string]any{"rounds": rounds})
+	}
+}
+
+// castVerdict logs our verdict in global_verification_log, signs it, stores
+// it in global_attestations and broadcasts it. An empty reason means valid.
+func castVerdict(ctx context.Context, localDagHash string, round int, reason string) (globalVerdict, error) {
+	validated := reason == ""
+	details, _ := json.Marshal(map[string]any{"reason": reason})
+	_, err := DB.ExecContext(ctx, `
+		INSERT INTO global_verification_log (local_dag_hash, validated, verifier_node, validation_round, proof)
+		VALUES ($1,$2,$3,$4,$5::jsonb)
+	`, localDagHash, validated, NodeID, round, string(details))
+	if err != nil {
+		return globalVerdict{}, err
+	}
+
+	env, err := signEnvelope(verdictMessage(localDagHash, round, validated))
+	if err != nil {
+		return globalVerdict{}, err
+	}
+	v := globalVerdict{LocalDagHash: localDagHash, Round: round, Validated: validated, Reason: reason, Verifier: env}
+	if err := storeVerdict(ctx, v); err != nil {
+		return v, err
+	}
+	go broadcastVerdict(v, PeersList)
+	return v, nil
+}
+
+// storeVerdict persists a verified verdict and re-tallies the settlement.
+func storeVerdict(ctx context.Context, v globalVerdict) error {
+	details, _ := json.Marshal(map[string]any{
+		"reason":         v.Reason,
+		"parent_pub_b64": v.Verifier.ParentPubB64,
+		"attestation":    v.Verifier.Attestation,
+	})
+	var id string
+	err := DB.QueryRowContext(ctx, `
+		INSERT INTO global_attestations (node_id, local_dag_hash, validation_round, signature, validated, validated_at, details)
+		VALUES ($1,$2,$3,$4,$5,NOW(),$6::jsonb)
+		ON CONFLICT (node_id, local_dag_hash, validation_round) DO NOTHING
+		RETURNING id
+	`, v.Verifier.NodeID, v.LocalDagHash, v.Round, v.Verifier.SigB64, v.Validated, string(details)).Scan(&id)
+	if err == sql.ErrNoRows {
+		var prev bool
+		if err := DB.QueryRowContext(ctx, `
+			SELECT validated FROM global_attestations
+			WHERE node_id=$1 AND local_dag_hash=$2 AND validation_round=$3
+		`, v.Verifier.NodeID, v.LocalDagHash, v.Round).Scan(&prev); err != nil {
+			return err
+		}
+		if prev != v.Validated {
+			raiseGlobalTamperAlert(ctx, v.LocalDagHash, "verdict_equivocation", map[string]any{
+				"verifier": v.Verifier.NodeID,
+				"round":    v.Round,
+			})
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return tallyVerdicts(ctx, v.LocalDagHash)
+}
+
+// tallyVerdicts takes each configured verifier's latest verdict. A
+// supermajority either way finalizes the settlement; any split raises a
+// conflicting_verdicts alert.
+func tallyVerdicts(ctx context.Context, localDagHash string) error {
+	rows, err := DB.QueryContext(ctx, `
+		SELECT DISTINCT ON (node_id) node_id, validated
+		FROM global_attestations
+		WHERE local_dag_hash=$1
+		ORDER BY node_id, validation_round DESC
+	`, localDagHash)
+	if err != nil {
+		return err
+	}
+	var valid, invalid []string
+	for rows.Next() {
+		var node string
+		var ok bool
+		if err := rows.Scan(&node, &ok); err != nil {
+			rows.Close()
+			return err
+		}
+		if !isGlobalMember(node) {
+			continue
+		}
+		if ok {
+			valid = append(valid, node)
+		} else {
+			invalid = append(invalid, node)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(valid) > 0 && len(invalid) > 0 {
+		var exists bool
+		_ = DB.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM global_tamper_alerts WHERE offending_local_hash=$1 AND description='conflicting_verdicts')
+		`, localDagHash).Scan(&exists)
+		if !exists {
+			raiseGlobalTamperAlert(ctx, localDagHash, "conflicting_verdicts", map[string]any{
+				"validated_by": valid,
+				"rejected_by":  invalid,
+			})
+		}
+	}
+
+	var res sql.Result
+	switch {
+	case len(valid) >= Supermajority:
+		res, err = DB.ExecContext(ctx, `
+			UPDATE global_settlements SET status='validated', validated=true
+			WHERE local_dag_hash=$1 AND status='pending'
+		`, localDagHash)
+	case len(invalid) >= Supermajority:
+		res, err = DB.ExecContext(ctx, `
+			UPDATE global_settlements SET status='rejected', validated=false
+			WHERE local_dag_hash=$1 AND status='pending'
+		`, localDagHash)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		if len(invalid) >= Supermajority {
+			var origin string
+			if err := DB.QueryRowContext(ctx, `
+				SELECT originating_node FROM global_settlements WHERE local_dag_hash=$1
+			`, localDagHash).Scan(&origin); err == nil {
+				raiseNodeAlert(ctx, origin, localDagHash, "settlement_rejected", map[string]any{"rejected_by": invalid})
+			}
+		}
+		go notifyFinality(localDagHash)
+	}
+	return nil
+}
+
+func raiseGlobalTamperAlert(ctx context.Context, localDagHash, description string, evidence map[string]any) {
+	evb, _ := json.Marshal(evidence)
+	if _, err := DB.ExecContext(ctx, `
+		INSERT INTO global_tamper_alerts (offending_local_hash, description, evidence)
+		VALUES ($1,$2,$3::jsonb)
+	`, localDagHash, description, string(evb)); err != nil {
+		log.Printf("tamper alert insert failed: local_hash=%s err=%v", localDagHash, err)
+	}
+}
+
+// === Peer Handler ===
+
+// HandlerPeerVerdict accepts a sibling verifier's signed verdict.
+func HandlerPeerVerdict(c *gin.Context) {
+	var v globalVerdict
+	if err := c.BindJSON(&v); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request", "details": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	if err := verifyEnvelope(ctx, v.Verifier, verdictMessage(v.LocalDagHash, v.Round, v.Validated)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "verdict_verification_failed", "reason": err.Error()})
+		return
+	}
+	if err := storeVerdict(ctx, v); err != nil {
+		c.JSON(500, gin.H{"error": "db_store_verdict", "details": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true})
+}
+
+// broadcastVerdict sends our verdict to every global sibling (single try;
+// a missed verdict is recovered by the next round).
+func broadcastVerdict(v globalVerdict, peers []string) {
+	client := &http.Client{Timeout: 3 * time.Second}
+	body, _ := json.Marshal(v)
+	for _, p := range peers {
+		go func(peer string) {
+			url := strings.TrimRight(peer, "/") + "/peer/verdict"
+			req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := client.Do(req)
+			if err != nil {
+				return
+			}
+			_ = resp.Body.Close()
+		}(p)
+	}
+}
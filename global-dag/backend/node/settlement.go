@@ -0,0 +1,562 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	tpm "hackodisha/backend/tpm"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// MinLocalQuorum is the fewest distinct local votes we accept as a quorum
+// proof, whatever quorum the submitting cluster declares.
+var MinLocalQuorum = 3
+
+// clusterQuorum is the 2f+1 quorum of clusterID's configured membership, at
+// least MinLocalQuorum; the cluster's own ClusterSize and Quorum claims can
+// only raise it.
+func clusterQuorum(clusterID string, claimed int) int {
+	n := len(Members.Cluster(clusterID))
+	quorum := 2*((n-1)/3) + 1
+	if quorum < MinLocalQuorum {
+		quorum = MinLocalQuorum
+	}
+	if claimed > quorum {
+		quorum = claimed
+	}
+	return quorum
+}
+
+// maxParents is how many current tips a new global DAG node links to.
+const maxParents = 2
+
+// === Wire types (mirrors of the local node's) ===
+
+// localTx is the subset of a local node's canonical tx the global layer reads.
+type localTx struct {
+	FromPublicID string   `json:"from_public_id"`
+	ToPublicID   string   `json:"to_public_id"`
+	Amount       int64    `json:"amount"`
+	Currency     string   `json:"currency"`
+	TxType       string   `json:"tx_type"`
+	Parents      []string `json:"parents"`
+
+	CounterAmount   int64  `json:"counter_amount,omitempty"`
+	CounterCurrency string `json:"counter_currency,omitempty"`
+}
+
+type localVote struct {
+	TxHash        string         `json:"tx_hash"`
+	Parents       []string       `json:"parents"`
+	ParentsDigest string         `json:"parents_digest"`
+	Round         int            `json:"round"`
+	Verdict       string         `json:"verdict"`
+	Reason        string         `json:"reason,omitempty"`
+	Voter         signedEnvelope `json:"voter"`
+}
+
+type settlementItem struct {
+	TxHash        string      `json:"tx_hash"`
+	TxBody        []byte      `json:"tx_body"`
+	NodeSignature string      `json:"node_signature"`
+	Votes         []localVote `json:"votes"`
+}
+
+type settlementBatch struct {
+	ClusterID       string           `json:"cluster_id"`
+	OriginatingNode string           `json:"originating_node"`
+	ClusterSize     int              `json:"cluster_size"`
+	Quorum          int              `json:"quorum"`
+	CallbackURL     string           `json:"callback_url"` // recorded only; see localNodeAddress
+	CreatedAtUnixMs int64            `json:"created_at_unix_ms"`
+	Items           []settlementItem `json:"items"`
+}
+
+type settlementRequest struct {
+	Batch     []byte         `json:"batch"`
+	BatchHash string         `json:"batch_hash"`
+	Origin    signedEnvelope `json:"origin"`
+}
+
+type settlementResult struct {
+	TxHash string `json:"tx_hash"`
+	Status string `json:"status"` // accepted | duplicate | rejected
+	Reason string `json:"reason,omitempty"`
+}
+
+// settlementProof is what we keep in global_settlements.validation_proof so
+// any global verifier can re-validate the settlement later on its own.
+type settlementProof struct {
+	ClusterID       string         `json:"cluster_id"`
+	OriginatingNode string         `json:"originating_node"`
+	OriginChildPub  string         `json:"origin_child_pub_b64"`
+	ClusterSize     int            `json:"cluster_size"`
+	Quorum          int            `json:"quorum"`
+	CallbackURL     string         `json:"callback_url"`
+	BatchHash       string         `json:"batch_hash"`
+	Item            settlementItem `json:"item"`
+}
+
+// globalEntry is the canonical body of a global DAG node; its JSON is stored
+// in global_dag_nodes.entry_body and hashed into global_tx_hash.
+type globalEntry struct {
+	Kind            string   `json:"kind"` // settlement | netting
+	LocalDagHash    string   `json:"local_dag_hash"`
+	OriginatingNode string   `json:"originating_node"`
+	Parents         []string `json:"parents"`
+	TsUnixMs        int64    `json:"ts_unix_ms"`
+
+	// netting entries only
+	NettingEpoch int64  `json:"netting_epoch,omitempty"`
+	ReportHash   string `json:"report_hash,omitempty"`
+}
+
+// replicatedEntry carries a global DAG node to global siblings.
+type replicatedEntry struct {
+	EntryBody    []byte         `json:"entry_body"`
+	GlobalTxHash string         `json:"global_tx_hash"`
+	Creator      signedEnvelope `json:"creator"`
+}
+
+func settlementSignMessage(batchHash string) []byte {
+	return []byte("settlement:" + batchHash)
+}
+
+func txSignMessage(txHash string) []byte {
+	return []byte("tx:" + txHash)
+}
+
+func globalTxSignMessage(hash string) []byte {
+	return []byte("gtx:" + hash)
+}
+
+func voteMessage(txHash, digest string, round int, verdict string) []byte {
+	return []byte(fmt.Sprintf("vote|%s|%s|%d|%s", txHash, digest, round, verdict))
+}
+
+func parentsDigest(parents []string) string {
+	h := sha256.Sum256([]byte(strings.Join(parents, ",")))
+	return hex.EncodeToString(h[:])
+}
+
+// localNodeID namespaces a local node under its cluster so it cannot
+// collide with global node IDs in the nodes table.
+func localNodeID(clusterID, nodeID string) string {
+	return clusterID + "/" + nodeID
+}
+
+// === Handlers ===
+
+// HandlerSubmitSettlements accepts a batch of locally confirmed txs from a
+// local node, verifies its attestation and each quorum proof, writes
+// global_settlements + global_dag_nodes and replicates them to siblings.
+func HandlerSubmitSettlements(c *gin.Context) {
+	var req settlementRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request", "details": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+
+	batch, proofs, results, err := verifySettlementRequest(ctx, req)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "verification_failed", "reason": err.Error()})
+		return
+	}
+
+	var entries []replicatedEntry
+	for i, r := range results {
+		if r.Status != "accepted" {
+			continue
+		}
+		entry, status, err := createSettlementEntry(ctx, batch, proofs[i])
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db_insert_settlement", "details": err.Error()})
+			return
+		}
+		results[i].Status = status
+		if status == "accepted" {
+			entries = append(entries, entry)
+		}
+	}
+
+	c.JSON(200, gin.H{"ok": true, "results": results})
+
+	if len(entries) > 0 {
+		go replicateSettlements(req, entries, PeersList)
+	}
+}
+
+// HandlerPeerSettlements stores settlements a global sibling accepted after
+// re-verifying the original local batch and the sibling's entry signatures.
+func HandlerPeerSettlements(c *gin.Context) {
+	var req struct {
+		Request settlementRequest `json:"request"`
+		Entries []replicatedEntry `json:"entries"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request", "details": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+
+	_, proofs, results, err := verifySettlementRequest(ctx, req.Request)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "verification_failed", "reason": err.Error()})
+		return
+	}
+	valid := map[string]settlementProof{}
+	for i, r := range results {
+		if r.Status == "accepted" {
+			valid[r.TxHash] = proofs[i]
+		}
+	}
+
+	for _, re := range req.Entries {
+		entry, err := verifyReplicatedEntry(ctx, re)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "entry_verification_failed", "reason": err.Error()})
+			return
+		}
+		proof, ok := valid[entry.LocalDagHash]
+		if !ok {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "entry_without_valid_proof", "local_dag_hash": entry.LocalDagHash})
+			return
+		}
+		missing, err := missingGlobalParents(ctx, entry.Parents)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db_check_parents", "details": err.Error()})
+			return
+		}
+		if len(missing) > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "parents_unknown", "missing": missing})
+			return
+		}
+		err = insertSettlement(ctx, proof, entry, re)
+		if err == errEpochClosed {
+			c.JSON(http.StatusConflict, gin.H{"error": "epoch_closed", "global_tx_hash": re.GlobalTxHash})
+			return
+		}
+		if err != nil && err != errDuplicateSettlement {
+			c.JSON(500, gin.H{"error": "db_insert_settlement", "details": err.Error()})
+			return
+		}
+	}
+	c.JSON(200, gin.H{"ok": true})
+}
+
+// === Verification ===
+
+// verifySettlementRequest checks the batch hash and the originating node's
+// attestation, then verifies every item; per-item failures are reported as
+// rejected results rather than failing the whole batch.
+func verifySettlementRequest(ctx context.Context, req settlementRequest) (settlementBatch, []settlementProof, []settlementResult, error) {
+	var batch settlementBatch
+	h := sha256.Sum256(req.Batch)
+	if hex.EncodeToString(h[:]) != req.BatchHash {
+		return batch, nil, nil, errors.New("batch_hash_mismatch")
+	}
+	if err := json.Unmarshal(req.Batch, &batch); err != nil {
+		return batch, nil, nil, fmt.Errorf("invalid batch json: %w", err)
+	}
+	if batch.ClusterID == "" || batch.OriginatingNode != req.Origin.NodeID {
+		return batch, nil, nil, errors.New("originating_node_mismatch")
+	}
+	if err := verifyLocalEnvelope(ctx, batch.ClusterID, req.Origin, settlementSignMessage(req.BatchHash)); err != nil {
+		return batch, nil, nil, fmt.Errorf("origin attestation: %w", err)
+	}
+	originID := localNodeID(batch.ClusterID, req.Origin.NodeID)
+	if q, err := isNodeQuarantined(ctx, originID); err != nil {
+		return batch, nil, nil, err
+	} else if q {
+		return batch, nil, nil, errNodeQuarantined
+	}
+	var att tpm.Attestation
+	_ = json.Unmarshal(req.Origin.Attestation, &att)
+
+	quorum := clusterQuorum(batch.ClusterID, batch.Quorum)
+
+	proofs := make([]settlementProof, len(batch.Items))
+	results := make([]settlementResult, len(batch.Items))
+	for i, it := range batch.Items {
+		proofs[i] = settlementProof{
+			ClusterID:       batch.ClusterID,
+			OriginatingNode: originID,
+			OriginChildPub:  att.ChildPubB64,
+			ClusterSize:     batch.ClusterSize,
+			Quorum:          quorum,
+			CallbackURL:     batch.CallbackURL,
+			BatchHash:       req.BatchHash,
+			Item:            it,
+		}
+		results[i] = settlementResult{TxHash: it.TxHash, Status: "accepted"}
+		if err := verifySettlementProof(ctx, proofs[i]); err != nil {
+			results[i].Status = "rejected"
+			results[i].Reason = err.Error()
+			// the batch itself is validly signed, so the bad item is on the origin
+			raiseNodeAlert(ctx, originID, it.TxHash, "settlement_item_invalid", map[string]any{
+				"batch_hash": req.BatchHash,
+				"reason":     err.Error(),
+			})
+		}
+	}
+	return batch, proofs, results, nil
+}
+
+// verifySettlementProof re-validates one settlement on its own: the tx body
+// hashes to local_dag_hash, the originating node signed it, and a quorum of
+// distinct configured members of the cluster voted accept on the same
+// parents.
+func verifySettlementProof(ctx context.Context, p settlementProof) error {
+	it := p.Item
+	h := sha256.Sum256(it.TxBody)
+	if hex.EncodeToString(h[:]) != it.TxHash {
+		return errors.New("tx_hash_mismatch")
+	}
+	pub, err := base64.StdEncoding.DecodeString(p.OriginChildPub)
+	if err != nil {
+		return errors.New("bad_origin_child_pub")
+	}
+	sig, err := base64.StdEncoding.DecodeString(it.NodeSignature)
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(pub), txSignMessage(it.TxHash), sig) {
+		return errors.New("node_signature_invalid")
+	}
+	var t localTx
+	if err := json.Unmarshal(it.TxBody, &t); err != nil {
+		return errors.New("invalid_tx_body")
+	}
+	digest := parentsDigest(t.Parents)
+
+	voters := map[string]bool{}
+	for _, v := range it.Votes {
+		if v.TxHash != it.TxHash || v.Verdict != "accept" || v.ParentsDigest != digest {
+			continue
+		}
+		if err := verifyLocalEnvelope(ctx, p.ClusterID, v.Voter, voteMessage(v.TxHash, v.ParentsDigest, v.Round, v.Verdict)); err != nil {
+			continue
+		}
+		voter := localNodeID(p.ClusterID, v.Voter.NodeID)
+		if q, _ := isNodeQuarantined(ctx, voter); q {
+			continue
+		}
+		voters[voter] = true
+	}
+	if quorum := clusterQuorum(p.ClusterID, p.Quorum); len(voters) < quorum {
+		return fmt.Errorf("quorum_not_met: %d/%d", len(voters), quorum)
+	}
+	// the node signature only counts under the key pinned for a member of
+	// that cluster, not whatever key the proof carries
+	if !strings.HasPrefix(p.OriginatingNode, p.ClusterID+"/") {
+		return errors.New("originating_node_not_in_cluster")
+	}
+	if pinned, err := pinnedNodeKey(ctx, p.OriginatingNode); err != nil || pinned != p.OriginChildPub {
+		return errors.New("origin_child_pub_not_pinned")
+	}
+	return nil
+}
+
+func verifyReplicatedEntry(ctx context.Context, re replicatedEntry) (globalEntry, error) {
+	var entry globalEntry
+	h := sha256.Sum256(re.EntryBody)
+	if hex.EncodeToString(h[:]) != re.GlobalTxHash {
+		return entry, errors.New("global_tx_hash_mismatch")
+	}
+	if err := verifyEnvelope(ctx, re.Creator, globalTxSignMessage(re.GlobalTxHash)); err != nil {
+		return entry, fmt.Errorf("creator signature: %w", err)
+	}
+	if err := json.Unmarshal(re.EntryBody, &entry); err != nil {
+		return entry, fmt.Errorf("invalid entry json: %w", err)
+	}
+	return entry, nil
+}
+
+// === Persistence ===
+
+var errDuplicateSettlement = errors.New("duplicate settlement")
+
+// createSettlementEntry builds and signs the global DAG node for a verified
+// settlement and stores both. It reports "duplicate" if the local hash was
+// already settled.
+func createSettlementEntry(ctx context.Context, batch settlementBatch, proof settlementProof) (replicatedEntry, string, error) {
+	parents, err := selectGlobalParents(ctx)
+	if err != nil {
+		return replicatedEntry{}, "", err
+	}
+	entry := globalEntry{
+		Kind:            "settlement",
+		LocalDagHash:    proof.Item.TxHash,
+		OriginatingNode: proof.OriginatingNode,
+		Parents:         parents,
+		TsUnixMs:        time.Now().UnixMilli(),
+	}
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return replicatedEntry{}, "", err
+	}
+	h := sha256.Sum256(body)
+	re := replicatedEntry{EntryBody: body, GlobalTxHash: hex.EncodeToString(h[:])}
+	re.Creator, err = signEnvelope(globalTxSignMessage(re.GlobalTxHash))
+	if err != nil {
+		return replicatedEntry{}, "", err
+	}
+
+	err = insertSettlement(ctx, proof, entry, re)
+	if err == errDuplicateSettlement {
+		return re, "duplicate", nil
+	}
+	if err != nil {
+		return replicatedEntry{}, "", err
+	}
+	return re, "accepted", nil
+}
+
+// insertSettlement writes the settlement and its global DAG node atomically.
+// created_at comes from the entry so every replica agrees on it.
+func insertSettlement(ctx context.Context, proof settlementProof, entry globalEntry, re replicatedEntry) error {
+	proofJSON, err := json.Marshal(proof)
+	if err != nil {
+		return err
+	}
+	createdAt := time.UnixMilli(entry.TsUnixMs).UTC()
+	var t localTx
+	if err := json.Unmarshal(proof.Item.TxBody, &t); err != nil {
+		return err
+	}
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	late, err := checkEpochOpen(ctx, tx, createdAt)
+	if err != nil {
+		return err
+	}
+
+	var settlementID string
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO global_settlements (local_dag_hash, originating_node, cluster_id, callback_url, validation_proof,
+		                                tx_type, from_public_id, to_public_id, credit_amount, credit_currency, created_at)
+		VALUES ($1,$2,$3,$4,$5::jsonb,$6,$7,$8,$9,$10,$11)
+		ON CONFLICT (local_dag_hash) DO NOTHING
+		RETURNING id
+	`, proof.Item.TxHash, proof.OriginatingNode, proof.ClusterID, proof.CallbackURL, string(proofJSON),
+		t.TxType, t.FromPublicID, t.ToPublicID, creditAmount(t), creditCurrency(t), createdAt).Scan(&settlementID)
+	if err == sql.ErrNoRows {
+		return errDuplicateSettlement
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO global_dag_nodes (settlement_id, global_tx_hash, parents, dag_type, node_id, node_signature, entry_body, created_at, late)
+		VALUES ($1,$2,$3,'global',$4,$5,$6,$7,$8)
+	`, settlementID, re.GlobalTxHash, pq.Array(entry.Parents), re.Creator.NodeID, re.Creator.SigB64, string(re.EntryBody), createdAt, late)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// selectGlobalParents returns the newest global DAG tips.
+func selectGlobalParents(ctx context.Context) ([]string, error) {
+	rows, err := DB.QueryContext(ctx, `
+		SELECT d.global_tx_hash FROM global_dag_nodes d
+		WHERE NOT EXISTS (SELECT 1 FROM global_dag_nodes c WHERE d.global_tx_hash = ANY(c.parents))
+		ORDER BY d.created_at DESC
+		LIMIT $1
+	`, maxParents)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	parents := []string{}
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			return nil, err
+		}
+		parents = append(parents, h)
+	}
+	return parents, rows.Err()
+}
+
+func missingGlobalParents(ctx context.Context, parents []string) ([]string, error) {
+	if len(parents) == 0 {
+		return nil, nil
+	}
+	rows, err := DB.QueryContext(ctx, `
+		SELECT p FROM unnest($1::text[]) AS p
+		WHERE NOT EXISTS (SELECT 1 FROM global_dag_nodes d WHERE d.global_tx_hash = p)
+	`, pq.Array(parents))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var missing []string
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			return nil, err
+		}
+		missing = append(missing, h)
+	}
+	return missing, rows.Err()
+}
+
+// === Propagation ===
+
+// replicateSettlements pushes the original batch and our signed entries to
+// every global sibling, retrying while parents are still in flight.
+func replicateSettlements(req settlementRequest, entries []replicatedEntry, peers []string) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	limiter := make(chan struct{}, 6)
+	var wg sync.WaitGroup
+
+	body, _ := json.Marshal(map[string]any{"request": req, "entries": entries})
+
+	for _, p := range peers {
+		wg.Add(1)
+		limiter <- struct{}{}
+		go func(peer string) {
+			defer wg.Done()
+			defer func() { <-limiter }()
+			url := strings.TrimRight(peer, "/") + "/peer/settlements"
+			backoff := time.Millisecond * 200
+			for i := 0; i < 5; i++ {
+				r, _ := http.NewRequest("POST", url, bytes.NewReader(body))
+				r.Header.Set("Content-Type", "application/json")
+				resp, err := client.Do(r)
+				if err == nil {
+					_ = resp.Body.Close()
+					if resp.StatusCode == http.StatusOK {
+						return
+					}
+				}
+				time.Sleep(backoff + time.Duration(rand.Int63n(int64(backoff))))
+				backoff *= 2
+			}
+			log.Printf("settlement: batch=%s peer=%s replicated=false", req.BatchHash, peer)
+		}(p)
+	}
+	wg.Wait()
+}
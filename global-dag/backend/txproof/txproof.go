@@ -0,0 +1,112 @@
+// Package txproof checks /api/tx/:hash/proof responses offline: the tx_hash
+// leaf, its audit path up to the tree root, and the serving node's TPM
+// signature over that root.
+package txproof
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"hackodisha/backend/merkle"
+	tpm "hackodisha/backend/tpm"
+)
+
+// Signer is the signature envelope the node attaches to a root.
+type Signer struct {
+	NodeID       string          `json:"node_id"`
+	ParentPubB64 string          `json:"parent_pub_b64"`
+	Attestation  json.RawMessage `json:"attestation"`
+	SigB64       string          `json:"sig_b64"`
+}
+
+// SignedRoot is a node's statement that its accumulator of TreeSize DAG
+// entries had root Root at SignedAtUnixMs.
+type SignedRoot struct {
+	TreeSize       uint64 `json:"tree_size"`
+	Root           string `json:"root"`
+	SignedAtUnixMs int64  `json:"signed_at_unix_ms"`
+	Signer         Signer `json:"signer"`
+}
+
+// Proof is the body of GET /api/tx/:hash/proof.
+type Proof struct {
+	TxHash     string     `json:"tx_hash"`
+	LeafIndex  uint64     `json:"leaf_index"`
+	Path       []string   `json:"path"` // hex, leaf up
+	SignedRoot SignedRoot `json:"signed_root"`
+}
+
+// LeafData is what the accumulator stores for a DAG entry.
+func LeafData(txHash string) []byte { return []byte("tx|" + txHash) }
+
+// RootMessage is the exact message a node signs for a root.
+func RootMessage(treeSize uint64, rootHex string, signedAtUnixMs int64) []byte {
+	return []byte(fmt.Sprintf("txlog|%d|%s|%d", treeSize, rootHex, signedAtUnixMs))
+}
+
+var (
+	ErrBadPath      = errors.New("inclusion path does not lead to root")
+	ErrBadSignature = errors.New("root signature invalid")
+	ErrUntrusted    = errors.New("root signed by an untrusted parent key")
+	ErrNoTrustedKey = errors.New("no trusted parent keys given")
+)
+
+// Verify checks p end to end. trustedParents lists the base64 TPM parent
+// keys the caller accepts, e.g. the serving node's configured key; a chain
+// that is merely internally valid proves nothing about who signed it, so an
+// empty list is rejected.
+func Verify(p Proof, trustedParents []string) error {
+	root, err := hex.DecodeString(p.SignedRoot.Root)
+	if err != nil {
+		return fmt.Errorf("bad root: %w", err)
+	}
+	path := make([][]byte, len(p.Path))
+	for i, h := range p.Path {
+		if path[i], err = hex.DecodeString(h); err != nil {
+			return fmt.Errorf("bad path element %d: %w", i, err)
+		}
+	}
+	leaf := merkle.LeafHash(LeafData(p.TxHash))
+	if !merkle.VerifyInclusion(leaf, p.LeafIndex, p.SignedRoot.TreeSize, path, root) {
+		return ErrBadPath
+	}
+	return VerifyRoot(p.SignedRoot, trustedParents)
+}
+
+// VerifyRoot checks only the signature chain on a signed root, which must
+// chain to one of trustedParents.
+func VerifyRoot(sr SignedRoot, trustedParents []string) error {
+	s := sr.Signer
+	if len(trustedParents) == 0 {
+		return ErrNoTrustedKey
+	}
+	trusted := false
+	for _, k := range trustedParents {
+		if k != "" && k == s.ParentPubB64 {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return ErrUntrusted
+	}
+	parentPub, err := base64.StdEncoding.DecodeString(s.ParentPubB64)
+	if err != nil {
+		return fmt.Errorf("%w: parent key: %v", ErrBadSignature, err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(s.SigB64)
+	if err != nil {
+		return fmt.Errorf("%w: signature: %v", ErrBadSignature, err)
+	}
+	var att tpm.Attestation
+	if err := json.Unmarshal(s.Attestation, &att); err != nil {
+		return fmt.Errorf("%w: attestation: %v", ErrBadSignature, err)
+	}
+	if err := tpm.VerifyChain(parentPub, RootMessage(sr.TreeSize, sr.Root, sr.SignedAtUnixMs), sig, att); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadSignature, err)
+	}
+	return nil
+}
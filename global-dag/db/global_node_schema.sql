@@ -8,12 +8,33 @@ CREATE EXTENSION IF NOT EXISTS "pgcrypto";
 CREATE TABLE IF NOT EXISTS global_settlements (
   id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
   local_dag_hash TEXT NOT NULL,      -- hash from local DAG
-  originating_node TEXT NOT NULL,    -- which local node submitted it
+  originating_node TEXT NOT NULL,    -- which local node submitted it (cluster_id/node_id)
+  cluster_id TEXT NOT NULL,          -- local cluster the node belongs to
   validated BOOLEAN DEFAULT false,
+  status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending','validated','rejected','expired')),
   validation_proof JSONB,
+  callback_url TEXT,                 -- where the originating node takes finality notices
+  finality_notified_at TIMESTAMPTZ,
+  -- read from the tx body for netting; credit_* is what the recipient gets
+  tx_type TEXT,
+  from_public_id TEXT,
+  to_public_id TEXT,
+  credit_amount BIGINT,
+  credit_currency TEXT,
   created_at TIMESTAMPTZ DEFAULT now()
 );
 
+-- Netting resolves an account's home cluster from its first settlement
+CREATE INDEX IF NOT EXISTS idx_global_settlements_sender
+  ON global_settlements (from_public_id, created_at);
+
+CREATE INDEX IF NOT EXISTS idx_global_settlements_status
+  ON global_settlements (status, created_at);
+
+-- A local DAG hash settles at most once
+CREATE UNIQUE INDEX IF NOT EXISTS idx_global_settlements_local_hash
+  ON global_settlements (local_dag_hash);
+
 -------------------------------------------------
 -- Global DAG Nodes
 -- Each DAG node confirms settlements and links them together
@@ -26,9 +47,19 @@ CREATE TABLE IF NOT EXISTS global_dag_nodes (
   dag_type TEXT NOT NULL CHECK (dag_type = 'global'),
   node_id TEXT NOT NULL,
   node_signature TEXT NOT NULL,
-  created_at TIMESTAMPTZ DEFAULT now()
+  entry_body TEXT NOT NULL,          -- canonical JSON hashed into global_tx_hash
+  created_at TIMESTAMPTZ DEFAULT now(),
+  late BOOLEAN NOT NULL DEFAULT false -- reached us after its epoch was published; in no epoch tree
 );
 
+-- Epoch closing scans entries by creation time
+CREATE INDEX IF NOT EXISTS idx_global_dag_nodes_created
+  ON global_dag_nodes (created_at, global_tx_hash);
+
+-- Tip selection scans parents arrays
+CREATE INDEX IF NOT EXISTS idx_global_dag_nodes_parents
+  ON global_dag_nodes USING GIN (parents);
+
 -------------------------------------------------
 -- Registered Nodes (with TPM keys)
 -------------------------------------------------
@@ -46,10 +77,14 @@ CREATE TABLE IF NOT EXISTS global_tamper_alerts (
   id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
   detected_at TIMESTAMPTZ DEFAULT now(),
   offending_local_hash TEXT NOT NULL,
+  offending_node TEXT,               -- cluster_id/node_id when the misbehaviour is attributable
   description TEXT NOT NULL,
   evidence JSONB
 );
 
+CREATE INDEX IF NOT EXISTS idx_global_tamper_alerts_node
+  ON global_tamper_alerts (offending_node, detected_at);
+
 -------------------------------------------------
 -- Verification Log
 -- Records final validation of local DAG hashes in the global DAG
@@ -72,8 +107,129 @@ CREATE TABLE IF NOT EXISTS global_attestations (
   id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
   node_id TEXT NOT NULL REFERENCES nodes(node_id),
   local_dag_hash TEXT NOT NULL,
+  validation_round INT NOT NULL DEFAULT 1,
   signature TEXT NOT NULL,
   validated BOOLEAN DEFAULT false,
   validated_at TIMESTAMPTZ,
   details JSONB
 );
+
+-- One verdict per verifier per settlement per round
+CREATE UNIQUE INDEX IF NOT EXISTS idx_global_attestations_verdict
+  ON global_attestations (node_id, local_dag_hash, validation_round);
+
+-------------------------------------------------
+-- Node Quarantine
+-- A local node is quarantined once QUARANTINE_THRESHOLD alerts name it and
+-- a supermajority of verifiers signed "quarantine|node|quarantine|epoch";
+-- an admin clear is voted the same way. epoch counts decisions per node.
+-------------------------------------------------
+CREATE TABLE IF NOT EXISTS global_node_quarantine (
+  node_id TEXT PRIMARY KEY,          -- cluster_id/node_id
+  status TEXT NOT NULL CHECK (status IN ('quarantined','clear')),
+  epoch BIGINT NOT NULL,
+  reason TEXT,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS global_quarantine_votes (
+  node_id TEXT NOT NULL,
+  action TEXT NOT NULL CHECK (action IN ('quarantine','clear')),
+  epoch BIGINT NOT NULL,
+  voter TEXT NOT NULL,
+  signature TEXT NOT NULL,
+  reason TEXT,
+  details JSONB NOT NULL,            -- the voter's signed envelope
+  created_at TIMESTAMPTZ DEFAULT now(),
+  PRIMARY KEY (node_id, action, epoch, voter)
+);
+
+-------------------------------------------------
+-- Epochs
+-- Each closed epoch commits to the global_dag_nodes created in
+-- [start_at, end_at), ordered by (created_at, global_tx_hash), as an
+-- RFC 6962 Merkle root chained to the previous epoch's root. It is
+-- published once a supermajority signed
+-- "epoch|epoch|start_ms|end_ms|size|root|prev_root".
+-------------------------------------------------
+CREATE TABLE IF NOT EXISTS global_epochs (
+  epoch BIGINT PRIMARY KEY,          -- start_ms / EPOCH_INTERVAL
+  start_at TIMESTAMPTZ NOT NULL,
+  end_at TIMESTAMPTZ NOT NULL,
+  size BIGINT NOT NULL,
+  root TEXT NOT NULL,                -- hex
+  prev_root TEXT NOT NULL DEFAULT '',
+  status TEXT NOT NULL CHECK (status IN ('pending','published')),
+  closed_at TIMESTAMPTZ DEFAULT now(),
+  published_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_global_epochs_range
+  ON global_epochs (start_at, end_at);
+
+CREATE TABLE IF NOT EXISTS global_epoch_signatures (
+  epoch BIGINT NOT NULL,
+  node_id TEXT NOT NULL,
+  start_at TIMESTAMPTZ NOT NULL,
+  end_at TIMESTAMPTZ NOT NULL,
+  size BIGINT NOT NULL,
+  root TEXT NOT NULL,
+  prev_root TEXT NOT NULL,
+  signature TEXT NOT NULL,
+  details JSONB NOT NULL,            -- parent_pub_b64 + attestation
+  signed_at TIMESTAMPTZ DEFAULT now(),
+  PRIMARY KEY (epoch, node_id)
+);
+
+-------------------------------------------------
+-- Netting
+-- One report per settlement window (published epoch): bilateral nets per
+-- cluster pair and multilateral positions per cluster and currency. The
+-- canonical report_body hashes to report_hash; a supermajority signed
+-- "netting|epoch|report_hash|proposer" and the proposer recorded it as the
+-- "netting" global DAG entry global_tx_hash.
+-------------------------------------------------
+CREATE TABLE IF NOT EXISTS global_netting_reports (
+  epoch BIGINT PRIMARY KEY,
+  report_hash TEXT NOT NULL,
+  report_body TEXT NOT NULL,
+  proposer TEXT NOT NULL,
+  global_tx_hash TEXT NOT NULL,
+  committed_at TIMESTAMPTZ DEFAULT now()
+);
+
+-- A verifier co-signs one proposal per window
+CREATE TABLE IF NOT EXISTS global_netting_signatures (
+  epoch BIGINT NOT NULL,
+  node_id TEXT NOT NULL,
+  report_hash TEXT NOT NULL,
+  proposer TEXT NOT NULL,
+  signature TEXT NOT NULL,
+  details JSONB NOT NULL,            -- parent_pub_b64 + attestation
+  signed_at TIMESTAMPTZ DEFAULT now(),
+  PRIMARY KEY (epoch, node_id)
+);
+
+-------------------------------------------------
+-- Audits
+-- Random challenges to the local node that settled a tx: it must return
+-- the tx, signatures, quorum votes and tx log inclusion proof, signed over
+-- our nonce. failed and timeout outcomes raise node alerts.
+-------------------------------------------------
+CREATE TABLE IF NOT EXISTS global_audits (
+  id BIGSERIAL PRIMARY KEY,
+  local_dag_hash TEXT NOT NULL,
+  originating_node TEXT NOT NULL,    -- cluster_id/node_id
+  auditor TEXT NOT NULL,
+  nonce TEXT NOT NULL,
+  status TEXT NOT NULL CHECK (status IN ('open','passed','failed','timeout')),
+  reason TEXT,
+  challenged_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  answered_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_global_audits_hash
+  ON global_audits (local_dag_hash, auditor);
+
+CREATE INDEX IF NOT EXISTS idx_global_audits_node
+  ON global_audits (originating_node, status);
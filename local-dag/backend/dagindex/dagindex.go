@@ -0,0 +1,287 @@
+// Package dagindex keeps an in-memory copy of a DAG's parent links so tip
+// selection and ancestry questions don't need recursive SQL. Nodes are
+// identified by tx_hash. A parent that was never added (pruned, or below a
+// checkpoint frontier) is simply outside the index: its children count it in
+// Parents but it has no depth and appears in no ancestor set.
+package dagindex
+
+import (
+	"sort"
+	"sync"
+)
+
+type node struct {
+	hash     string
+	parents  []string
+	children []*node
+	depth    int    // longest path to a node without indexed parents
+	seq      uint64 // insertion order
+}
+
+// Index is safe for concurrent use.
+type Index struct {
+	mu    sync.RWMutex
+	nodes map[string]*node
+	tips  map[string]*node
+	// children added before their parent, keyed by the missing parent
+	waiting map[string][]*node
+	seq     uint64
+}
+
+// Info describes one indexed node.
+type Info struct {
+	Hash     string   `json:"tx_hash"`
+	Parents  []string `json:"parents"`
+	Children []string `json:"children"`
+	Depth    int      `json:"depth"`
+	Tip      bool     `json:"tip"`
+}
+
+func New() *Index {
+	return &Index{
+		nodes:   map[string]*node{},
+		tips:    map[string]*node{},
+		waiting: map[string][]*node{},
+	}
+}
+
+// Add indexes hash with its parents. It reports false if hash is already
+// indexed. Nodes should be added oldest first; a child that arrives before
+// its parent is linked, and its depth corrected, when the parent arrives.
+func (x *Index) Add(hash string, parents []string) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if _, ok := x.nodes[hash]; ok {
+		return false
+	}
+	x.seq++
+	n := &node{hash: hash, parents: append([]string(nil), parents...), seq: x.seq}
+	for _, p := range parents {
+		if pn, ok := x.nodes[p]; ok {
+			pn.children = append(pn.children, n)
+			delete(x.tips, p)
+			if pn.depth+1 > n.depth {
+				n.depth = pn.depth + 1
+			}
+		} else {
+			x.waiting[p] = append(x.waiting[p], n)
+		}
+	}
+	x.nodes[hash] = n
+
+	if early := x.waiting[hash]; len(early) > 0 {
+		delete(x.waiting, hash)
+		n.children = early
+		x.deepen(n)
+	} else {
+		x.tips[hash] = n
+	}
+	return true
+}
+
+// deepen pushes n's depth down to descendants that now sit deeper.
+func (x *Index) deepen(n *node) {
+	stack := []*node{n}
+	for len(stack) > 0 {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, c := range cur.children {
+			if cur.depth+1 > c.depth {
+				c.depth = cur.depth + 1
+				stack = append(stack, c)
+			}
+		}
+	}
+}
+
+// Remove drops hashes from the index, e.g. once they are pruned. Their
+// children keep their depth and their parent hashes.
+func (x *Index) Remove(hashes ...string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, h := range hashes {
+		n, ok := x.nodes[h]
+		if !ok {
+			continue
+		}
+		delete(x.nodes, h)
+		delete(x.tips, h)
+		for _, p := range n.parents {
+			if pn, ok := x.nodes[p]; ok {
+				pn.children = without(pn.children, n)
+				if len(pn.children) == 0 {
+					x.tips[p] = pn
+				}
+			} else if w := without(x.waiting[p], n); len(w) > 0 {
+				x.waiting[p] = w
+			} else {
+				delete(x.waiting, p)
+			}
+		}
+	}
+}
+
+func without(ns []*node, n *node) []*node {
+	out := ns[:0]
+	for _, c := range ns {
+		if c != n {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Len returns the number of indexed nodes.
+func (x *Index) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.nodes)
+}
+
+// Has reports whether hash is indexed.
+func (x *Index) Has(hash string) bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	_, ok := x.nodes[hash]
+	return ok
+}
+
+// Tips returns up to limit nodes no other node references, newest first.
+// limit <= 0 returns all of them.
+func (x *Index) Tips(limit int) []string {
+	x.mu.RLock()
+	tips := make([]*node, 0, len(x.tips))
+	for _, n := range x.tips {
+		tips = append(tips, n)
+	}
+	x.mu.RUnlock()
+
+	sort.Slice(tips, func(i, j int) bool { return tips[i].seq > tips[j].seq })
+	if limit > 0 && len(tips) > limit {
+		tips = tips[:limit]
+	}
+	out := make([]string, len(tips))
+	for i, n := range tips {
+		out[i] = n.hash
+	}
+	return out
+}
+
+// Depth returns the length of the longest parent chain below hash.
+func (x *Index) Depth(hash string) (int, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	n, ok := x.nodes[hash]
+	if !ok {
+		return 0, false
+	}
+	return n.depth, true
+}
+
+// Get describes hash.
+func (x *Index) Get(hash string) (Info, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	n, ok := x.nodes[hash]
+	if !ok {
+		return Info{}, false
+	}
+	info := Info{
+		Hash:     n.hash,
+		Parents:  append([]string{}, n.parents...),
+		Children: make([]string, len(n.children)),
+		Depth:    n.depth,
+		Tip:      len(n.children) == 0,
+	}
+	for i, c := range n.children {
+		info.Children[i] = c.hash
+	}
+	return info, true
+}
+
+// IsAncestor reports whether ancestor is reachable from descendant by
+// following parent links. A node is not its own ancestor. The walk skips
+// anything no deeper than ancestor, which cannot lead to it.
+func (x *Index) IsAncestor(ancestor, descendant string) bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	a, ok := x.nodes[ancestor]
+	if !ok {
+		return false
+	}
+	d, ok := x.nodes[descendant]
+	if !ok || d.depth <= a.depth {
+		return false
+	}
+	seen := map[string]bool{descendant: true}
+	stack := []*node{d}
+	for len(stack) > 0 {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, p := range cur.parents {
+			if p == ancestor {
+				return true
+			}
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			if pn, ok := x.nodes[p]; ok && pn.depth > a.depth {
+				stack = append(stack, pn)
+			}
+		}
+	}
+	return false
+}
+
+// Ancestors returns every indexed node reachable from hash through parent
+// links, nearest first.
+func (x *Index) Ancestors(hash string) ([]string, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	n, ok := x.nodes[hash]
+	if !ok {
+		return nil, false
+	}
+	return x.walk(n, func(n *node) []*node {
+		var ps []*node
+		for _, p := range n.parents {
+			if pn, ok := x.nodes[p]; ok {
+				ps = append(ps, pn)
+			}
+		}
+		return ps
+	}), true
+}
+
+// Descendants returns every node that has hash as an ancestor, nearest
+// first.
+func (x *Index) Descendants(hash string) ([]string, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	n, ok := x.nodes[hash]
+	if !ok {
+		return nil, false
+	}
+	return x.walk(n, func(n *node) []*node { return n.children }), true
+}
+
+// walk is a breadth-first traversal from n, excluding n.
+func (x *Index) walk(n *node, next func(*node) []*node) []string {
+	out := []string{}
+	seen := map[*node]bool{n: true}
+	queue := []*node{n}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, m := range next(cur) {
+			if seen[m] {
+				continue
+			}
+			seen[m] = true
+			out = append(out, m.hash)
+			queue = append(queue, m)
+		}
+	}
+	return out
+}
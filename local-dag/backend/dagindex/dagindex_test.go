@@ -0,0 +1,188 @@
+package dagindex
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type edge struct {
+	hash    string
+	parents []string
+}
+
+// diamond is g <- a <- {b, c} <- d, with e hanging off c.
+var diamond = []edge{
+	{"g", nil},
+	{"a", []string{"g"}},
+	{"b", []string{"a"}},
+	{"c", []string{"a"}},
+	{"d", []string{"b", "c"}},
+	{"e", []string{"c"}},
+}
+
+func build(edges []edge) *Index {
+	x := New()
+	for _, e := range edges {
+		x.Add(e.hash, e.parents)
+	}
+	return x
+}
+
+func sorted(s []string) []string {
+	out := append([]string{}, s...)
+	sort.Strings(out)
+	return out
+}
+
+func TestAddDuplicate(t *testing.T) {
+	x := build(diamond)
+	if x.Add("a", []string{"g"}) {
+		t.Error("re-adding a reported true")
+	}
+	if x.Len() != len(diamond) {
+		t.Errorf("Len = %d, want %d", x.Len(), len(diamond))
+	}
+}
+
+func TestDepth(t *testing.T) {
+	tests := []struct {
+		name  string
+		edges []edge
+		hash  string
+		want  int
+		ok    bool
+	}{
+		{"genesis", diamond, "g", 0, true},
+		{"longest path wins", diamond, "d", 3, true},
+		{"side branch", diamond, "e", 3, true},
+		{"unknown", diamond, "zz", 0, false},
+		{"parent outside index", []edge{{"x", []string{"pruned"}}}, "x", 0, true},
+		{"child before parent", []edge{{"b", []string{"a"}}, {"c", []string{"b"}}, {"a", nil}}, "c", 2, true},
+		{"late parent deepens", []edge{{"a", nil}, {"c", []string{"a", "b"}}, {"b", []string{"a"}}}, "c", 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := build(tt.edges).Depth(tt.hash)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("Depth(%s) = %d, %v; want %d, %v", tt.hash, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestTips(t *testing.T) {
+	tests := []struct {
+		name  string
+		edges []edge
+		limit int
+		want  []string
+	}{
+		{"newest first", diamond, 0, []string{"e", "d"}},
+		{"limited", diamond, 1, []string{"e"}},
+		{"parent arriving late is no tip", []edge{{"b", []string{"a"}}, {"a", nil}}, 0, []string{"b"}},
+		{"empty", nil, 0, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := build(tt.edges).Tips(tt.limit); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Tips = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsAncestor(t *testing.T) {
+	x := build(diamond)
+	tests := []struct {
+		ancestor, descendant string
+		want                 bool
+	}{
+		{"g", "d", true},
+		{"a", "e", true},
+		{"b", "d", true},
+		{"c", "e", true},
+		{"b", "e", false},
+		{"d", "a", false},
+		{"d", "d", false},
+		{"b", "c", false},
+		{"zz", "d", false},
+		{"g", "zz", false},
+	}
+	for _, tt := range tests {
+		if got := x.IsAncestor(tt.ancestor, tt.descendant); got != tt.want {
+			t.Errorf("IsAncestor(%s, %s) = %v, want %v", tt.ancestor, tt.descendant, got, tt.want)
+		}
+	}
+}
+
+func TestAncestorsDescendants(t *testing.T) {
+	x := build(diamond)
+	tests := []struct {
+		hash        string
+		ancestors   []string
+		descendants []string
+	}{
+		{"g", []string{}, []string{"a", "b", "c", "d", "e"}},
+		{"c", []string{"a", "g"}, []string{"d", "e"}},
+		{"d", []string{"a", "b", "c", "g"}, []string{}},
+	}
+	for _, tt := range tests {
+		anc, ok := x.Ancestors(tt.hash)
+		if !ok || !reflect.DeepEqual(sorted(anc), tt.ancestors) {
+			t.Errorf("Ancestors(%s) = %v, %v; want %v", tt.hash, anc, ok, tt.ancestors)
+		}
+		desc, ok := x.Descendants(tt.hash)
+		if !ok || !reflect.DeepEqual(sorted(desc), tt.descendants) {
+			t.Errorf("Descendants(%s) = %v, %v; want %v", tt.hash, desc, ok, tt.descendants)
+		}
+	}
+
+	// nearest first
+	if anc, _ := x.Ancestors("d"); anc[len(anc)-1] != "g" {
+		t.Errorf("Ancestors(d) = %v, want g last", anc)
+	}
+	if _, ok := x.Ancestors("zz"); ok {
+		t.Error("Ancestors of unknown hash reported ok")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	x := build(diamond)
+	x.Remove("d", "e", "zz")
+
+	if x.Has("d") || x.Len() != 4 {
+		t.Errorf("after Remove: Has(d) = %v, Len = %d", x.Has("d"), x.Len())
+	}
+	if got, want := x.Tips(0), []string{"c", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Tips = %v, want %v", got, want)
+	}
+
+	// pruning the root keeps descendants' depth and parent hashes
+	x.Remove("g")
+	info, ok := x.Get("a")
+	if !ok || info.Depth != 1 || !reflect.DeepEqual(info.Parents, []string{"g"}) {
+		t.Errorf("Get(a) = %+v, %v", info, ok)
+	}
+	if x.IsAncestor("g", "b") {
+		t.Error("removed node still an ancestor")
+	}
+}
+
+func TestRemoveWaiting(t *testing.T) {
+	x := New()
+	x.Add("b", []string{"a"})
+	x.Remove("b")
+	x.Add("a", nil)
+	if info, _ := x.Get("a"); len(info.Children) != 0 || !info.Tip {
+		t.Errorf("Get(a) = %+v, want a childless tip", info)
+	}
+}
+
+func TestGet(t *testing.T) {
+	info, ok := build(diamond).Get("c")
+	want := Info{Hash: "c", Parents: []string{"a"}, Children: []string{"d", "e"}, Depth: 2}
+	if !ok || !reflect.DeepEqual(info, want) {
+		t.Errorf("Get(c) = %+v, %v; want %+v", info, ok, want)
+	}
+}
@@ -0,0 +1,158 @@
+// Package merkle builds SHA-256 Merkle trees with the RFC 6962 shape and
+// domain separation: leaves hash as H(0x00 || data), interior nodes as
+// H(0x01 || left || right), and a tree of n leaves splits at the largest
+// power of two below n.
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"math/bits"
+)
+
+// LeafHash hashes one leaf's data.
+func LeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// NodeHash hashes two child hashes.
+func NodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// Root returns the tree head of leaves, in order. The empty tree's root is
+// SHA-256 of the empty string.
+func Root(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		h := sha256.Sum256(nil)
+		return h[:]
+	}
+	hashes := make([][]byte, len(leaves))
+	for i, l := range leaves {
+		hashes[i] = LeafHash(l)
+	}
+	return rootOf(hashes)
+}
+
+func rootOf(hashes [][]byte) []byte {
+	if len(hashes) == 1 {
+		return hashes[0]
+	}
+	k := splitPoint(len(hashes))
+	return NodeHash(rootOf(hashes[:k]), rootOf(hashes[k:]))
+}
+
+// splitPoint is the largest power of two strictly below n (n >= 2).
+func splitPoint(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// NodeFunc returns the stored hash of the perfect subtree at level covering
+// leaves [index<<level, (index+1)<<level). Level 0 is the leaf hashes.
+type NodeFunc func(level uint, index uint64) ([]byte, error)
+
+// RangeRoot returns the root of the first size leaves using only perfect
+// subtree hashes from node.
+func RangeRoot(size uint64, node NodeFunc) ([]byte, error) {
+	if size == 0 {
+		h := sha256.Sum256(nil)
+		return h[:], nil
+	}
+	return subtree(0, size, node)
+}
+
+// subtree hashes leaves [lo, hi). Every range RFC 6962 recursion produces
+// starts on a multiple of its left half, so perfect ranges are aligned.
+func subtree(lo, hi uint64, node NodeFunc) ([]byte, error) {
+	n := hi - lo
+	if n&(n-1) == 0 {
+		level := uint(bits.TrailingZeros64(n))
+		return node(level, lo>>level)
+	}
+	k := uint64(splitPoint(int(n)))
+	left, err := subtree(lo, lo+k, node)
+	if err != nil {
+		return nil, err
+	}
+	right, err := subtree(lo+k, hi, node)
+	if err != nil {
+		return nil, err
+	}
+	return NodeHash(left, right), nil
+}
+
+// InclusionPath returns the RFC 6962 audit path for leaf index in a tree of
+// size leaves, ordered from the leaf up.
+func InclusionPath(index, size uint64, node NodeFunc) ([][]byte, error) {
+	if index >= size {
+		return nil, fmt.Errorf("leaf %d outside tree of size %d", index, size)
+	}
+	return path(index, 0, size, node)
+}
+
+func path(m, lo, hi uint64, node NodeFunc) ([][]byte, error) {
+	n := hi - lo
+	if n == 1 {
+		return nil, nil
+	}
+	k := uint64(splitPoint(int(n)))
+	if m < k {
+		p, err := path(m, lo, lo+k, node)
+		if err != nil {
+			return nil, err
+		}
+		sib, err := subtree(lo+k, hi, node)
+		if err != nil {
+			return nil, err
+		}
+		return append(p, sib), nil
+	}
+	p, err := path(m-k, lo+k, hi, node)
+	if err != nil {
+		return nil, err
+	}
+	sib, err := subtree(lo, lo+k, node)
+	if err != nil {
+		return nil, err
+	}
+	return append(p, sib), nil
+}
+
+// VerifyInclusion checks an audit path for leafHash at index in a tree of
+// size leaves against root (RFC 9162, section 2.1.3.2).
+func VerifyInclusion(leafHash []byte, index, size uint64, auditPath [][]byte, root []byte) bool {
+	if index >= size {
+		return false
+	}
+	fn, sn := index, size-1
+	r := leafHash
+	for _, p := range auditPath {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = NodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = NodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(r, root)
+}
@@ -0,0 +1,174 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func testLeaves(n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		leaves[i] = []byte(fmt.Sprintf("leaf-%d", i))
+	}
+	return leaves
+}
+
+// storedNodes mimics the tx log: perfect subtree hashes computed from the
+// leaf hashes, looked up by level and index.
+func storedNodes(leaves [][]byte) NodeFunc {
+	return func(level uint, index uint64) ([]byte, error) {
+		lo := index << level
+		hi := lo + 1<<level
+		if hi > uint64(len(leaves)) {
+			return nil, fmt.Errorf("node %d/%d not stored", level, index)
+		}
+		hashes := make([][]byte, 0, hi-lo)
+		for _, l := range leaves[lo:hi] {
+			hashes = append(hashes, LeafHash(l))
+		}
+		return rootOf(hashes), nil
+	}
+}
+
+func TestRootVectors(t *testing.T) {
+	tests := []struct {
+		name   string
+		leaves [][]byte
+		want   string
+	}{
+		{"empty", nil, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{"one empty leaf", [][]byte{{}}, "6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hex.EncodeToString(Root(tt.leaves)); got != tt.want {
+				t.Errorf("Root = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRootShape(t *testing.T) {
+	a, b, c := []byte("a"), []byte("b"), []byte("c")
+	tests := []struct {
+		name   string
+		leaves [][]byte
+		want   []byte
+	}{
+		{"one", [][]byte{a}, LeafHash(a)},
+		{"two", [][]byte{a, b}, NodeHash(LeafHash(a), LeafHash(b))},
+		{"three splits 2+1", [][]byte{a, b, c}, NodeHash(NodeHash(LeafHash(a), LeafHash(b)), LeafHash(c))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Root(tt.leaves); !bytes.Equal(got, tt.want) {
+				t.Errorf("Root = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitPoint(t *testing.T) {
+	tests := []struct{ n, want int }{
+		{2, 1}, {3, 2}, {4, 2}, {5, 4}, {8, 4}, {9, 8}, {17, 16},
+	}
+	for _, tt := range tests {
+		if got := splitPoint(tt.n); got != tt.want {
+			t.Errorf("splitPoint(%d) = %d, want %d", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestRangeRoot(t *testing.T) {
+	for _, size := range []int{0, 1, 2, 3, 4, 5, 7, 8, 9, 13, 16, 31} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			leaves := testLeaves(size)
+			got, err := RangeRoot(uint64(size), storedNodes(leaves))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := Root(leaves); !bytes.Equal(got, want) {
+				t.Errorf("RangeRoot = %x, want %x", got, want)
+			}
+		})
+	}
+}
+
+func TestInclusionPath(t *testing.T) {
+	tests := []struct {
+		size    int
+		pathLen []int // expected path length per index
+	}{
+		{1, []int{0}},
+		{2, []int{1, 1}},
+		{3, []int{2, 2, 1}},
+		{5, []int{3, 3, 3, 3, 1}},
+		{7, []int{3, 3, 3, 3, 3, 3, 2}},
+		{8, []int{3, 3, 3, 3, 3, 3, 3, 3}},
+	}
+	for _, tt := range tests {
+		leaves := testLeaves(tt.size)
+		node := storedNodes(leaves)
+		root := Root(leaves)
+		for i := 0; i < tt.size; i++ {
+			t.Run(fmt.Sprintf("%d/%d", i, tt.size), func(t *testing.T) {
+				p, err := InclusionPath(uint64(i), uint64(tt.size), node)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(p) != tt.pathLen[i] {
+					t.Errorf("path length = %d, want %d", len(p), tt.pathLen[i])
+				}
+				if !VerifyInclusion(LeafHash(leaves[i]), uint64(i), uint64(tt.size), p, root) {
+					t.Error("valid path rejected")
+				}
+			})
+		}
+	}
+}
+
+func TestInclusionPathOutOfRange(t *testing.T) {
+	if _, err := InclusionPath(4, 4, storedNodes(testLeaves(4))); err == nil {
+		t.Error("index == size accepted")
+	}
+}
+
+func TestVerifyInclusionRejects(t *testing.T) {
+	leaves := testLeaves(6)
+	root := Root(leaves)
+	leaf := LeafHash(leaves[2])
+	p, err := InclusionPath(2, 6, storedNodes(leaves))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([][]byte{}, p...)
+	tampered[0] = sha256.New().Sum(nil)
+
+	tests := []struct {
+		name  string
+		leaf  []byte
+		index uint64
+		size  uint64
+		path  [][]byte
+		root  []byte
+	}{
+		{"wrong leaf", LeafHash(leaves[3]), 2, 6, p, root},
+		{"wrong index", leaf, 3, 6, p, root},
+		{"wrong size", leaf, 2, 3, p, root},
+		{"index outside tree", leaf, 6, 6, p, root},
+		{"tampered sibling", leaf, 2, 6, tampered, root},
+		{"short path", leaf, 2, 6, p[:len(p)-1], root},
+		{"extra hash", leaf, 2, 6, append(append([][]byte{}, p...), root), root},
+		{"wrong root", leaf, 2, 6, p, Root(leaves[:5])},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if VerifyInclusion(tt.leaf, tt.index, tt.size, tt.path, tt.root) {
+				t.Error("invalid proof accepted")
+			}
+		})
+	}
+}
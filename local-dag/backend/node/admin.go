@@ -0,0 +1,26 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminToken guards /api/admin routes. Empty disables them.
+var AdminToken string
+
+// requireAdmin accepts "Authorization: Bearer <ADMIN_TOKEN>".
+func requireAdmin(c *gin.Context) {
+	if AdminToken == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin_disabled"})
+		return
+	}
+	got, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(AdminToken)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	c.Next()
+}
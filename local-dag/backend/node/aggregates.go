@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"time"
+	_ "time/tzdata" // callers name arbitrary IANA zones; don't depend on the image's zoneinfo
+
+	"github.com/gin-gonic/gin"
+)
+
+// aggregateBucket is one day/week/month of an account's activity. Sums are in
+// minor units; net is sum_in - sum_out.
+type aggregateBucket struct {
+	PeriodStart string `json:"period_start"`
+	CountIn     int64  `json:"count_in"`
+	SumIn       int64  `json:"sum_in"`
+	CountOut    int64  `json:"count_out"`
+	SumOut      int64  `json:"sum_out"`
+	Count       int64  `json:"count"`
+	Net         int64  `json:"net"`
+}
+
+func (b *aggregateBucket) add(o aggregateBucket) {
+	b.CountIn += o.CountIn
+	b.SumIn += o.SumIn
+	b.CountOut += o.CountOut
+	b.SumOut += o.SumOut
+	b.Count += o.Count
+	b.Net += o.Net
+}
+
+// HandlerAccountAggregates answers GET /api/aggregates from the 15-minute
+// local_account_rollups table.
+//
+// Query: accountId (required), currency (default DefaultCurrency),
+// groupBy (day|week|month, default day), tz (IANA name, default UTC),
+// from/to (RFC 3339; default today in tz). Weeks start on Monday.
+func HandlerAccountAggregates(c *gin.Context) {
+	if refuseIfBootstrapped(c) {
+		return
+	}
+	account := c.Query("accountId")
+	if account == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing_account"})
+		return
+	}
+	currency := c.DefaultQuery("currency", DefaultCurrency)
+	groupBy := c.DefaultQuery("groupBy", "day")
+	switch groupBy {
+	case "day", "week", "month":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_group_by"})
+		return
+	}
+	tzName := c.DefaultQuery("tz", "UTC")
+	loc, err := time.LoadLocation(tzName)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_tz"})
+		return
+	}
+
+	now := time.Now().In(loc)
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	to := from.AddDate(0, 0, 1)
+	if v := c.Query("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_from"})
+			return
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_to"})
+			return
+		}
+	}
+	if !to.After(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "empty_range"})
+		return
+	}
+
+	rows, err := DB.QueryContext(c.Request.Context(), `
+		SELECT date_trunc($2, bucket_start AT TIME ZONE $3) AS period,
+		       SUM(count_in), SUM(sum_in), SUM(count_out), SUM(sum_out)
+		FROM local_account_rollups
+		WHERE account_id=$1 AND currency=$6 AND bucket_start >= $4 AND bucket_start < $5
+		GROUP BY period
+		ORDER BY period
+	`, account, groupBy, tzName, from.UTC(), to.UTC(), currency)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_aggregates", "details": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	buckets := []aggregateBucket{}
+	var totals aggregateBucket
+	for rows.Next() {
+		var period time.Time // wall-clock time in tz, returned without zone
+		var b aggregateBucket
+		if err := rows.Scan(&period, &b.CountIn, &b.SumIn, &b.CountOut, &b.SumOut); err != nil {
+			c.JSON(500, gin.H{"error": "db_scan_aggregate", "details": err.Error()})
+			return
+		}
+		start := time.Date(period.Year(), period.Month(), period.Day(), 0, 0, 0, 0, loc)
+		b.PeriodStart = start.Format(time.RFC3339)
+		b.Count = b.CountIn + b.CountOut
+		b.Net = b.SumIn - b.SumOut
+		buckets = append(buckets, b)
+		totals.add(b)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(500, gin.H{"error": "db_aggregates", "details": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"ok":        true,
+		"accountId": account,
+		"currency":  currency,
+		"groupBy":   groupBy,
+		"tz":        tzName,
+		"from":      from.In(loc).Format(time.RFC3339),
+		"to":        to.In(loc).Format(time.RFC3339),
+		"buckets":   buckets,
+		"totals":    totals,
+	})
+}
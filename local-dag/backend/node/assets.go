@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var assetSymbolRe = regexp.MustCompile(`^[A-Z][A-Z0-9.]{0,11}$`)
+
+type asset struct {
+	Symbol         string `json:"symbol"`
+	Name           string `json:"name"`
+	IssuerPublicID string `json:"issuer_public_id"`
+	QuoteCurrency  string `json:"quote_currency"`
+	CreatedAt      string `json:"created_at"`
+}
+
+type position struct {
+	Symbol   string `json:"symbol"`
+	Quantity int64  `json:"quantity"`
+}
+
+// HandlerAdminCreateAsset registers a symbol by appending an issue_asset tx
+// that credits the issuer with the initial supply.
+func HandlerAdminCreateAsset(c *gin.Context) {
+	var req struct {
+		Symbol         string `json:"symbol"`
+		Name           string `json:"name"`
+		IssuerPublicID string `json:"issuer_public_id"`
+		QuoteCurrency  string `json:"quote_currency"`
+		Supply         int64  `json:"supply"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request", "details": err.Error()})
+		return
+	}
+	if !assetSymbolRe.MatchString(req.Symbol) || req.IssuerPublicID == "" || req.Supply < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_asset"})
+		return
+	}
+	if req.Name == "" {
+		req.Name = req.Symbol
+	}
+	if req.QuoteCurrency == "" {
+		req.QuoteCurrency = DefaultCurrency
+	}
+	ctx := c.Request.Context()
+
+	if _, err := currencyScale(ctx, req.QuoteCurrency); err != nil || req.QuoteCurrency == noCurrency {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown_currency", "currency": req.QuoteCurrency})
+		return
+	}
+
+	var exists bool
+	if err := DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM local_assets WHERE symbol=$1)`, req.Symbol).Scan(&exists); err != nil {
+		c.JSON(500, gin.H{"error": "db_lookup_asset", "details": err.Error()})
+		return
+	}
+	if exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "asset_exists"})
+		return
+	}
+
+	parents, err := selectParents(ctx)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_select_parents", "details": err.Error()})
+		return
+	}
+	payload, _ := json.Marshal(map[string]string{"symbol": req.Symbol, "name": req.Name, "quote_currency": req.QuoteCurrency})
+	t := localTx{
+		FromPublicID: req.IssuerPublicID,
+		ToPublicID:   req.IssuerPublicID,
+		Amount:       req.Supply,
+		Currency:     noCurrency,
+		TxType:       "issue_asset",
+		TsUnixMs:     time.Now().UnixMilli(),
+		Payload:      payload,
+		Parents:      parents,
+	}
+	msg, err := commitLocalTx(ctx, t)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_insert_tx", "details": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true, "symbol": req.Symbol, "tx_hash": msg.TxHash})
+
+	go announceTx(msg, parents)
+}
+
+// HandlerListAssets returns the asset registry.
+func HandlerListAssets(c *gin.Context) {
+	rows, err := DB.QueryContext(c.Request.Context(), `
+		SELECT symbol, name, issuer_public_id, quote_currency, created_at FROM local_assets ORDER BY symbol
+	`)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_list_assets", "details": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	assets := []asset{}
+	for rows.Next() {
+		var a asset
+		var ts time.Time
+		if err := rows.Scan(&a.Symbol, &a.Name, &a.IssuerPublicID, &a.QuoteCurrency, &ts); err != nil {
+			c.JSON(500, gin.H{"error": "db_scan_asset", "details": err.Error()})
+			return
+		}
+		a.CreatedAt = ts.UTC().Format(time.RFC3339)
+		assets = append(assets, a)
+	}
+	c.JSON(200, gin.H{"ok": true, "assets": assets})
+}
+
+// HandlerAccountPositions returns an account's non-zero asset positions.
+func HandlerAccountPositions(c *gin.Context) {
+	if refuseIfBootstrapped(c) {
+		return
+	}
+	rows, err := DB.QueryContext(c.Request.Context(), `
+		SELECT symbol, quantity FROM local_asset_positions
+		WHERE account_id=$1 AND quantity <> 0
+		ORDER BY symbol
+	`, c.Param("public_id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_list_positions", "details": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	positions := []position{}
+	for rows.Next() {
+		var p position
+		if err := rows.Scan(&p.Symbol, &p.Quantity); err != nil {
+			c.JSON(500, gin.H{"error": "db_scan_position", "details": err.Error()})
+			return
+		}
+		positions = append(positions, p)
+	}
+	c.JSON(200, gin.H{"ok": true, "account_id": c.Param("public_id"), "positions": positions})
+}
+
+// assetIssueTx returns the hash of symbol's issue_asset tx, or "" if it has
+// been pruned (siblings then hold the asset already).
+func assetIssueTx(ctx context.Context, symbol string) (string, error) {
+	var h string
+	err := DB.QueryRowContext(ctx, `
+		SELECT d.tx_hash FROM local_dag_nodes d JOIN local_ledger l ON l.id = d.ledger_id
+		WHERE l.tx_type='issue_asset' AND l.payload->>'symbol'=$1
+		LIMIT 1
+	`, symbol).Scan(&h)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return h, err
+}
+
+// assetRegistered reports whether a gossiped trade_asset leg's symbol is
+// known here. Legs name their issue tx as a parent, but one that doesn't
+// must still wait for it rather than fail on the positions FK.
+func assetRegistered(ctx context.Context, t localTx) (bool, error) {
+	if t.TxType != "trade_asset" {
+		return true, nil
+	}
+	var p struct {
+		Symbol string `json:"symbol"`
+	}
+	_ = json.Unmarshal(t.Payload, &p)
+	var ok bool
+	err := DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM local_assets WHERE symbol=$1)`, p.Symbol).Scan(&ok)
+	return ok, err
+}
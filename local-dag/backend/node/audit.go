@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"hackodisha/backend/txproof"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Global verifiers audit settled txs at random: they challenge the node that
+// settled one to hand back the tx, its signatures and quorum votes, and its
+// tx log inclusion proof. Wrong or missing answers count against us there.
+
+// auditChallenge is signed by the auditing global verifier over
+// "audit|local_dag_hash|nonce".
+type auditChallenge struct {
+	LocalDagHash string         `json:"local_dag_hash"`
+	Nonce        string         `json:"nonce"`
+	Auditor      signedEnvelope `json:"auditor"`
+}
+
+// auditResponse binds the answer to the challenge nonce through Responder,
+// a signature over "audit-response|local_dag_hash|nonce|sha256(tx_body)".
+type auditResponse struct {
+	Item      settlementItem `json:"item"`
+	Proof     txproof.Proof  `json:"proof"`
+	Responder signedEnvelope `json:"responder"`
+}
+
+func auditMessage(localDagHash, nonce string) []byte {
+	return []byte(fmt.Sprintf("audit|%s|%s", localDagHash, nonce))
+}
+
+func auditResponseMessage(localDagHash, nonce string, txBody []byte) []byte {
+	h := sha256.Sum256(txBody)
+	return []byte(fmt.Sprintf("audit-response|%s|%s|%s", localDagHash, nonce, hex.EncodeToString(h[:])))
+}
+
+// === Handlers ===
+
+// HandlerPeerAudit answers a global verifier's audit challenge for one of
+// our settled txs that has not been pruned yet.
+func HandlerPeerAudit(c *gin.Context) {
+	var ch auditChallenge
+	if err := c.BindJSON(&ch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request", "details": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	if err := verifyGlobalEnvelope(ctx, ch.Auditor, auditMessage(ch.LocalDagHash, ch.Nonce)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "audit_verification_failed", "reason": err.Error()})
+		return
+	}
+
+	it := settlementItem{TxHash: ch.LocalDagHash}
+	var body string
+	err := DB.QueryRowContext(ctx, `
+		SELECT tx_body, node_signature FROM local_dag_nodes WHERE tx_hash=$1
+	`, ch.LocalDagHash).Scan(&body, &it.NodeSignature)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tx_not_found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_lookup_tx", "details": err.Error()})
+		return
+	}
+	it.TxBody = []byte(body)
+	if it.Votes, err = loadQuorumProof(ctx, it.TxHash); err != nil {
+		c.JSON(500, gin.H{"error": "db_load_votes", "details": err.Error()})
+		return
+	}
+	proof, err := txInclusionProof(ctx, it.TxHash)
+	if err == errTxNotLogged {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tx_not_logged"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "txlog_proof", "details": err.Error()})
+		return
+	}
+	env, err := signEnvelope(auditResponseMessage(it.TxHash, ch.Nonce, it.TxBody))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "sign_failed", "details": err.Error()})
+		return
+	}
+	c.JSON(200, auditResponse{Item: it, Proof: proof, Responder: env})
+}
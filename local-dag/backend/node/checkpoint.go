@@ -0,0 +1,831 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"hackodisha/backend/merkle"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Checkpoints: every CheckpointInterval a node proposes the balances implied
+// by a settled DAG frontier, built on the previous signed checkpoint;
+// siblings recompute them, and once Quorum nodes have signed the same root
+// the checkpoint is committed everywhere. Txs covered by the
+// CheckpointRetain-th newest checkpoint are pruned.
+var (
+	CheckpointInterval  = 10 * time.Minute
+	CheckpointRetain    = 3 // 0 disables pruning
+	BootstrapCheckpoint bool
+)
+
+var errCheckpointBaseUnknown = errors.New("checkpoint base unknown")
+
+// checkpoint is the signed header; balances travel separately.
+type checkpoint struct {
+	Hash          string   `json:"hash"`
+	Seq           int64    `json:"seq"`
+	Base          string   `json:"base,omitempty"` // previous signed checkpoint
+	Proposer      string   `json:"proposer"`
+	Frontier      []string `json:"frontier"` // sorted tx_hashes
+	BalancesRoot  string   `json:"balances_root"`
+	BalanceCount  int      `json:"balance_count"`
+	CreatedUnixMs int64    `json:"created_unix_ms"`
+}
+
+// checkpointBalance is one Merkle leaf: "account_id|currency|balance".
+type checkpointBalance struct {
+	AccountID string `json:"account_id"`
+	Currency  string `json:"currency"`
+	Balance   int64  `json:"balance"`
+}
+
+func (b checkpointBalance) leaf() []byte {
+	return []byte(fmt.Sprintf("%s|%s|%d", b.AccountID, b.Currency, b.Balance))
+}
+
+// checkpointMessage is what proposer and signers sign; its sha256 is the
+// checkpoint hash.
+func checkpointMessage(cp checkpoint) []byte {
+	return []byte(fmt.Sprintf("checkpoint|%d|%s|%s|%s|%s|%d|%d",
+		cp.Seq, cp.Base, cp.Proposer, parentsDigest(cp.Frontier), cp.BalancesRoot, cp.BalanceCount, cp.CreatedUnixMs))
+}
+
+func checkpointHash(cp checkpoint) string {
+	h := sha256.Sum256(checkpointMessage(cp))
+	return hex.EncodeToString(h[:])
+}
+
+// balancesRoot sorts balances by (account, currency) and returns their
+// Merkle root.
+func balancesRoot(balances []checkpointBalance) string {
+	sort.Slice(balances, func(i, j int) bool {
+		if balances[i].AccountID != balances[j].AccountID {
+			return balances[i].AccountID < balances[j].AccountID
+		}
+		return balances[i].Currency < balances[j].Currency
+	})
+	leaves := make([][]byte, len(balances))
+	for i, b := range balances {
+		leaves[i] = b.leaf()
+	}
+	return hex.EncodeToString(merkle.Root(leaves))
+}
+
+// === Balances at a frontier ===
+
+// frontierBalances computes the balances at cp.Frontier: those of cp.Base,
+// which must be the signed checkpoint just before cp, plus every tx
+// reachable from the frontier but not from the base's. Rejected and
+// quarantined txs never count. Zero balances are left out so every node
+// produces the same leaves.
+func frontierBalances(ctx context.Context, cp checkpoint) ([]checkpointBalance, error) {
+	sums := map[[2]string]int64{}
+	baseFrontier := []string{}
+	if cp.Base != "" {
+		var baseSeq int64
+		err := DB.QueryRowContext(ctx, `
+			SELECT seq, frontier FROM local_checkpoints WHERE hash=$1 AND status='signed'
+		`, cp.Base).Scan(&baseSeq, pq.Array(&baseFrontier))
+		if err == sql.ErrNoRows || (err == nil && baseSeq != cp.Seq-1) {
+			return nil, errCheckpointBaseUnknown
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows, err := DB.QueryContext(ctx, `
+			SELECT account_id, currency, balance FROM local_checkpoint_balances WHERE checkpoint_hash=$1
+		`, cp.Base)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var acct, cur string
+			var bal int64
+			if err := rows.Scan(&acct, &cur, &bal); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			sums[[2]string{acct, cur}] += bal
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := DB.QueryContext(ctx, `
+		WITH RECURSIVE cover(tx_hash) AS (
+		  SELECT unnest($1::text[])
+		  UNION
+		  SELECT p FROM cover c JOIN local_dag_nodes d ON d.tx_hash = c.tx_hash CROSS JOIN LATERAL unnest(d.parents) AS p
+		), base(tx_hash) AS (
+		  SELECT unnest($2::text[])
+		  UNION
+		  SELECT p FROM base b JOIN local_dag_nodes d ON d.tx_hash = b.tx_hash CROSS JOIN LATERAL unnest(d.parents) AS p
+		), covered AS (
+		  SELECT l.* FROM (SELECT tx_hash FROM cover EXCEPT SELECT tx_hash FROM base) c
+		  JOIN local_dag_nodes d ON d.tx_hash = c.tx_hash JOIN local_ledger l ON l.id = d.ledger_id
+		  WHERE l.tx_type NOT IN ('issue_asset','trade_asset','bulk_import')
+		    AND d.status NOT IN ('rejected','quarantined')
+		)
+		SELECT account_id, currency, SUM(delta)::bigint FROM (
+		  SELECT from_public_id AS account_id, currency, -amount AS delta FROM covered
+		  UNION ALL
+		  SELECT to_public_id, COALESCE(counter_currency, currency), COALESCE(counter_amount, amount) FROM covered
+		) legs
+		GROUP BY account_id, currency
+	`, pq.Array(cp.Frontier), pq.Array(baseFrontier))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var acct, cur string
+		var bal int64
+		if err := rows.Scan(&acct, &cur, &bal); err != nil {
+			return nil, err
+		}
+		sums[[2]string{acct, cur}] += bal
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	balances := make([]checkpointBalance, 0, len(sums))
+	for k, v := range sums {
+		if v != 0 {
+			balances = append(balances, checkpointBalance{AccountID: k[0], Currency: k[1], Balance: v})
+		}
+	}
+	return balances, nil
+}
+
+// settledFrontier returns the tips of the txs that have cleared local
+// consensus and are older than ConsensusTimeout, sorted.
+func settledFrontier(ctx context.Context) ([]string, error) {
+	rows, err := DB.QueryContext(ctx, `
+		WITH settled AS (
+		  SELECT tx_hash, parents FROM local_dag_nodes
+		  WHERE status IN ('local_confirmed','submitted_global','finalized')
+		    AND created_at < NOW() - $1 * interval '1 second'
+		)
+		SELECT s.tx_hash FROM settled s
+		WHERE NOT EXISTS (SELECT 1 FROM settled c WHERE s.tx_hash = ANY(c.parents))
+		ORDER BY s.tx_hash
+	`, ConsensusTimeout.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	frontier := []string{}
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			return nil, err
+		}
+		frontier = append(frontier, h)
+	}
+	return frontier, rows.Err()
+}
+
+// === Storage ===
+
+func storeCheckpoint(ctx context.Context, cp checkpoint, balances []checkpointBalance, sigs []signedEnvelope, signed, bootstrap bool) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO local_checkpoints (hash, seq, base, proposer, frontier, balances_root, balance_count, created_unix_ms, status, bootstrap, signed_at)
+		VALUES ($1,$2,NULLIF($10,''),$3,$4,$5,$6,$7,CASE WHEN $8 THEN 'signed' ELSE 'proposed' END,$9,CASE WHEN $8 THEN NOW() END)
+		ON CONFLICT (hash) DO UPDATE
+		  SET status = CASE WHEN $8 THEN 'signed' ELSE local_checkpoints.status END,
+		      signed_at = COALESCE(local_checkpoints.signed_at, EXCLUDED.signed_at)
+	`, cp.Hash, cp.Seq, cp.Proposer, pq.Array(cp.Frontier), cp.BalancesRoot, cp.BalanceCount, cp.CreatedUnixMs, signed, bootstrap, cp.Base)
+	if err != nil {
+		return err
+	}
+	for _, b := range balances {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO local_checkpoint_balances (checkpoint_hash, account_id, currency, balance)
+			VALUES ($1,$2,$3,$4)
+			ON CONFLICT DO NOTHING
+		`, cp.Hash, b.AccountID, b.Currency, b.Balance); err != nil {
+			return err
+		}
+	}
+	for _, env := range sigs {
+		envJSON, _ := json.Marshal(env)
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO local_checkpoint_signatures (checkpoint_hash, node_id, envelope)
+			VALUES ($1,$2,$3::jsonb)
+			ON CONFLICT DO NOTHING
+		`, cp.Hash, env.NodeID, string(envJSON)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+var errCheckpointNotFound = errors.New("checkpoint not found")
+
+// loadCheckpoint returns the newest signed checkpoint, or the one with hash
+// when it is not empty.
+func loadCheckpoint(ctx context.Context, hash string) (checkpoint, []signedEnvelope, []checkpointBalance, error) {
+	var cp checkpoint
+	err := DB.QueryRowContext(ctx, `
+		SELECT hash, seq, COALESCE(base, ''), proposer, frontier, balances_root, balance_count, created_unix_ms
+		FROM local_checkpoints
+		WHERE status='signed' AND ($1 = '' OR hash=$1)
+		ORDER BY seq DESC, signed_at DESC
+		LIMIT 1
+	`, hash).Scan(&cp.Hash, &cp.Seq, &cp.Base, &cp.Proposer, pq.Array(&cp.Frontier), &cp.BalancesRoot, &cp.BalanceCount, &cp.CreatedUnixMs)
+	if err == sql.ErrNoRows {
+		return cp, nil, nil, errCheckpointNotFound
+	}
+	if err != nil {
+		return cp, nil, nil, err
+	}
+
+	sigs := []signedEnvelope{}
+	rows, err := DB.QueryContext(ctx, `
+		SELECT envelope FROM local_checkpoint_signatures WHERE checkpoint_hash=$1 ORDER BY node_id
+	`, cp.Hash)
+	if err != nil {
+		return cp, nil, nil, err
+	}
+	for rows.Next() {
+		var raw []byte
+		var env signedEnvelope
+		if err := rows.Scan(&raw); err != nil {
+			rows.Close()
+			return cp, nil, nil, err
+		}
+		if json.Unmarshal(raw, &env) == nil {
+			sigs = append(sigs, env)
+		}
+	}
+	rows.Close()
+
+	balances := []checkpointBalance{}
+	rows, err = DB.QueryContext(ctx, `
+		SELECT account_id, currency, balance FROM local_checkpoint_balances
+		WHERE checkpoint_hash=$1
+		ORDER BY account_id, currency
+	`, cp.Hash)
+	if err != nil {
+		return cp, nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b checkpointBalance
+		if err := rows.Scan(&b.AccountID, &b.Currency, &b.Balance); err != nil {
+			return cp, nil, nil, err
+		}
+		balances = append(balances, b)
+	}
+	return cp, sigs, balances, rows.Err()
+}
+
+// verifyCheckpointQuorum checks that at least Quorum distinct nodes signed cp.
+func verifyCheckpointQuorum(ctx context.Context, cp checkpoint, sigs []signedEnvelope) error {
+	if checkpointHash(cp) != cp.Hash {
+		return fmt.Errorf("checkpoint hash mismatch")
+	}
+	msg := checkpointMessage(cp)
+	seen := map[string]bool{}
+	for _, env := range sigs {
+		if seen[env.NodeID] {
+			continue
+		}
+		if err := verifyEnvelope(ctx, env, msg); err != nil {
+			return fmt.Errorf("signature of %s: %w", env.NodeID, err)
+		}
+		seen[env.NodeID] = true
+	}
+	if len(seen) < Quorum {
+		return fmt.Errorf("%d signatures, quorum is %d", len(seen), Quorum)
+	}
+	return nil
+}
+
+// === Proposing ===
+
+type checkpointProposal struct {
+	Checkpoint checkpoint     `json:"checkpoint"`
+	Signature  signedEnvelope `json:"signature"`
+}
+
+// checkpointCommit carries the balances too, so a node that missed the
+// proposal (or its base) can store it on the quorum's word.
+type checkpointCommit struct {
+	Checkpoint checkpoint          `json:"checkpoint"`
+	Signatures []signedEnvelope    `json:"signatures"`
+	Balances   []checkpointBalance `json:"balances,omitempty"`
+}
+
+// proposeCheckpoint builds a checkpoint over the settled frontier, collects
+// sibling signatures and, with a quorum, commits it cluster-wide.
+func proposeCheckpoint(ctx context.Context) error {
+	frontier, err := settledFrontier(ctx)
+	if err != nil {
+		return err
+	}
+	if len(frontier) == 0 {
+		return nil
+	}
+	var seq int64
+	var base string
+	var lastFrontier []string
+	err = DB.QueryRowContext(ctx, `
+		SELECT seq, hash, frontier FROM local_checkpoints WHERE status='signed' ORDER BY seq DESC, signed_at DESC LIMIT 1
+	`).Scan(&seq, &base, pq.Array(&lastFrontier))
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if strings.Join(lastFrontier, ",") == strings.Join(frontier, ",") {
+		return nil
+	}
+
+	cp := checkpoint{
+		Seq:           seq + 1,
+		Base:          base,
+		Proposer:      NodeID,
+		Frontier:      frontier,
+		CreatedUnixMs: time.Now().UnixMilli(),
+	}
+	balances, err := frontierBalances(ctx, cp)
+	if err != nil {
+		return err
+	}
+	cp.BalancesRoot, cp.BalanceCount = balancesRoot(balances), len(balances)
+	cp.Hash = checkpointHash(cp)
+	own, err := signEnvelope(checkpointMessage(cp))
+	if err != nil {
+		return err
+	}
+	if err := storeCheckpoint(ctx, cp, balances, []signedEnvelope{own}, false, false); err != nil {
+		return err
+	}
+
+	sigs := append([]signedEnvelope{own}, collectCheckpointSignatures(ctx, checkpointProposal{Checkpoint: cp, Signature: own})...)
+	if err := verifyCheckpointQuorum(ctx, cp, sigs); err != nil {
+		log.Printf("checkpoint: seq=%d hash=%s not committed: %v", cp.Seq, cp.Hash, err)
+		return nil
+	}
+	if err := storeCheckpoint(ctx, cp, nil, sigs, true, false); err != nil {
+		return err
+	}
+	log.Printf("checkpoint: seq=%d hash=%s root=%s balances=%d signatures=%d", cp.Seq, cp.Hash, cp.BalancesRoot, cp.BalanceCount, len(sigs))
+	broadcastCheckpoint(checkpointCommit{Checkpoint: cp, Signatures: sigs, Balances: balances})
+	return nil
+}
+
+// collectCheckpointSignatures asks every sibling to co-sign p. A sibling
+// missing p's base is sent the base commit and asked once more.
+func collectCheckpointSignatures(ctx context.Context, p checkpointProposal) []signedEnvelope {
+	client := &http.Client{Timeout: 10 * time.Second}
+	body, _ := json.Marshal(p)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var sigs []signedEnvelope
+	for _, peer := range PeersList {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			url := strings.TrimRight(peer, "/") + "/peer/checkpoint"
+			for attempt := 0; attempt < 2; attempt++ {
+				req, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				resp, err := client.Do(req)
+				if err != nil {
+					return
+				}
+				var out struct {
+					Signature *signedEnvelope `json:"signature"`
+					Error     string          `json:"error"`
+				}
+				_ = json.NewDecoder(resp.Body).Decode(&out)
+				_ = resp.Body.Close()
+				if resp.StatusCode == http.StatusConflict && out.Error == "checkpoint_base_unknown" && attempt == 0 {
+					if sendCheckpointBase(ctx, client, peer, p.Checkpoint.Base) == nil {
+						continue
+					}
+				}
+				if resp.StatusCode != http.StatusOK || out.Signature == nil {
+					log.Printf("checkpoint: hash=%s peer=%s declined: %d %s", p.Checkpoint.Hash, peer, resp.StatusCode, out.Error)
+					return
+				}
+				mu.Lock()
+				sigs = append(sigs, *out.Signature)
+				mu.Unlock()
+				return
+			}
+		}(peer)
+	}
+	wg.Wait()
+	return sigs
+}
+
+// sendCheckpointBase commits the signed checkpoint hash, with its balances,
+// to one peer.
+func sendCheckpointBase(ctx context.Context, client *http.Client, peer, hash string) error {
+	cp, sigs, balances, err := loadCheckpoint(ctx, hash)
+	if err != nil {
+		return err
+	}
+	body, _ := json.Marshal(checkpointCommit{Checkpoint: cp, Signatures: sigs, Balances: balances})
+	url := strings.TrimRight(peer, "/") + "/peer/checkpoint/commit"
+	req, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func broadcastCheckpoint(cm checkpointCommit) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	body, _ := json.Marshal(cm)
+	for _, p := range PeersList {
+		go func(peer string) {
+			url := strings.TrimRight(peer, "/") + "/peer/checkpoint/commit"
+			req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := client.Do(req)
+			if err != nil {
+				return
+			}
+			_ = resp.Body.Close()
+		}(p)
+	}
+}
+
+// checkpointLoop proposes a checkpoint whenever the newest signed one is
+// older than CheckpointInterval, then prunes what retention allows.
+func checkpointLoop(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		var recent bool
+		_ = DB.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM local_checkpoints WHERE status='signed' AND signed_at > NOW() - $1 * interval '1 second')
+		`, interval.Seconds()).Scan(&recent)
+		if !recent {
+			if err := proposeCheckpoint(ctx); err != nil {
+				log.Printf("checkpoint: propose failed: %v", err)
+			}
+		}
+		if CheckpointRetain > 0 {
+			if n, err := pruneCovered(ctx); err != nil {
+				log.Printf("checkpoint: prune failed: %v", err)
+			} else if n > 0 {
+				log.Printf("checkpoint: pruned %d txs", n)
+			}
+		}
+		cancel()
+	}
+}
+
+// === Pruning ===
+
+// pruneCovered deletes txs covered by the CheckpointRetain-th newest signed
+// checkpoint, and already in a terminal status, from the ledger, the DAG and
+// every per-tx table. Their effect on balances is carried by that
+// checkpoint; local_pruned_txs keeps what parents and nonce checks need.
+func pruneCovered(ctx context.Context) (int, error) {
+	var cpHash string
+	var frontier []string
+	err := DB.QueryRowContext(ctx, `
+		SELECT hash, frontier FROM local_checkpoints WHERE status='signed'
+		ORDER BY seq DESC, signed_at DESC
+		OFFSET $1 LIMIT 1
+	`, CheckpointRetain-1).Scan(&cpHash, pq.Array(&frontier))
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var hashes []string
+	err = tx.QueryRowContext(ctx, `
+		WITH RECURSIVE cover(tx_hash) AS (
+		  SELECT unnest($1::text[])
+		  UNION
+		  SELECT p FROM cover c JOIN local_dag_nodes d ON d.tx_hash = c.tx_hash CROSS JOIN LATERAL unnest(d.parents) AS p
+		)
+		SELECT COALESCE(array_agg(d.tx_hash), '{}')
+		FROM cover c JOIN local_dag_nodes d ON d.tx_hash = c.tx_hash
+		WHERE d.status IN ('finalized','rejected','quarantined')
+	`, pq.Array(frontier)).Scan(pq.Array(&hashes))
+	if err != nil || len(hashes) == 0 {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO local_pruned_txs (tx_hash, checkpoint_hash, from_public_id, nonce, tx_type, status, user_signed)
+		SELECT d.tx_hash, $2, COALESCE(l.from_public_id, ''), COALESCE(l.nonce, 0), COALESCE(l.tx_type, ''), d.status,
+		       COALESCE(d.tx_body::jsonb->>'user_sig', '') <> ''
+		FROM local_dag_nodes d LEFT JOIN local_ledger l ON l.id = d.ledger_id
+		WHERE d.tx_hash = ANY($1)
+		ON CONFLICT (tx_hash) DO NOTHING
+	`, pq.Array(hashes), cpHash); err != nil {
+		return 0, err
+	}
+	for _, q := range []string{
+		`DELETE FROM local_tx_transitions WHERE tx_hash = ANY($1)`,
+		`DELETE FROM local_attestations WHERE tx_hash = ANY($1)`,
+		`DELETE FROM local_verification_log WHERE tx_hash = ANY($1)`,
+		`WITH gone AS (DELETE FROM local_dag_nodes WHERE tx_hash = ANY($1) RETURNING ledger_id)
+		 DELETE FROM local_ledger l USING gone WHERE l.id = gone.ledger_id`,
+	} {
+		if _, err := tx.ExecContext(ctx, q, pq.Array(hashes)); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE local_checkpoints SET pruned_at = NOW() WHERE hash=$1
+	`, cpHash); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	DAG.Remove(hashes...)
+	return len(hashes), nil
+}
+
+// === Bootstrap ===
+
+// bootstrapFromCheckpoint seeds an empty node from the newest checkpoint a
+// sibling serves: the quorum signatures and Merkle root are checked, the
+// balances become local_balances, and the frontier stands in for the parents
+// this node will never receive.
+//
+// Checkpoints sign balances only. Asset positions and the rollup buckets of
+// the txs a checkpoint covers are out of scope and stay missing here, so a
+// bootstrapped node refuses to serve them (refuseIfBootstrapped).
+func bootstrapFromCheckpoint(ctx context.Context) error {
+	var empty bool
+	if err := DB.QueryRowContext(ctx, `
+		SELECT NOT EXISTS (SELECT 1 FROM local_dag_nodes) AND NOT EXISTS (SELECT 1 FROM local_checkpoints)
+	`).Scan(&empty); err != nil {
+		return err
+	}
+	if !empty {
+		return nil
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	var lastErr error = errCheckpointNotFound
+	for _, peer := range PeersList {
+		resp, err := client.Get(strings.TrimRight(peer, "/") + "/api/checkpoints/latest")
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		var out struct {
+			Checkpoint checkpoint          `json:"checkpoint"`
+			Signatures []signedEnvelope    `json:"signatures"`
+			Balances   []checkpointBalance `json:"balances"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&out)
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || err != nil {
+			lastErr = fmt.Errorf("%s: status %d", peer, resp.StatusCode)
+			continue
+		}
+		cp := out.Checkpoint
+		if err := verifyCheckpointQuorum(ctx, cp, out.Signatures); err != nil {
+			lastErr = fmt.Errorf("%s: %w", peer, err)
+			continue
+		}
+		if len(out.Balances) != cp.BalanceCount || balancesRoot(out.Balances) != cp.BalancesRoot {
+			lastErr = fmt.Errorf("%s: balances do not match root", peer)
+			continue
+		}
+		if err := storeCheckpoint(ctx, cp, out.Balances, out.Signatures, true, true); err != nil {
+			return err
+		}
+		// nothing it covers will ever be stored here
+		if _, err := DB.ExecContext(ctx, `
+			UPDATE local_checkpoints SET pruned_at = NOW() WHERE hash=$1
+		`, cp.Hash); err != nil {
+			return err
+		}
+		if _, err := DB.ExecContext(ctx, `
+			INSERT INTO local_balances (account_id, currency, balance)
+			SELECT account_id, currency, balance FROM local_checkpoint_balances WHERE checkpoint_hash=$1
+			ON CONFLICT (account_id, currency) DO UPDATE SET balance = EXCLUDED.balance, updated_at = now()
+		`, cp.Hash); err != nil {
+			return err
+		}
+		log.Printf("checkpoint: bootstrapped from %s seq=%d hash=%s balances=%d", peer, cp.Seq, cp.Hash, cp.BalanceCount)
+		return nil
+	}
+	return lastErr
+}
+
+// bootstrapped reports whether this node started from a checkpoint.
+func bootstrapped(ctx context.Context) (bool, error) {
+	var b bool
+	err := DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM local_checkpoints WHERE bootstrap)`).Scan(&b)
+	return b, err
+}
+
+// refuseIfBootstrapped answers 409 on a bootstrapped node, for endpoints
+// derived from history the checkpoint did not carry.
+func refuseIfBootstrapped(c *gin.Context) bool {
+	b, err := bootstrapped(c.Request.Context())
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_lookup_checkpoint", "details": err.Error()})
+		return true
+	}
+	if b {
+		c.JSON(http.StatusConflict, gin.H{"error": "bootstrapped_from_checkpoint", "details": "history before the checkpoint is not on this node; ask a sibling"})
+		return true
+	}
+	return false
+}
+
+// === Handlers ===
+
+// HandlerPeerCheckpoint co-signs a sibling's proposal if our own replay of
+// its frontier gives the same balances root.
+func HandlerPeerCheckpoint(c *gin.Context) {
+	var p checkpointProposal
+	if err := c.BindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request", "details": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	cp := p.Checkpoint
+	if checkpointHash(cp) != cp.Hash || p.Signature.NodeID != cp.Proposer {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "checkpoint_malformed"})
+		return
+	}
+	if err := verifyEnvelope(ctx, p.Signature, checkpointMessage(cp)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "checkpoint_verification_failed", "reason": err.Error()})
+		return
+	}
+	missing, err := missingParents(ctx, cp.Frontier)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_missing_parents", "details": err.Error()})
+		return
+	}
+	if len(missing) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "frontier_unknown", "missing": missing})
+		return
+	}
+	balances, err := frontierBalances(ctx, cp)
+	if err == errCheckpointBaseUnknown {
+		c.JSON(http.StatusConflict, gin.H{"error": "checkpoint_base_unknown", "base": cp.Base})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_checkpoint_balances", "details": err.Error()})
+		return
+	}
+	root := balancesRoot(balances)
+	if root != cp.BalancesRoot || len(balances) != cp.BalanceCount {
+		raiseTamperAlert(ctx, cp.Hash, "checkpoint_root_mismatch", map[string]any{
+			"proposer":      cp.Proposer,
+			"seq":           cp.Seq,
+			"proposed_root": cp.BalancesRoot,
+			"local_root":    root,
+		})
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "checkpoint_root_mismatch", "local_root": root})
+		return
+	}
+
+	own, err := signEnvelope(checkpointMessage(cp))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "sign_failed", "details": err.Error()})
+		return
+	}
+	if err := storeCheckpoint(ctx, cp, balances, []signedEnvelope{p.Signature, own}, false, false); err != nil {
+		c.JSON(500, gin.H{"error": "db_store_checkpoint", "details": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true, "signature": own})
+}
+
+// HandlerPeerCheckpointCommit stores a checkpoint once it carries a quorum.
+func HandlerPeerCheckpointCommit(c *gin.Context) {
+	var cm checkpointCommit
+	if err := c.BindJSON(&cm); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request", "details": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	if err := verifyCheckpointQuorum(ctx, cm.Checkpoint, cm.Signatures); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "checkpoint_verification_failed", "reason": err.Error()})
+		return
+	}
+	// balances are already stored if we co-signed; otherwise take the ones
+	// sent along, or replay them
+	var known bool
+	_ = DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM local_checkpoints WHERE hash=$1)`, cm.Checkpoint.Hash).Scan(&known)
+	var balances []checkpointBalance
+	if !known {
+		balances = cm.Balances
+		if balances == nil {
+			var err error
+			balances, err = frontierBalances(ctx, cm.Checkpoint)
+			if err == errCheckpointBaseUnknown {
+				c.JSON(http.StatusConflict, gin.H{"error": "checkpoint_base_unknown", "base": cm.Checkpoint.Base})
+				return
+			}
+			if err != nil {
+				c.JSON(500, gin.H{"error": "db_checkpoint_balances", "details": err.Error()})
+				return
+			}
+		}
+		if len(balances) != cm.Checkpoint.BalanceCount || balancesRoot(balances) != cm.Checkpoint.BalancesRoot {
+			c.JSON(http.StatusConflict, gin.H{"error": "checkpoint_root_mismatch"})
+			return
+		}
+	}
+	if err := storeCheckpoint(ctx, cm.Checkpoint, balances, cm.Signatures, true, false); err != nil {
+		c.JSON(500, gin.H{"error": "db_store_checkpoint", "details": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true})
+}
+
+// HandlerLatestCheckpoint serves the newest signed checkpoint with its
+// signatures and balances, enough for a new node to bootstrap from.
+func HandlerLatestCheckpoint(c *gin.Context) {
+	cp, sigs, balances, err := loadCheckpoint(c.Request.Context(), "")
+	if err == errCheckpointNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no_checkpoint"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_load_checkpoint", "details": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true, "checkpoint": cp, "signatures": sigs, "balances": balances})
+}
+
+// HandlerListCheckpoints lists the newest checkpoint headers.
+func HandlerListCheckpoints(c *gin.Context) {
+	rows, err := DB.QueryContext(c.Request.Context(), `
+		SELECT hash, seq, COALESCE(base, ''), proposer, frontier, balances_root, balance_count, created_unix_ms, status,
+		       (SELECT COUNT(*) FROM local_checkpoint_signatures s WHERE s.checkpoint_hash = c.hash)
+		FROM local_checkpoints c
+		ORDER BY seq DESC, created_unix_ms DESC
+		LIMIT 50
+	`)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_list_checkpoints", "details": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	type item struct {
+		checkpoint
+		Status     string `json:"status"`
+		Signatures int    `json:"signatures"`
+	}
+	items := []item{}
+	for rows.Next() {
+		var it item
+		if err := rows.Scan(&it.Hash, &it.Seq, &it.Base, &it.Proposer, pq.Array(&it.Frontier), &it.BalancesRoot,
+			&it.BalanceCount, &it.CreatedUnixMs, &it.Status, &it.Signatures); err != nil {
+			c.JSON(500, gin.H{"error": "db_scan_checkpoint", "details": err.Error()})
+			return
+		}
+		items = append(items, it)
+	}
+	c.JSON(200, gin.H{"ok": true, "checkpoints": items})
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Two user-signed txs with the same sender and nonce spend the same
+// authorisation. Siblings can each accept one before gossip converges, so
+// every node resolves the set the same way once it has seen it:
+//
+//  1. topological: a tx with a conflicting ancestor loses to it
+//  2. timestamp:   the earlier ts_unix_ms the user signed into the tx, so no
+//     origin node can bias it
+//  3. hash:        the lower tx_hash
+//
+// A finalized tx is never overturned; if one is in the set it wins. Losers
+// are rejected and a signed local_tx_conflicts row links them to the winner;
+// rejecting them reverses their balance effects (trg_local_reverse_ineffective).
+// Unsigned txs (USER_SIG_REQUIRED=false) authorise nothing and never conflict.
+
+// spendCandidate is one member of a conflicting set.
+type spendCandidate struct {
+	hash   string
+	ts     int64 // signer's ts_unix_ms
+	status string
+	pruned bool // only the hash and final status survive
+}
+
+// conflictMessage is what a node signs when it records loser as beaten by
+// winner.
+func conflictMessage(winner, loser, fromPublicID string, nonce int64, rule string, atUnixMs int64) []byte {
+	return []byte(fmt.Sprintf("conflict|%s|%s|%s|%d|%s|%d", winner, loser, fromPublicID, nonce, rule, atUnixMs))
+}
+
+// spendCandidates returns every stored or pruned tx from fromPublicID with
+// nonce that carries a user signature, in tx_hash order.
+func spendCandidates(ctx context.Context, fromPublicID string, nonce int64) ([]spendCandidate, error) {
+	types := make([]string, 0, len(userSigTxTypes))
+	for tt := range userSigTxTypes {
+		types = append(types, tt)
+	}
+	rows, err := DB.QueryContext(ctx, `
+		SELECT d.tx_hash, d.tx_body, d.status
+		FROM local_ledger l
+		JOIN local_dag_nodes d ON d.ledger_id = l.id
+		WHERE l.from_public_id=$1 AND l.nonce=$2 AND l.tx_type = ANY($3)
+		  AND COALESCE(d.tx_body::jsonb->>'user_sig', '') <> ''
+		UNION ALL
+		SELECT tx_hash, NULL, status FROM local_pruned_txs
+		WHERE from_public_id=$1 AND nonce=$2 AND tx_type = ANY($3) AND user_signed
+		ORDER BY 1
+	`, fromPublicID, nonce, pq.Array(types))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []spendCandidate
+	for rows.Next() {
+		var c spendCandidate
+		var body sql.NullString
+		if err := rows.Scan(&c.hash, &body, &c.status); err != nil {
+			return nil, err
+		}
+		if !body.Valid {
+			c.pruned = true
+			out = append(out, c)
+			continue
+		}
+		var t localTx
+		if err := json.Unmarshal([]byte(body.String), &t); err != nil {
+			return nil, err
+		}
+		c.ts = t.UserTsUnixMs
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// conflictWinner picks the winner of cs, which must be non-empty. Only live
+// txs with no conflicting ancestor compete on timestamp and hash, so the
+// result does not depend on the order the set was seen in.
+func conflictWinner(cs []spendCandidate) spendCandidate {
+	for _, c := range cs {
+		if c.status == "finalized" {
+			return c
+		}
+	}
+	var roots []spendCandidate
+	for _, c := range cs {
+		if c.pruned {
+			continue
+		}
+		root := true
+		for _, o := range cs {
+			if o.hash != c.hash && DAG.IsAncestor(o.hash, c.hash) {
+				root = false
+				break
+			}
+		}
+		if root {
+			roots = append(roots, c)
+		}
+	}
+	if len(roots) == 0 { // only if the index lost an ancestor
+		roots = cs
+	}
+	sort.Slice(roots, func(i, j int) bool {
+		if roots[i].ts != roots[j].ts {
+			return roots[i].ts < roots[j].ts
+		}
+		return roots[i].hash < roots[j].hash
+	})
+	return roots[0]
+}
+
+// conflictRule names the step that decided winner over loser.
+func conflictRule(winner, loser spendCandidate, cs []spendCandidate) string {
+	if winner.status == "finalized" {
+		return "finalized"
+	}
+	for _, o := range cs {
+		if o.hash != loser.hash && DAG.IsAncestor(o.hash, loser.hash) {
+			return "topological"
+		}
+	}
+	if winner.ts != loser.ts {
+		return "timestamp"
+	}
+	return "hash"
+}
+
+// resolveConflicts runs after t is stored. If t shares its sender and nonce
+// with other user-signed txs, every loser that is still live is rejected.
+func resolveConflicts(ctx context.Context, t localTx) error {
+	if !userSigTxTypes[t.TxType] || t.UserSig == "" {
+		return nil
+	}
+	cs, err := spendCandidates(ctx, t.FromPublicID, t.Nonce)
+	if err != nil || len(cs) < 2 {
+		return err
+	}
+	winner := conflictWinner(cs)
+	for _, loser := range cs {
+		if loser.hash == winner.hash || loser.pruned || loser.status == "rejected" || loser.status == "finalized" {
+			continue
+		}
+		rule := conflictRule(winner, loser, cs)
+		if err := recordConflict(ctx, winner.hash, loser.hash, t.FromPublicID, t.Nonce, rule); err != nil {
+			return err
+		}
+		reason := fmt.Sprintf("conflict_lost: %s (%s)", winner.hash, rule)
+		moved, err := transitionTx(ctx, loser.hash, []string{"received", "local_confirmed", "submitted_global"}, "rejected", reason)
+		if err != nil {
+			return err
+		}
+		if moved {
+			log.Printf("conflict: tx=%s rejected winner=%s rule=%s", loser.hash, winner.hash, rule)
+			raiseTamperAlert(ctx, loser.hash, "conflicting_spend", map[string]any{
+				"winner":         winner.hash,
+				"from_public_id": t.FromPublicID,
+				"nonce":          t.Nonce,
+				"rule":           rule,
+			})
+		}
+	}
+	return nil
+}
+
+// recordConflict signs and stores one winner/loser pair; repeats are no-ops.
+func recordConflict(ctx context.Context, winner, loser, fromPublicID string, nonce int64, rule string) error {
+	at := time.Now().UnixMilli()
+	env, err := signEnvelope(conflictMessage(winner, loser, fromPublicID, nonce, rule, at))
+	if err != nil {
+		return err
+	}
+	_, err = DB.ExecContext(ctx, `
+		INSERT INTO local_tx_conflicts (winner_tx_hash, loser_tx_hash, from_public_id, nonce, rule, node_id, signature, created_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+		ON CONFLICT (winner_tx_hash, loser_tx_hash) DO NOTHING
+	`, winner, loser, fromPublicID, nonce, rule, NodeID, env.SigB64, time.UnixMilli(at).UTC())
+	return err
+}
+
+// resolveConflictsAfterInsert is resolveConflicts for callers that have
+// already answered for the insert itself.
+func resolveConflictsAfterInsert(ctx context.Context, t localTx, txHash string) {
+	if err := resolveConflicts(ctx, t); err != nil {
+		log.Printf("conflict: tx=%s resolve_failed=%v", txHash, err)
+	}
+}
+
+// checkNonceUnused is the handler-side gate: a sender reusing a nonce this
+// node already holds gets 409 instead of a tx that would only lose.
+func checkNonceUnused(c *gin.Context, t localTx) bool {
+	if !userSigTxTypes[t.TxType] || t.UserSig == "" {
+		return true
+	}
+	cs, err := spendCandidates(c.Request.Context(), t.FromPublicID, t.Nonce)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_lookup_nonce", "details": err.Error()})
+		return false
+	}
+	if len(cs) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "nonce_used", "nonce": t.Nonce, "tx_hash": cs[0].hash})
+		return false
+	}
+	return true
+}
+
+// === Handlers ===
+
+// HandlerListConflicts returns the signed conflict records touching a
+// tx_hash (?tx_hash=) or a sender (?from_public_id=), newest first.
+func HandlerListConflicts(c *gin.Context) {
+	txHash, from := c.Query("tx_hash"), c.Query("from_public_id")
+	if txHash == "" && from == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tx_hash_or_from_public_id_required"})
+		return
+	}
+	rows, err := DB.QueryContext(c.Request.Context(), `
+		SELECT winner_tx_hash, loser_tx_hash, from_public_id, nonce, rule, node_id, signature, created_at
+		FROM local_tx_conflicts
+		WHERE ($1 = '' OR winner_tx_hash = $1 OR loser_tx_hash = $1)
+		  AND ($2 = '' OR from_public_id = $2)
+		ORDER BY created_at DESC, id DESC
+		LIMIT 500
+	`, txHash, from)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_list_conflicts", "details": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	type conflict struct {
+		Winner       string    `json:"winner_tx_hash"`
+		Loser        string    `json:"loser_tx_hash"`
+		FromPublicID string    `json:"from_public_id"`
+		Nonce        int64     `json:"nonce"`
+		Rule         string    `json:"rule"`
+		NodeID       string    `json:"node_id"`
+		Signature    string    `json:"signature"`
+		CreatedAt    time.Time `json:"created_at"`
+	}
+	out := []conflict{}
+	for rows.Next() {
+		var k conflict
+		if err := rows.Scan(&k.Winner, &k.Loser, &k.FromPublicID, &k.Nonce, &k.Rule, &k.NodeID, &k.Signature, &k.CreatedAt); err != nil {
+			c.JSON(500, gin.H{"error": "db_scan_conflict", "details": err.Error()})
+			return
+		}
+		out = append(out, k)
+	}
+	c.JSON(200, gin.H{"ok": true, "conflicts": out})
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Cluster voting parameters. With n siblings and up to f faulty ones
+// (n >= 3f+1), a tx is locally confirmed once 2f+1 nodes accept the same
+// tx_hash with the same parents.
+var (
+	ClusterSize      int
+	FaultTolerance   int
+	Quorum           int
+	ConsensusTimeout = 10 * time.Second
+	MaxRounds        = 3
+)
+
+// configureConsensus derives the quorum from the configured cluster size
+// (falling back to PEERS) and the optional FAULT_TOLERANCE override.
+func configureConsensus() {
+	ClusterSize = len(Members.Cluster(ClusterID))
+	if ClusterSize == 0 {
+		ClusterSize = len(PeersList) + 1
+	}
+	FaultTolerance = getenvInt("FAULT_TOLERANCE", (ClusterSize-1)/3)
+	if ClusterSize < 3*FaultTolerance+1 {
+		log.Fatalf("consensus: cluster of %d cannot tolerate %d faulty nodes (need n >= 3f+1)", ClusterSize, FaultTolerance)
+	}
+	Quorum = 2*FaultTolerance + 1
+
+	// the global side's supermajority: 2f+1 of its configured verifiers
+	globalSize := len(Members.Global)
+	if globalSize == 0 {
+		globalSize = len(GlobalPeersList)
+	}
+	GlobalQuorum = 2*((globalSize-1)/3) + 1
+	ConsensusTimeout = getenvDuration("CONSENSUS_TIMEOUT", ConsensusTimeout)
+	MaxRounds = getenvInt("CONSENSUS_MAX_ROUNDS", MaxRounds)
+	log.Printf("consensus: n=%d f=%d quorum=%d global_quorum=%d", ClusterSize, FaultTolerance, Quorum, GlobalQuorum)
+}
+
+// announceTx casts the origin's own vote and gossips the tx to siblings.
+func announceTx(msg gossipMessage, parents []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := castVote(ctx, msg.TxHash, parents, msg.Round, ""); err != nil {
+		log.Printf("consensus: tx=%s self_vote_failed=%v", msg.TxHash, err)
+	}
+	gossipTx(msg, PeersList)
+}
+
+// === Peer Handler ===
+
+// HandlerPeerVote accepts a sibling's signed vote and re-tallies the tx.
+func HandlerPeerVote(c *gin.Context) {
+	var v localVote
+	if err := c.BindJSON(&v); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request", "details": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	if err := verifyVote(ctx, v); err != nil {
+		raiseTamperAlert(ctx, v.TxHash, "vote_verification_failed", map[string]any{
+			"voter":  v.Voter.NodeID,
+			"round":  v.Round,
+			"reason": err.Error(),
+		})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "vote_verification_failed", "reason": err.Error()})
+		return
+	}
+	if err := storeVote(ctx, v); err != nil {
+		c.JSON(500, gin.H{"error": "db_store_vote", "details": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true})
+}
+
+// broadcastVote sends our vote to every sibling (best effort, single try;
+// a missed vote is recovered by the next round).
+func broadcastVote(v localVote, peers []string) {
+	client := &http.Client{Timeout: 3 * time.Second}
+	body, _ := json.Marshal(v)
+	for _, p := range peers {
+		go func(peer string) {
+			url := strings.TrimRight(peer, "/") + "/peer/vote"
+			req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := client.Do(req)
+			if err != nil {
+				return
+			}
+			_ = resp.Body.Close()
+		}(p)
+	}
+}
+
+// === Tally ===
+
+// tallyVotes looks at each node's latest vote on txHash. A quorum of accepts
+// on the local copy's parents confirms the tx; any disagreement raises a
+// conflicting_votes alert, and once a quorum is out of reach the tx is
+// quarantined.
+func tallyVotes(ctx context.Context, txHash string) error {
+	rows, err := DB.QueryContext(ctx, `
+		SELECT DISTINCT ON (node_id) node_id, details->>'verdict', details->>'parents_digest'
+		FROM local_attestations
+		WHERE tx_hash=$1 AND verified
+		ORDER BY node_id, round DESC
+	`, txHash)
+	if err != nil {
+		return err
+	}
+	accepts := map[string][]string{} // parents digest -> voters
+	var rejects []string
+	for rows.Next() {
+		var node, verdict, digest string
+		if err := rows.Scan(&node, &verdict, &digest); err != nil {
+			rows.Close()
+			return err
+		}
+		if !isClusterMember(node) {
+			continue
+		}
+		if verdict == "accept" {
+			accepts[digest] = append(accepts[digest], node)
+		} else {
+			rejects = append(rejects, node)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var status string
+	var parents []string
+	err = DB.QueryRowContext(ctx, `SELECT status, parents FROM local_dag_nodes WHERE tx_hash=$1`, txHash).
+		Scan(&status, pq.Array(&parents))
+	if err != nil {
+		// votes may arrive before the gossip itself; tally again on insert
+		return nil
+	}
+	localDigest := parentsDigest(parents)
+
+	if len(accepts) > 1 || len(rejects) > 0 || (len(accepts) == 1 && accepts[localDigest] == nil) {
+		flagConflictingVotes(ctx, txHash, accepts, rejects)
+	}
+
+	if status != "received" {
+		return nil
+	}
+	if len(accepts[localDigest]) >= Quorum {
+		moved, err := transitionTx(ctx, txHash, []string{"received"}, "local_confirmed", "")
+		if moved {
+			log.Printf("consensus: tx=%s local_confirmed votes=%d", txHash, len(accepts[localDigest]))
+		}
+		return err
+	}
+	if len(rejects) > ClusterSize-Quorum {
+		reason := fmt.Sprintf("consensus_rejected: %d of %d nodes rejected", len(rejects), ClusterSize)
+		_, err = transitionTx(ctx, txHash, []string{"received"}, "quarantined", reason)
+		return err
+	}
+	return nil
+}
+
+// flagConflictingVotes raises one conflicting_votes alert per tx.
+func flagConflictingVotes(ctx context.Context, txHash string, accepts map[string][]string, rejects []string) {
+	var exists bool
+	_ = DB.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM local_tamper_alerts WHERE offending_tx=$1 AND description='conflicting_votes')
+	`, txHash).Scan(&exists)
+	if exists {
+		return
+	}
+	raiseTamperAlert(ctx, txHash, "conflicting_votes", map[string]any{
+		"accepts_by_parents_digest": accepts,
+		"rejects":                   rejects,
+	})
+}
+
+// === Rounds ===
+
+// consensusLoop re-gossips our own txs that have not reached a quorum within
+// ConsensusTimeout under a new round, and quarantines them after MaxRounds.
+func consensusLoop(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		rows, err := DB.QueryContext(ctx, `
+			SELECT d.tx_hash, d.tx_body,
+			       COALESCE((SELECT MAX(a.round) FROM local_attestations a WHERE a.tx_hash=d.tx_hash), 1)
+			FROM local_dag_nodes d
+			WHERE d.node_id=$1 AND d.status='received' AND d.created_at < NOW() - $2 * interval '1 second'
+		`, NodeID, ConsensusTimeout.Seconds())
+		if err != nil {
+			log.Printf("consensus: sweep failed: %v", err)
+			cancel()
+			continue
+		}
+		type pending struct {
+			hash  string
+			body  string
+			round int
+		}
+		var stale []pending
+		for rows.Next() {
+			var p pending
+			if err := rows.Scan(&p.hash, &p.body, &p.round); err == nil {
+				stale = append(stale, p)
+			}
+		}
+		rows.Close()
+
+		for _, p := range stale {
+			if p.round >= MaxRounds {
+				raiseTamperAlert(ctx, p.hash, "consensus_timeout", map[string]any{"rounds": p.round, "quorum": Quorum})
+				reason := fmt.Sprintf("consensus_timeout: no quorum after %d rounds", p.round)
+				if _, err := transitionTx(ctx, p.hash, []string{"received"}, "quarantined", reason); err != nil {
+					log.Printf("consensus: tx=%s quarantine_failed=%v", p.hash, err)
+				}
+				continue
+			}
+			env, err := signEnvelope(txSignMessage(p.hash))
+			if err != nil {
+				continue
+			}
+			var t localTx
+			if err := json.Unmarshal([]byte(p.body), &t); err != nil {
+				continue
+			}
+			go announceTx(gossipMessage{
+				TxBody: []byte(p.body),
+				TxHash: p.hash,
+				Origin: env,
+				Round:  p.round + 1,
+			}, t.Parents)
+		}
+		cancel()
+	}
+}
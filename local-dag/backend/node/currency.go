@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultCurrency denominates requests that don't name one.
+var DefaultCurrency = "USD"
+
+// noCurrency is ISO 4217 XXX; asset legs carry unit counts in it.
+const noCurrency = "XXX"
+
+var (
+	currencyCodeRe     = regexp.MustCompile(`^[A-Z]{3}$`)
+	errUnknownCurrency = errors.New("unknown currency")
+)
+
+// currencyScale returns the minor-unit scale of code.
+func currencyScale(ctx context.Context, code string) (int, error) {
+	var scale int
+	err := DB.QueryRowContext(ctx, `SELECT scale FROM local_currencies WHERE code=$1`, code).Scan(&scale)
+	if err == sql.ErrNoRows {
+		return 0, errUnknownCurrency
+	}
+	return scale, err
+}
+
+type balance struct {
+	Currency string `json:"currency"`
+	Scale    int    `json:"scale"`
+	Balance  int64  `json:"balance"`
+}
+
+// HandlerAccountBalances returns an account's balance in every currency it
+// has touched, in minor units.
+func HandlerAccountBalances(c *gin.Context) {
+	rows, err := DB.QueryContext(c.Request.Context(), `
+		SELECT b.currency, c.scale, b.balance
+		FROM local_balances b JOIN local_currencies c ON c.code = b.currency
+		WHERE b.account_id=$1
+		ORDER BY b.currency
+	`, c.Param("public_id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_list_balances", "details": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	balances := []balance{}
+	for rows.Next() {
+		var b balance
+		if err := rows.Scan(&b.Currency, &b.Scale, &b.Balance); err != nil {
+			c.JSON(500, gin.H{"error": "db_scan_balance", "details": err.Error()})
+			return
+		}
+		balances = append(balances, b)
+	}
+	c.JSON(200, gin.H{"ok": true, "account_id": c.Param("public_id"), "balances": balances})
+}
+
+// HandlerListCurrencies returns the known currencies and their scales.
+func HandlerListCurrencies(c *gin.Context) {
+	rows, err := DB.QueryContext(c.Request.Context(), `SELECT code, scale FROM local_currencies ORDER BY code`)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_list_currencies", "details": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	type currency struct {
+		Code  string `json:"code"`
+		Scale int    `json:"scale"`
+	}
+	out := []currency{}
+	for rows.Next() {
+		var cur currency
+		if err := rows.Scan(&cur.Code, &cur.Scale); err != nil {
+			c.JSON(500, gin.H{"error": "db_scan_currency", "details": err.Error()})
+			return
+		}
+		out = append(out, cur)
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "currencies": out})
+}
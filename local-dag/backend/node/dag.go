@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"hackodisha/backend/dagindex"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// DAG mirrors the parent links of local_dag_nodes. It is loaded at startup
+// and updated after every commit that stores or prunes DAG nodes.
+var DAG = dagindex.New()
+
+// loadDAGIndex indexes the live DAG, oldest first.
+func loadDAGIndex(ctx context.Context) error {
+	rows, err := DB.QueryContext(ctx, `
+		SELECT tx_hash, parents FROM local_dag_nodes ORDER BY created_at, tx_hash
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var h string
+		var parents []string
+		if err := rows.Scan(&h, pq.Array(&parents)); err != nil {
+			return err
+		}
+		DAG.Add(h, parents)
+	}
+	return rows.Err()
+}
+
+// === Handlers ===
+
+// HandlerDagTips returns the current tips, newest first.
+func HandlerDagTips(c *gin.Context) {
+	c.JSON(200, gin.H{"ok": true, "tips": DAG.Tips(0), "indexed": DAG.Len()})
+}
+
+// HandlerDagNode describes one DAG node. ?relatives=ancestors|descendants
+// adds that set; ?ancestor_of=<tx_hash> asks whether this node is one of
+// its ancestors.
+func HandlerDagNode(c *gin.Context) {
+	hash := c.Param("hash")
+	info, ok := DAG.Get(hash)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tx_not_indexed"})
+		return
+	}
+	resp := gin.H{"ok": true, "node": info}
+	switch c.Query("relatives") {
+	case "":
+	case "ancestors":
+		resp["ancestors"], _ = DAG.Ancestors(hash)
+	case "descendants":
+		resp["descendants"], _ = DAG.Descendants(hash)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_relatives"})
+		return
+	}
+	if d := c.Query("ancestor_of"); d != "" {
+		resp["ancestor_of"] = d
+		resp["is_ancestor"] = DAG.IsAncestor(hash, d)
+	}
+	c.JSON(200, resp)
+}
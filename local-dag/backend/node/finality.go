@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Finality from the global layer.
+var (
+	CallbackAddress string
+	GlobalQuorum    = 3 // set by configureConsensus
+	MaxFinalityWait = 60 * time.Second
+)
+
+// finalityNotice mirrors the global node's notice: the outcome plus the
+// supermajority of signed verdicts that decided it.
+type finalityNotice struct {
+	LocalDagHash string          `json:"local_dag_hash"`
+	Outcome      string          `json:"outcome"` // finalized | rejected
+	Verdicts     []globalVerdict `json:"verdicts"`
+	Notifier     signedEnvelope  `json:"notifier"`
+	// Scope is "cluster" when the originating sibling relays the notice; the
+	// notifier key is then looked up among siblings instead of global nodes.
+	Scope string `json:"scope,omitempty"`
+}
+
+type globalVerdict struct {
+	LocalDagHash string         `json:"local_dag_hash"`
+	Round        int            `json:"round"`
+	Validated    bool           `json:"validated"`
+	Reason       string         `json:"reason,omitempty"`
+	Verifier     signedEnvelope `json:"verifier"`
+}
+
+func finalityMessage(localDagHash, outcome string) []byte {
+	return []byte(fmt.Sprintf("finality|%s|%s", localDagHash, outcome))
+}
+
+func verdictMessage(localDagHash string, round int, validated bool) []byte {
+	return []byte(fmt.Sprintf("verdict|%s|%d|%t", localDagHash, round, validated))
+}
+
+// globalNodeID namespaces global verifier keys so they cannot collide with
+// sibling node IDs in the nodes table.
+func globalNodeID(id string) string { return "global/" + id }
+
+func isFinalStatus(s string) bool { return s == "finalized" || s == "rejected" }
+
+// finalityReason explains a rejection with the first reason a rejecting
+// verifier gave.
+func finalityReason(n finalityNotice) string {
+	if n.Outcome != "rejected" {
+		return ""
+	}
+	for _, v := range n.Verdicts {
+		if !v.Validated && v.Reason != "" {
+			return "global_rejected: " + v.Reason
+		}
+	}
+	return "global_rejected"
+}
+
+// === Waiters ===
+
+var (
+	finalityMu      sync.Mutex
+	finalityWaiters = map[string][]chan string{}
+)
+
+func subscribeFinality(txHash string) chan string {
+	ch := make(chan string, 1)
+	finalityMu.Lock()
+	finalityWaiters[txHash] = append(finalityWaiters[txHash], ch)
+	finalityMu.Unlock()
+	return ch
+}
+
+func unsubscribeFinality(txHash string, ch chan string) {
+	finalityMu.Lock()
+	defer finalityMu.Unlock()
+	ws := finalityWaiters[txHash]
+	for i, w := range ws {
+		if w == ch {
+			ws = append(ws[:i], ws[i+1:]...)
+			break
+		}
+	}
+	if len(ws) == 0 {
+		delete(finalityWaiters, txHash)
+	} else {
+		finalityWaiters[txHash] = ws
+	}
+}
+
+func publishFinality(txHash, status string) {
+	finalityMu.Lock()
+	ws := finalityWaiters[txHash]
+	delete(finalityWaiters, txHash)
+	finalityMu.Unlock()
+	for _, ch := range ws {
+		ch <- status
+	}
+}
+
+// === Handlers ===
+
+// HandlerPeerFinality applies a finality notice from a global node (or from
+// the sibling that originated the tx). The notice must carry at least
+// GlobalQuorum distinct verdicts agreeing with the outcome.
+func HandlerPeerFinality(c *gin.Context) {
+	var n finalityNotice
+	if err := c.BindJSON(&n); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request", "details": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	if err := verifyFinalityNotice(ctx, n); err != nil {
+		raiseTamperAlert(ctx, n.LocalDagHash, "finality_verification_failed", map[string]any{
+			"notifier": n.Notifier.NodeID,
+			"reason":   err.Error(),
+		})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "finality_verification_failed", "reason": err.Error()})
+		return
+	}
+
+	var origin string
+	err := DB.QueryRowContext(ctx, `SELECT node_id FROM local_dag_nodes WHERE tx_hash=$1`, n.LocalDagHash).Scan(&origin)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tx_not_found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_lookup_tx", "details": err.Error()})
+		return
+	}
+
+	status := n.Outcome
+	moved, err := transitionTx(ctx, n.LocalDagHash, []string{"local_confirmed", "submitted_global"}, status, finalityReason(n))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_update_status", "details": err.Error()})
+		return
+	}
+	if moved {
+		log.Printf("finality: tx=%s status=%s notifier=%s", n.LocalDagHash, status, n.Notifier.NodeID)
+		publishFinality(n.LocalDagHash, status)
+		if origin == NodeID {
+			go forwardFinality(n, PeersList)
+		}
+	}
+	c.JSON(200, gin.H{"ok": true})
+}
+
+// HandlerWaitFinality long-polls until tx_hash is finalized or rejected, or
+// the timeout (default 30s, capped at MaxFinalityWait) runs out.
+func HandlerWaitFinality(c *gin.Context) {
+	txHash := c.Param("hash")
+	timeout := 30 * time.Second
+	if v := c.Query("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_timeout"})
+			return
+		}
+		timeout = d
+	}
+	if timeout > MaxFinalityWait {
+		timeout = MaxFinalityWait
+	}
+
+	// subscribe before reading so a notice landing in between is not lost
+	ch := subscribeFinality(txHash)
+	defer unsubscribeFinality(txHash, ch)
+
+	ctx := c.Request.Context()
+	var status string
+	err := DB.QueryRowContext(ctx, `SELECT status FROM local_dag_nodes WHERE tx_hash=$1`, txHash).Scan(&status)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tx_not_found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_lookup_tx", "details": err.Error()})
+		return
+	}
+	if !isFinalStatus(status) {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case status = <-ch:
+		case <-timer.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+	c.JSON(200, gin.H{"ok": true, "tx_hash": txHash, "status": status, "final": isFinalStatus(status)})
+}
+
+// === Verification ===
+
+func verifyFinalityNotice(ctx context.Context, n finalityNotice) error {
+	if n.Outcome != "finalized" && n.Outcome != "rejected" {
+		return errors.New("invalid_outcome")
+	}
+	msg := finalityMessage(n.LocalDagHash, n.Outcome)
+	if n.Scope != "cluster" {
+		if err := verifyGlobalEnvelope(ctx, n.Notifier, msg); err != nil {
+			return fmt.Errorf("notifier: %w", err)
+		}
+	} else if !isOrigin(ctx, n.LocalDagHash, n.Notifier.NodeID) {
+		return errors.New("notifier_not_origin")
+	} else if err := verifyEnvelope(ctx, n.Notifier, msg); err != nil {
+		return fmt.Errorf("notifier: %w", err)
+	}
+
+	validated := n.Outcome == "finalized"
+	seen := map[string]bool{}
+	for _, v := range n.Verdicts {
+		if v.LocalDagHash != n.LocalDagHash || v.Validated != validated || seen[v.Verifier.NodeID] {
+			continue
+		}
+		if err := verifyGlobalEnvelope(ctx, v.Verifier, verdictMessage(v.LocalDagHash, v.Round, v.Validated)); err != nil {
+			continue
+		}
+		seen[v.Verifier.NodeID] = true
+	}
+	if len(seen) < GlobalQuorum {
+		return fmt.Errorf("insufficient_verdicts: have %d need %d", len(seen), GlobalQuorum)
+	}
+	return nil
+}
+
+// isOrigin reports whether nodeID is the local node that originated txHash.
+func isOrigin(ctx context.Context, txHash, nodeID string) bool {
+	var ok bool
+	_ = DB.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM local_dag_nodes WHERE tx_hash=$1 AND node_id=$2)
+	`, txHash, nodeID).Scan(&ok)
+	return ok
+}
+
+// forwardFinality relays a notice we originated the tx for to our siblings,
+// re-signed by us so they see the cluster origin as the notifier.
+func forwardFinality(n finalityNotice, peers []string) {
+	env, err := signEnvelope(finalityMessage(n.LocalDagHash, n.Outcome))
+	if err != nil {
+		log.Printf("finality: tx=%s forward_sign_failed=%v", n.LocalDagHash, err)
+		return
+	}
+	n.Notifier = env
+	n.Scope = "cluster"
+	body, _ := json.Marshal(n)
+	client := &http.Client{Timeout: 3 * time.Second}
+	for _, p := range peers {
+		go func(peer string) {
+			url := strings.TrimRight(peer, "/") + "/peer/finality"
+			req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := client.Do(req)
+			if err != nil {
+				return
+			}
+			_ = resp.Body.Close()
+		}(p)
+	}
+}
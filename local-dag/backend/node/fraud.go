@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// FraudRulesFile is the JSON rule set checked on the local fast path. It is
+// re-read on SIGHUP or POST /api/admin/fraud/reload; a file that fails to
+// parse leaves the previous rules in force.
+var FraudRulesFile string
+
+// fraudRule is one entry of the rules file. Amounts are minor units of
+// Currency, or of each tx's own currency when Currency is empty.
+//
+//	velocity:  more than MaxCount txs from the sender within Window
+//	amount:    a single tx of at least MinAmount
+//	new_payee: first tx from sender to recipient, of at least MinAmount
+//	daily_cap: the sender's outgoing total for the UTC day exceeds MaxAmount
+type fraudRule struct {
+	ID        string   `json:"id"`
+	Type      string   `json:"type"`
+	Action    string   `json:"action"`             // block | flag
+	TxTypes   []string `json:"tx_types,omitempty"` // empty matches every type
+	Currency  string   `json:"currency,omitempty"`
+	MaxCount  int64    `json:"max_count,omitempty"`
+	Window    string   `json:"window,omitempty"` // Go duration, e.g. "1m"
+	MinAmount int64    `json:"min_amount,omitempty"`
+	MaxAmount int64    `json:"max_amount,omitempty"`
+	Disabled  bool     `json:"disabled,omitempty"`
+
+	window time.Duration
+}
+
+type fraudRuleSet struct {
+	Rules    []fraudRule `json:"rules"`
+	Source   string      `json:"source"`
+	LoadedAt string      `json:"loaded_at"`
+}
+
+// fraudHit is a rule that matched, with what it saw.
+type fraudHit struct {
+	RuleID   string         `json:"rule_id"`
+	Type     string         `json:"type"`
+	Action   string         `json:"action"`
+	Evidence map[string]any `json:"evidence"`
+}
+
+var fraudRules atomic.Pointer[fraudRuleSet]
+
+func (r *fraudRule) compile() error {
+	if r.ID == "" {
+		return fmt.Errorf("rule without id")
+	}
+	if r.Action != "block" && r.Action != "flag" {
+		return fmt.Errorf("rule %s: action must be block or flag", r.ID)
+	}
+	if r.Currency != "" && !currencyCodeRe.MatchString(r.Currency) {
+		return fmt.Errorf("rule %s: bad currency", r.ID)
+	}
+	switch r.Type {
+	case "velocity":
+		d, err := time.ParseDuration(r.Window)
+		if err != nil || d <= 0 || r.MaxCount <= 0 {
+			return fmt.Errorf("rule %s: velocity needs window and max_count", r.ID)
+		}
+		r.window = d
+	case "amount":
+		if r.MinAmount <= 0 {
+			return fmt.Errorf("rule %s: amount needs min_amount", r.ID)
+		}
+	case "new_payee":
+		if r.MinAmount < 0 {
+			return fmt.Errorf("rule %s: negative min_amount", r.ID)
+		}
+	case "daily_cap":
+		if r.MaxAmount <= 0 {
+			return fmt.Errorf("rule %s: daily_cap needs max_amount", r.ID)
+		}
+	default:
+		return fmt.Errorf("rule %s: unknown type %q", r.ID, r.Type)
+	}
+	return nil
+}
+
+// loadFraudRules parses and installs path; on error the current set stays.
+func loadFraudRules(path string) (int, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var set fraudRuleSet
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return 0, err
+	}
+	seen := map[string]bool{}
+	for i := range set.Rules {
+		if err := set.Rules[i].compile(); err != nil {
+			return 0, err
+		}
+		if seen[set.Rules[i].ID] {
+			return 0, fmt.Errorf("duplicate rule id %s", set.Rules[i].ID)
+		}
+		seen[set.Rules[i].ID] = true
+	}
+	set.Source = path
+	set.LoadedAt = time.Now().UTC().Format(time.RFC3339)
+	fraudRules.Store(&set)
+	log.Printf("fraud: loaded %d rules from %s", len(set.Rules), path)
+	return len(set.Rules), nil
+}
+
+// watchFraudRulesSignal reloads the rules file on SIGHUP.
+func watchFraudRulesSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		if _, err := loadFraudRules(FraudRulesFile); err != nil {
+			log.Printf("fraud: reload failed, keeping previous rules: %v", err)
+		}
+	}
+}
+
+func (r *fraudRule) applies(t localTx) bool {
+	if r.Disabled {
+		return false
+	}
+	if r.Currency != "" && r.Currency != t.Currency {
+		return false
+	}
+	if len(r.TxTypes) == 0 {
+		return true
+	}
+	for _, tt := range r.TxTypes {
+		if tt == t.TxType {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateFraudRules runs every applicable rule against t, which has not
+// been stored yet. Rejected and quarantined txs don't count toward
+// velocity or caps.
+func evaluateFraudRules(ctx context.Context, t localTx) ([]fraudHit, error) {
+	set := fraudRules.Load()
+	if set == nil {
+		return nil, nil
+	}
+	var hits []fraudHit
+	for i := range set.Rules {
+		r := &set.Rules[i]
+		if !r.applies(t) {
+			continue
+		}
+		ev, err := r.evaluate(ctx, t)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", r.ID, err)
+		}
+		if ev != nil {
+			hits = append(hits, fraudHit{RuleID: r.ID, Type: r.Type, Action: r.Action, Evidence: ev})
+		}
+	}
+	return hits, nil
+}
+
+// evaluate returns the rule's evidence if t trips it, nil otherwise.
+func (r *fraudRule) evaluate(ctx context.Context, t localTx) (map[string]any, error) {
+	switch r.Type {
+	case "velocity":
+		var n int64
+		err := DB.QueryRowContext(ctx, `
+			SELECT COUNT(*)
+			FROM local_ledger l
+			JOIN local_dag_nodes d ON d.ledger_id = l.id
+			WHERE l.from_public_id=$1 AND l.created_at > NOW() - $2 * interval '1 millisecond'
+			  AND (cardinality($3::text[]) = 0 OR l.tx_type = ANY($3))
+			  AND d.status NOT IN ('rejected','quarantined')
+		`, t.FromPublicID, r.window.Milliseconds(), pq.Array(r.TxTypes)).Scan(&n)
+		if err != nil {
+			return nil, err
+		}
+		if n+1 > r.MaxCount {
+			return map[string]any{"count": n + 1, "max_count": r.MaxCount, "window": r.Window}, nil
+		}
+	case "amount":
+		if t.Amount >= r.MinAmount {
+			return map[string]any{"amount": t.Amount, "currency": t.Currency, "min_amount": r.MinAmount}, nil
+		}
+	case "new_payee":
+		if t.Amount < r.MinAmount {
+			return nil, nil
+		}
+		var known bool
+		err := DB.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM local_ledger WHERE from_public_id=$1 AND to_public_id=$2)
+		`, t.FromPublicID, t.ToPublicID).Scan(&known)
+		if err != nil {
+			return nil, err
+		}
+		if !known {
+			return map[string]any{"to_public_id": t.ToPublicID, "amount": t.Amount, "currency": t.Currency, "min_amount": r.MinAmount}, nil
+		}
+	case "daily_cap":
+		var spent int64
+		err := DB.QueryRowContext(ctx, `
+			SELECT COALESCE(SUM(l.amount), 0)
+			FROM local_ledger l
+			JOIN local_dag_nodes d ON d.ledger_id = l.id
+			WHERE l.from_public_id=$1 AND l.currency=$2
+			  AND l.created_at >= date_trunc('day', NOW() AT TIME ZONE 'UTC') AT TIME ZONE 'UTC'
+			  AND (cardinality($3::text[]) = 0 OR l.tx_type = ANY($3))
+			  AND d.status NOT IN ('rejected','quarantined')
+		`, t.FromPublicID, t.Currency, pq.Array(r.TxTypes)).Scan(&spent)
+		if err != nil {
+			return nil, err
+		}
+		if spent+t.Amount > r.MaxAmount {
+			return map[string]any{"spent_today": spent, "amount": t.Amount, "currency": t.Currency, "max_amount": r.MaxAmount}, nil
+		}
+	}
+	return nil, nil
+}
+
+// checkFraud is the handler-side gate. A blocking hit answers 403, records
+// a fraud_rule_blocked alert against the would-be tx_hash and returns false;
+// otherwise the flagging hits come back for flagFraudHits once t is stored.
+func checkFraud(c *gin.Context, t localTx) ([]fraudHit, bool) {
+	ctx := c.Request.Context()
+	hits, err := evaluateFraudRules(ctx, t)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_fraud_rules", "details": err.Error()})
+		return nil, false
+	}
+	if h := blockingHit(hits); h != nil {
+		recordFraudBlock(ctx, t, *h)
+		c.JSON(http.StatusForbidden, gin.H{"error": "fraud_rule_blocked", "rule_id": h.RuleID, "evidence": h.Evidence})
+		return nil, false
+	}
+	return hits, true
+}
+
+// blockingHit returns the first blocking hit, if any.
+func blockingHit(hits []fraudHit) *fraudHit {
+	for i := range hits {
+		if hits[i].Action == "block" {
+			return &hits[i]
+		}
+	}
+	return nil
+}
+
+// recordFraudBlock raises a fraud_rule_blocked alert against the tx_hash t
+// would have had.
+func recordFraudBlock(ctx context.Context, t localTx, h fraudHit) {
+	_, txHash, _ := encodeTx(t)
+	raiseTamperAlert(ctx, txHash, "fraud_rule_blocked", map[string]any{
+		"rule_id":        h.RuleID,
+		"type":           h.Type,
+		"from_public_id": t.FromPublicID,
+		"evidence":       h.Evidence,
+	})
+}
+
+// flagFraudHits raises one fraud_rule_flagged alert per hit on a stored tx.
+func flagFraudHits(ctx context.Context, txHash string, t localTx, hits []fraudHit) {
+	for _, h := range hits {
+		raiseTamperAlert(ctx, txHash, "fraud_rule_flagged", map[string]any{
+			"rule_id":        h.RuleID,
+			"type":           h.Type,
+			"from_public_id": t.FromPublicID,
+			"evidence":       h.Evidence,
+		})
+	}
+}
+
+// === Handlers ===
+
+// HandlerAdminListFraudRules returns the rule set in force.
+func HandlerAdminListFraudRules(c *gin.Context) {
+	set := fraudRules.Load()
+	if set == nil {
+		c.JSON(200, gin.H{"ok": true, "rules": []fraudRule{}})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true, "rules": set.Rules, "source": set.Source, "loaded_at": set.LoadedAt})
+}
+
+// HandlerAdminReloadFraudRules re-reads FraudRulesFile.
+func HandlerAdminReloadFraudRules(c *gin.Context) {
+	if FraudRulesFile == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "fraud_rules_not_configured"})
+		return
+	}
+	n, err := loadFraudRules(FraudRulesFile)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_fraud_rules", "details": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true, "rules": n})
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fxRatePrecision is how many decimals of an effective rate we record and
+// convert with.
+const fxRatePrecision = 12
+
+var errNoFxRate = errors.New("no fx rate")
+
+// FxRateTolerance is how far, relative to our own table, the rate a sibling
+// recorded in an fx_transfer may be before we reject it. Rate tables are
+// updated independently, so siblings only roughly agree.
+var FxRateTolerance = big.NewRat(1, 200)
+
+func setFxRateTolerance(v string) error {
+	r, ok := new(big.Rat).SetString(v)
+	if !ok || r.Sign() < 0 {
+		return fmt.Errorf("want a non-negative decimal, got %q", v)
+	}
+	FxRateTolerance = r
+	return nil
+}
+
+// fxRate is one row of the rate table: 1 base = Rate quote.
+type fxRate struct {
+	Base      string `json:"base"`
+	Quote     string `json:"quote"`
+	Rate      string `json:"rate"`
+	Source    string `json:"source,omitempty"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+// fxQuote is the rate applied to an fx_transfer; it goes into the DAG
+// payload verbatim.
+type fxQuote struct {
+	Rate          string `json:"rate"` // 1 currency = rate counter_currency
+	Base          string `json:"base"`
+	Quote         string `json:"quote"`
+	Inverted      bool   `json:"inverted,omitempty"` // derived from the quote->base row
+	RateSource    string `json:"rate_source"`
+	RateUpdatedAt string `json:"rate_updated_at"`
+}
+
+// loadFxRatesFile upserts rates from a JSON array of {base, quote, rate}.
+func loadFxRatesFile(ctx context.Context, path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var rates []fxRate
+	if err := json.Unmarshal(raw, &rates); err != nil {
+		return err
+	}
+	n, err := upsertFxRates(ctx, rates, "file")
+	if err != nil {
+		return err
+	}
+	log.Printf("fx: loaded %d rates from %s", n, path)
+	return nil
+}
+
+func upsertFxRates(ctx context.Context, rates []fxRate, source string) (int, error) {
+	for _, r := range rates {
+		if !currencyCodeRe.MatchString(r.Base) || !currencyCodeRe.MatchString(r.Quote) || r.Base == r.Quote {
+			return 0, fmt.Errorf("bad pair %s/%s", r.Base, r.Quote)
+		}
+		if v, ok := new(big.Rat).SetString(r.Rate); !ok || v.Sign() <= 0 {
+			return 0, fmt.Errorf("bad rate for %s/%s", r.Base, r.Quote)
+		}
+	}
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	for _, r := range rates {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO local_fx_rates (base, quote, rate, source, updated_at)
+			VALUES ($1,$2,$3::numeric,$4,NOW())
+			ON CONFLICT (base, quote) DO UPDATE
+			  SET rate=EXCLUDED.rate, source=EXCLUDED.source, updated_at=NOW()
+		`, r.Base, r.Quote, r.Rate, source); err != nil {
+			return 0, err
+		}
+	}
+	return len(rates), tx.Commit()
+}
+
+// lookupFxQuote finds the rate from currency to counter, inverting the
+// opposite pair when only that one is on file.
+func lookupFxQuote(ctx context.Context, currency, counter string) (fxQuote, *big.Rat, error) {
+	var rate string
+	var updated time.Time
+	q := fxQuote{Base: currency, Quote: counter}
+	err := DB.QueryRowContext(ctx, `
+		SELECT rate::text, source, updated_at FROM local_fx_rates WHERE base=$1 AND quote=$2
+	`, currency, counter).Scan(&rate, &q.RateSource, &updated)
+	if err == sql.ErrNoRows {
+		q.Inverted = true
+		err = DB.QueryRowContext(ctx, `
+			SELECT rate::text, source, updated_at FROM local_fx_rates WHERE base=$1 AND quote=$2
+		`, counter, currency).Scan(&rate, &q.RateSource, &updated)
+	}
+	if err == sql.ErrNoRows {
+		return q, nil, errNoFxRate
+	}
+	if err != nil {
+		return q, nil, err
+	}
+	r, ok := new(big.Rat).SetString(rate)
+	if !ok || r.Sign() <= 0 {
+		return q, nil, errNoFxRate
+	}
+	if q.Inverted {
+		r.Inv(r)
+	}
+	// convert with exactly the rate we record
+	q.Rate = r.FloatString(fxRatePrecision)
+	r.SetString(q.Rate)
+	q.RateUpdatedAt = updated.UTC().Format(time.RFC3339)
+	return q, r, nil
+}
+
+// convertMinor converts amount minor units at fromScale into minor units at
+// toScale, rounding half up.
+func convertMinor(amount int64, rate *big.Rat, fromScale, toScale int) (int64, error) {
+	v := new(big.Rat).SetInt64(amount)
+	v.Mul(v, rate)
+	shift := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(toScale-fromScale))), nil))
+	if toScale >= fromScale {
+		v.Mul(v, shift)
+	} else {
+		v.Quo(v, shift)
+	}
+	num := new(big.Int).Mul(v.Num(), big.NewInt(2))
+	num.Add(num, v.Denom())
+	out := num.Quo(num, new(big.Int).Mul(v.Denom(), big.NewInt(2)))
+	if !out.IsInt64() {
+		return 0, errors.New("converted amount overflows")
+	}
+	return out.Int64(), nil
+}
+
+// checkFxLeg checks the rate recorded in a gossiped fx_transfer's payload
+// against our own rate table, within FxRateTolerance, and recomputes its
+// counter_amount from that rate with this node's currency scales, so an
+// origin can neither pick its own rate nor credit more than the rate it
+// claims. Empty means it matches.
+func checkFxLeg(ctx context.Context, t localTx) string {
+	if t.TxType != "fx_transfer" {
+		return ""
+	}
+	var p struct {
+		Fx fxQuote `json:"fx"`
+	}
+	if err := json.Unmarshal(t.Payload, &p); err != nil {
+		return "fx_rate_invalid"
+	}
+	if p.Fx.Base != t.Currency || p.Fx.Quote != t.CounterCurrency {
+		return "fx_pair_mismatch"
+	}
+	rate, ok := new(big.Rat).SetString(p.Fx.Rate)
+	if !ok || rate.Sign() <= 0 {
+		return "fx_rate_invalid"
+	}
+	_, own, err := lookupFxQuote(ctx, t.Currency, t.CounterCurrency)
+	if err != nil {
+		return "fx_rate_unknown"
+	}
+	diff := new(big.Rat).Sub(rate, own)
+	diff.Abs(diff)
+	if diff.Cmp(new(big.Rat).Mul(own, FxRateTolerance)) > 0 {
+		return "fx_rate_off_table"
+	}
+	scale, err := currencyScale(ctx, t.Currency)
+	if err != nil || scale != t.Scale {
+		return "fx_currency_unknown"
+	}
+	counterScale, err := currencyScale(ctx, t.CounterCurrency)
+	if err != nil {
+		return "fx_currency_unknown"
+	}
+	want, err := convertMinor(t.Amount, rate, scale, counterScale)
+	if err != nil || want != t.CounterAmount {
+		return "fx_counter_amount_mismatch"
+	}
+	return ""
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// === Handlers ===
+
+// HandlerListFxRates returns the rate table.
+func HandlerListFxRates(c *gin.Context) {
+	rows, err := DB.QueryContext(c.Request.Context(), `
+		SELECT base, quote, rate::text, source, updated_at FROM local_fx_rates ORDER BY base, quote
+	`)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_list_fx_rates", "details": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	rates := []fxRate{}
+	for rows.Next() {
+		var r fxRate
+		var ts time.Time
+		if err := rows.Scan(&r.Base, &r.Quote, &r.Rate, &r.Source, &ts); err != nil {
+			c.JSON(500, gin.H{"error": "db_scan_fx_rate", "details": err.Error()})
+			return
+		}
+		r.UpdatedAt = ts.UTC().Format(time.RFC3339)
+		rates = append(rates, r)
+	}
+	c.JSON(200, gin.H{"ok": true, "rates": rates})
+}
+
+// HandlerAdminPutFxRates upserts a JSON array of {base, quote, rate}.
+func HandlerAdminPutFxRates(c *gin.Context) {
+	var rates []fxRate
+	if err := c.BindJSON(&rates); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request", "details": err.Error()})
+		return
+	}
+	n, err := upsertFxRates(c.Request.Context(), rates, "admin")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_rates", "details": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true, "updated": n})
+}
+
+// HandlerSubmitFxTransfer debits the payer in currency and credits the payee
+// in counter_currency at the current rate, recorded in the payload.
+func HandlerSubmitFxTransfer(c *gin.Context) {
+	var req struct {
+		FromPublicID    string `json:"from_public_id"`
+		ToPublicID      string `json:"to_public_id"`
+		Amount          int64  `json:"amount"`
+		Currency        string `json:"currency"`
+		CounterCurrency string `json:"counter_currency"`
+		Nonce           int64  `json:"nonce"`
+		TsUnixMs        int64  `json:"ts_unix_ms"`
+		UserSig         string `json:"user_sig"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request", "details": err.Error()})
+		return
+	}
+	if req.Currency == "" {
+		req.Currency = DefaultCurrency
+	}
+	if req.Currency == req.CounterCurrency || req.Currency == noCurrency || req.CounterCurrency == noCurrency {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_tx", "details": "currency and counter_currency must be two different currencies"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	userPub, ok := checkUserSig(c, clientTx{
+		FromPublicID:    req.FromPublicID,
+		ToPublicID:      req.ToPublicID,
+		Amount:          req.Amount,
+		Currency:        req.Currency,
+		CounterCurrency: req.CounterCurrency,
+		TxType:          "fx_transfer",
+		Nonce:           req.Nonce,
+		TsUnixMs:        req.TsUnixMs,
+	}, req.UserSig)
+	if !ok {
+		return
+	}
+
+	scale, err := currencyScale(ctx, req.Currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown_currency", "currency": req.Currency})
+		return
+	}
+	counterScale, err := currencyScale(ctx, req.CounterCurrency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown_currency", "currency": req.CounterCurrency})
+		return
+	}
+	quote, rate, err := lookupFxQuote(ctx, req.Currency, req.CounterCurrency)
+	if errors.Is(err, errNoFxRate) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "no_fx_rate", "base": req.Currency, "quote": req.CounterCurrency})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_fx_rate", "details": err.Error()})
+		return
+	}
+	counterAmount, err := convertMinor(req.Amount, rate, scale, counterScale)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_tx", "details": err.Error()})
+		return
+	}
+
+	parents, err := selectParents(ctx)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_select_parents", "details": err.Error()})
+		return
+	}
+	payload, _ := json.Marshal(map[string]any{"fx": quote})
+	t := localTx{
+		FromPublicID:    req.FromPublicID,
+		ToPublicID:      req.ToPublicID,
+		Amount:          req.Amount,
+		Currency:        req.Currency,
+		Scale:           scale,
+		CounterAmount:   counterAmount,
+		CounterCurrency: req.CounterCurrency,
+		TxType:          "fx_transfer",
+		Nonce:           req.Nonce,
+		TsUnixMs:        time.Now().UnixMilli(),
+		Payload:         payload,
+		Parents:         parents,
+		UserPub:         userPub,
+	}
+	if userPub != "" {
+		t.UserSig, t.UserTsUnixMs = req.UserSig, req.TsUnixMs
+	}
+	if err := t.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_tx", "details": err.Error()})
+		return
+	}
+	if !checkNonceUnused(c, t) {
+		return
+	}
+	flags, ok := checkFraud(c, t)
+	if !ok {
+		return
+	}
+
+	msg, err := commitLocalTx(ctx, t)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_insert_tx", "details": err.Error()})
+		return
+	}
+	flagFraudHits(ctx, msg.TxHash, t, flags)
+	c.JSON(200, gin.H{
+		"ok":               true,
+		"tx_hash":          msg.TxHash,
+		"parents":          parents,
+		"counter_amount":   counterAmount,
+		"counter_currency": req.CounterCurrency,
+		"fx":               quote,
+	})
+
+	go announceTx(msg, parents)
+}
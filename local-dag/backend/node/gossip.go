@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gossipMessage pushes a freshly appended local DAG node to cluster siblings.
+// TxBody is the exact canonical JSON the origin hashed and signed.
+type gossipMessage struct {
+	TxBody []byte         `json:"tx_body"`
+	TxHash string         `json:"tx_hash"`
+	Origin signedEnvelope `json:"origin"`
+	Round  int            `json:"round"`
+}
+
+// localVote is a sibling's TPM-signed verdict on a tx for one round.
+type localVote struct {
+	TxHash        string         `json:"tx_hash"`
+	Parents       []string       `json:"parents"`
+	ParentsDigest string         `json:"parents_digest"`
+	Round         int            `json:"round"`
+	Verdict       string         `json:"verdict"` // accept | reject
+	Reason        string         `json:"reason,omitempty"`
+	Voter         signedEnvelope `json:"voter"`
+}
+
+func parentsDigest(parents []string) string {
+	h := sha256.Sum256([]byte(strings.Join(parents, ",")))
+	return hex.EncodeToString(h[:])
+}
+
+// voteMessage is the exact message a voter signs.
+func voteMessage(txHash, digest string, round int, verdict string) []byte {
+	return []byte(fmt.Sprintf("vote|%s|%s|%d|%s", txHash, digest, round, verdict))
+}
+
+// === Peer Handler ===
+
+// HandlerPeerGossip re-verifies a sibling's DAG node, inserts it, records the
+// verdict for this round and answers with our signed vote.
+func HandlerPeerGossip(c *gin.Context) {
+	var msg gossipMessage
+	if err := c.BindJSON(&msg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request", "details": err.Error()})
+		return
+	}
+	if msg.Round < 1 {
+		msg.Round = 1
+	}
+	ctx := c.Request.Context()
+
+	t, reason := verifyGossip(ctx, msg)
+	if reason == "auth_unavailable" {
+		// not a verdict: the origin retries once we can resolve the key
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": reason})
+		return
+	}
+	if reason == "" {
+		missing, err := missingParents(ctx, t.Parents)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db_check_parents", "details": err.Error()})
+			return
+		}
+		if len(missing) > 0 {
+			// not a verdict yet: the origin retries once the parents arrive
+			c.JSON(http.StatusConflict, gin.H{"error": "parents_unknown", "missing": missing})
+			return
+		}
+		registered, err := assetRegistered(ctx, t)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db_check_asset", "details": err.Error()})
+			return
+		}
+		if !registered {
+			// likewise: the issue_asset tx has not reached us yet
+			c.JSON(http.StatusConflict, gin.H{"error": "asset_unknown"})
+			return
+		}
+		reason = checkBulkParent(ctx, t, msg.TxHash)
+	}
+	if reason == "" {
+		if err := insertGossipedTx(ctx, t, msg); err != nil {
+			c.JSON(500, gin.H{"error": "db_insert_tx", "details": err.Error()})
+			return
+		}
+	} else {
+		raiseTamperAlert(ctx, msg.TxHash, "gossip_verification_failed", map[string]any{
+			"reason":      reason,
+			"origin_node": msg.Origin.NodeID,
+			"round":       msg.Round,
+		})
+	}
+
+	vote, err := castVote(ctx, msg.TxHash, t.Parents, msg.Round, reason)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "vote_failed", "details": err.Error()})
+		return
+	}
+
+	if reason != "" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": reason, "vote": vote})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true, "vote": vote})
+}
+
+// verifyGossip returns the decoded tx and an empty reason if msg is sound.
+func verifyGossip(ctx context.Context, msg gossipMessage) (localTx, string) {
+	var t localTx
+	h := sha256.Sum256(msg.TxBody)
+	if hex.EncodeToString(h[:]) != msg.TxHash {
+		return t, "tx_hash_mismatch"
+	}
+	if err := verifyEnvelope(ctx, msg.Origin, txSignMessage(msg.TxHash)); err != nil {
+		if err == errNodeKeyMismatch {
+			return t, "origin_key_mismatch"
+		}
+		if err == errNodeQuarantined {
+			return t, "origin_quarantined"
+		}
+		return t, "origin_signature_invalid"
+	}
+	if err := json.Unmarshal(msg.TxBody, &t); err != nil {
+		return t, "invalid_tx_body"
+	}
+	if err := t.validate(); err != nil {
+		return t, "invalid_tx"
+	}
+	if err := checkEmbeddedUserSig(ctx, t); err != nil {
+		if err == errAuthUnavailable {
+			return t, "auth_unavailable"
+		}
+		return t, "user_signature_invalid"
+	}
+	if reason := checkFxLeg(ctx, t); reason != "" {
+		return t, reason
+	}
+	if reason := checkTradeLeg(ctx, t, msg.TxHash); reason != "" {
+		return t, reason
+	}
+	return t, ""
+}
+
+func insertGossipedTx(ctx context.Context, t localTx, msg gossipMessage) error {
+	inserted, err := groupCommit(ctx, t, msg.TxBody, msg.TxHash, msg.Origin.NodeID, msg.Origin.SigB64)
+	if err != nil {
+		return err
+	}
+	if inserted {
+		resolveConflictsAfterInsert(ctx, t, msg.TxHash)
+		// votes that beat the gossip here were stored but not tallied
+		if err := tallyVotes(ctx, msg.TxHash); err != nil {
+			log.Printf("gossip: tx=%s tally_failed=%v", msg.TxHash, err)
+		}
+	}
+	return nil
+}
+
+// castVote records our verdict in local_verification_log, signs it and
+// stores the vote in local_attestations. An empty reason means accept.
+func castVote(ctx context.Context, txHash string, parents []string, round int, reason string) (localVote, error) {
+	verdict := "accept"
+	if reason != "" {
+		verdict = "reject"
+	}
+	if parents == nil {
+		parents = []string{}
+	}
+	digest := parentsDigest(parents)
+
+	details, _ := json.Marshal(map[string]any{"verdict": verdict, "reason": reason, "parents": parents})
+	_, err := DB.ExecContext(ctx, `
+		INSERT INTO local_verification_log (tx_hash, verified, verifier_node, consensus_round, details)
+		VALUES ($1,$2,$3,$4,$5::jsonb)
+	`, txHash, reason == "", NodeID, round, string(details))
+	if err != nil {
+		return localVote{}, err
+	}
+
+	env, err := signEnvelope(voteMessage(txHash, digest, round, verdict))
+	if err != nil {
+		return localVote{}, err
+	}
+	v := localVote{
+		TxHash:        txHash,
+		Parents:       parents,
+		ParentsDigest: digest,
+		Round:         round,
+		Verdict:       verdict,
+		Reason:        reason,
+		Voter:         env,
+	}
+	if err := storeVote(ctx, v); err != nil {
+		return v, err
+	}
+	go broadcastVote(v, PeersList)
+	return v, nil
+}
+
+// storeVote persists a verified vote in local_attestations and re-tallies
+// the tx. A second, different vote from the same node for the same round is
+// equivocation and gets flagged.
+func storeVote(ctx context.Context, v localVote) error {
+	details, _ := json.Marshal(map[string]any{
+		"verdict":        v.Verdict,
+		"reason":         v.Reason,
+		"parents":        v.Parents,
+		"parents_digest": v.ParentsDigest,
+		"parent_pub_b64": v.Voter.ParentPubB64,
+		"attestation":    v.Voter.Attestation,
+	})
+	var id string
+	err := DB.QueryRowContext(ctx, `
+		INSERT INTO local_attestations (node_id, tx_hash, round, signature, verified, verified_at, details)
+		VALUES ($1,$2,$3,$4,true,NOW(),$5::jsonb)
+		ON CONFLICT (node_id, tx_hash, round) DO NOTHING
+		RETURNING id
+	`, v.Voter.NodeID, v.TxHash, v.Round, v.Voter.SigB64, string(details)).Scan(&id)
+	if err == sql.ErrNoRows {
+		var prevVerdict, prevDigest string
+		if err := DB.QueryRowContext(ctx, `
+			SELECT details->>'verdict', details->>'parents_digest' FROM local_attestations
+			WHERE node_id=$1 AND tx_hash=$2 AND round=$3
+		`, v.Voter.NodeID, v.TxHash, v.Round).Scan(&prevVerdict, &prevDigest); err != nil {
+			return err
+		}
+		if prevVerdict != v.Verdict || prevDigest != v.ParentsDigest {
+			raiseTamperAlert(ctx, v.TxHash, "vote_equivocation", map[string]any{
+				"voter":          v.Voter.NodeID,
+				"round":          v.Round,
+				"first_verdict":  prevVerdict,
+				"first_digest":   prevDigest,
+				"second_verdict": v.Verdict,
+				"second_digest":  v.ParentsDigest,
+			})
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return tallyVotes(ctx, v.TxHash)
+}
+
+// verifyVote checks the voter's signature and that the digest matches the parents.
+func verifyVote(ctx context.Context, v localVote) error {
+	if v.Verdict != "accept" && v.Verdict != "reject" {
+		return fmt.Errorf("bad verdict %q", v.Verdict)
+	}
+	if parentsDigest(v.Parents) != v.ParentsDigest {
+		return fmt.Errorf("parents digest mismatch")
+	}
+	return verifyEnvelope(ctx, v.Voter, voteMessage(v.TxHash, v.ParentsDigest, v.Round, v.Verdict))
+}
+
+func raiseTamperAlert(ctx context.Context, offendingTx, description string, evidence map[string]any) {
+	evb, _ := json.Marshal(evidence)
+	if _, err := DB.ExecContext(ctx, `
+		INSERT INTO local_tamper_alerts (offending_tx, description, evidence)
+		VALUES ($1,$2,$3::jsonb)
+	`, offendingTx, description, string(evb)); err != nil {
+		log.Printf("tamper alert insert failed: tx=%s err=%v", offendingTx, err)
+	}
+}
+
+// === Propagation ===
+
+// gossipTx pushes msg to every sibling, retrying while parents are unknown
+// or the sibling is unreachable, and stores the votes they answer with.
+func gossipTx(msg gossipMessage, peers []string) {
+	client := &http.Client{Timeout: 3 * time.Second}
+	limiter := make(chan struct{}, 6)
+	var wg sync.WaitGroup
+
+	body, _ := json.Marshal(msg)
+
+	for _, p := range peers {
+		wg.Add(1)
+		limiter <- struct{}{}
+		go func(peer string) {
+			defer wg.Done()
+			defer func() { <-limiter }()
+			url := strings.TrimRight(peer, "/") + "/peer/gossip"
+			backoff := time.Millisecond * 200
+			for i := 0; i < 5; i++ {
+				req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				resp, err := client.Do(req)
+				if err == nil {
+					var out struct {
+						Vote *localVote `json:"vote"`
+					}
+					_ = json.NewDecoder(resp.Body).Decode(&out)
+					_ = resp.Body.Close()
+					if out.Vote != nil {
+						acceptPeerVote(*out.Vote)
+					}
+					if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusUnprocessableEntity {
+						return
+					}
+				}
+				time.Sleep(backoff + time.Duration(rand.Int63n(int64(backoff))))
+				backoff *= 2
+			}
+			log.Printf("gossip: tx=%s peer=%s delivered=false", msg.TxHash, peer)
+		}(p)
+	}
+	wg.Wait()
+}
+
+func acceptPeerVote(v localVote) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := verifyVote(ctx, v); err != nil {
+		log.Printf("gossip: tx=%s vote_from=%s verified=false reason=%v", v.TxHash, v.Voter.NodeID, err)
+		return
+	}
+	if err := storeVote(ctx, v); err != nil {
+		log.Printf("gossip: tx=%s vote_from=%s store_failed=%v", v.TxHash, v.Voter.NodeID, err)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// CommitBatchSize and CommitBatchDelay tune the group-commit pipeline. A
+// batch is written once it holds CommitBatchSize txs or its first tx has
+// waited CommitBatchDelay; a delay of 0 writes whatever queued up while the
+// previous batch was in flight.
+var (
+	CommitBatchSize  = 256
+	CommitBatchDelay = 2 * time.Millisecond
+)
+
+// Verification and signing happen on each caller's goroutine; only the
+// writes are funnelled through one writer, which turns a batch into a single
+// transaction with one multi-row INSERT per table.
+
+// pendingTx is one queued write and the channel its caller waits on.
+type pendingTx struct {
+	t          localTx
+	body       []byte
+	txHash     string
+	originNode string
+	nodeSig    string
+
+	// the "received" transition, signed before queueing
+	receivedAt  int64
+	receivedSig string
+
+	done chan pendingResult
+}
+
+type pendingResult struct {
+	inserted bool
+	err      error
+}
+
+var commitQueue chan *pendingTx
+
+// errBatchConflict means a tx_hash in the batch was stored concurrently; the
+// batch is rolled back and retried one tx at a time.
+var errBatchConflict = errors.New("tx_hash stored concurrently")
+
+// startGroupCommit starts the writer. Callers block in groupCommit once the
+// queue is full, which is the pipeline's back-pressure.
+func startGroupCommit() {
+	if CommitBatchSize < 1 {
+		CommitBatchSize = 1
+	}
+	commitQueue = make(chan *pendingTx, 4*CommitBatchSize)
+	go groupCommitLoop()
+}
+
+// groupCommit queues one tx for the next batch and waits for its own
+// outcome, with the same contract as insertLocalTx in a transaction of its
+// own: false without error if tx_hash was already known. Once queued the
+// write is not abandoned, so a cancelled ctx cannot leave a stored tx that
+// the caller believes failed.
+func groupCommit(ctx context.Context, t localTx, body []byte, txHash, originNode, nodeSig string) (bool, error) {
+	at := time.Now().UnixMilli()
+	env, err := signEnvelope(transitionMessage(txHash, "", "received", "", at))
+	if err != nil {
+		return false, err
+	}
+	p := &pendingTx{
+		t:           t,
+		body:        body,
+		txHash:      txHash,
+		originNode:  originNode,
+		nodeSig:     nodeSig,
+		receivedAt:  at,
+		receivedSig: env.SigB64,
+		done:        make(chan pendingResult, 1),
+	}
+	select {
+	case commitQueue <- p:
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+	r := <-p.done
+	return r.inserted, r.err
+}
+
+func groupCommitLoop() {
+	for first := range commitQueue {
+		writeBatch(gatherBatch(first))
+	}
+}
+
+// gatherBatch collects txs behind first until the batch is full or
+// CommitBatchDelay has passed.
+func gatherBatch(first *pendingTx) []*pendingTx {
+	batch := []*pendingTx{first}
+	if CommitBatchDelay <= 0 {
+		for len(batch) < CommitBatchSize {
+			select {
+			case p := <-commitQueue:
+				batch = append(batch, p)
+			default:
+				return batch
+			}
+		}
+		return batch
+	}
+	timer := time.NewTimer(CommitBatchDelay)
+	defer timer.Stop()
+	for len(batch) < CommitBatchSize {
+		select {
+		case p := <-commitQueue:
+			batch = append(batch, p)
+		case <-timer.C:
+			return batch
+		}
+	}
+	return batch
+}
+
+// writeBatch stores batch in one transaction and acknowledges every caller.
+// If the batch fails as a whole, each tx is retried on its own so one bad
+// row does not fail its neighbours.
+func writeBatch(batch []*pendingTx) {
+	ctx := context.Background()
+	inserted, err := insertBatch(ctx, batch)
+	if err == nil {
+		for i, p := range batch {
+			if inserted[i] {
+				DAG.Add(p.txHash, p.t.Parents)
+			}
+			p.done <- pendingResult{inserted: inserted[i]}
+		}
+		return
+	}
+	if len(batch) > 1 {
+		log.Printf("groupcommit: batch of %d failed, retrying singly: %v", len(batch), err)
+	}
+	for _, p := range batch {
+		ok, err := insertSingle(ctx, p)
+		if ok {
+			DAG.Add(p.txHash, p.t.Parents)
+		}
+		p.done <- pendingResult{inserted: ok, err: err}
+	}
+}
+
+// insertSingle is the one-tx fallback path.
+func insertSingle(ctx context.Context, p *pendingTx) (bool, error) {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	inserted, err := insertLocalTx(ctx, tx, p.t, p.body, p.txHash, p.originNode, p.nodeSig)
+	if err != nil || !inserted {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// insertBatch is insertLocalTx for many txs: ledger rows, DAG nodes,
+// "received" transitions and tx log leaves, one statement per table.
+// inserted[i] is false for txs already known or repeated within the batch.
+func insertBatch(ctx context.Context, batch []*pendingTx) ([]bool, error) {
+	inserted := make([]bool, len(batch))
+	hashes := make([]string, len(batch))
+	for i, p := range batch {
+		hashes[i] = p.txHash
+	}
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	known := map[string]bool{}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT tx_hash FROM local_dag_nodes WHERE tx_hash = ANY($1)
+	`, pq.Array(hashes))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		known[h] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var fresh []*pendingTx
+	for i, p := range batch {
+		if known[p.txHash] {
+			continue
+		}
+		known[p.txHash] = true
+		inserted[i] = true
+		fresh = append(fresh, p)
+	}
+	if len(fresh) == 0 {
+		return inserted, nil
+	}
+
+	ids, err := newLedgerIDs(ctx, tx, len(fresh))
+	if err != nil {
+		return nil, err
+	}
+
+	// one array per column, unnested server-side
+	var (
+		froms, tos, currencies, txTypes, payloads, createdAt []string
+		amounts, scales, nonces                              []int64
+		counterAmounts                                       []sql.NullInt64
+		counterCurrencies                                    []sql.NullString
+
+		freshHashes, parents, origins, nodeSigs, bodies, receivedAt, receivedSigs []string
+	)
+	for _, p := range fresh {
+		t := p.t
+		payload := string(t.Payload)
+		if payload == "" {
+			payload = `{}`
+		}
+		var counterAmount sql.NullInt64
+		var counterCurrency sql.NullString
+		if t.CounterCurrency != "" {
+			counterAmount = sql.NullInt64{Int64: t.CounterAmount, Valid: true}
+			counterCurrency = sql.NullString{String: t.CounterCurrency, Valid: true}
+		}
+		ps, _ := json.Marshal(t.Parents)
+
+		froms = append(froms, t.FromPublicID)
+		tos = append(tos, t.ToPublicID)
+		amounts = append(amounts, t.Amount)
+		currencies = append(currencies, t.Currency)
+		scales = append(scales, int64(t.Scale))
+		counterAmounts = append(counterAmounts, counterAmount)
+		counterCurrencies = append(counterCurrencies, counterCurrency)
+		txTypes = append(txTypes, t.TxType)
+		nonces = append(nonces, t.Nonce)
+		payloads = append(payloads, payload)
+		createdAt = append(createdAt, time.UnixMilli(t.TsUnixMs).UTC().Format(time.RFC3339Nano))
+
+		freshHashes = append(freshHashes, p.txHash)
+		parents = append(parents, string(ps))
+		origins = append(origins, p.originNode)
+		nodeSigs = append(nodeSigs, p.nodeSig)
+		bodies = append(bodies, string(p.body))
+		receivedAt = append(receivedAt, time.UnixMilli(p.receivedAt).UTC().Format(time.RFC3339Nano))
+		receivedSigs = append(receivedSigs, p.receivedSig)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO local_ledger (id, from_public_id, to_public_id, amount, currency, scale, counter_amount, counter_currency, tx_type, nonce, payload, created_at)
+		SELECT u.id, u.from_id, u.to_id, u.amount, u.currency, u.scale, u.counter_amount, u.counter_currency, u.tx_type, u.nonce, u.payload::jsonb, u.created_at
+		FROM unnest($1::uuid[], $2::text[], $3::text[], $4::bigint[], $5::text[], $6::int[], $7::bigint[], $8::text[], $9::text[], $10::bigint[], $11::text[], $12::timestamptz[])
+		  AS u(id, from_id, to_id, amount, currency, scale, counter_amount, counter_currency, tx_type, nonce, payload, created_at)
+	`, pq.Array(ids), pq.Array(froms), pq.Array(tos), pq.Array(amounts), pq.Array(currencies), pq.Array(scales),
+		pq.Array(counterAmounts), pq.Array(counterCurrencies), pq.Array(txTypes), pq.Array(nonces), pq.Array(payloads), pq.Array(createdAt))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO local_dag_nodes (ledger_id, tx_hash, parents, dag_type, node_id, node_signature, tx_body)
+		SELECT u.ledger_id, u.tx_hash, ARRAY(SELECT jsonb_array_elements_text(u.parents::jsonb)), 'local', u.node_id, u.node_signature, u.tx_body
+		FROM unnest($1::uuid[], $2::text[], $3::text[], $4::text[], $5::text[], $6::text[])
+		  AS u(ledger_id, tx_hash, parents, node_id, node_signature, tx_body)
+		ON CONFLICT (tx_hash) DO NOTHING
+	`, pq.Array(ids), pq.Array(freshHashes), pq.Array(parents), pq.Array(origins), pq.Array(nodeSigs), pq.Array(bodies))
+	if err != nil {
+		return nil, err
+	}
+	if stored, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if stored != int64(len(fresh)) {
+		return nil, errBatchConflict
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO local_tx_transitions (tx_hash, from_status, to_status, reason, node_id, signature, created_at)
+		SELECT u.tx_hash, NULL, 'received', NULL, $1, u.signature, u.created_at
+		FROM unnest($2::text[], $3::text[], $4::timestamptz[]) AS u(tx_hash, signature, created_at)
+	`, NodeID, pq.Array(freshHashes), pq.Array(receivedSigs), pq.Array(receivedAt))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := appendTxLog(ctx, tx, freshHashes...); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return inserted, nil
+}
+
+// newLedgerIDs draws n ledger ids up front so DAG nodes can reference their
+// rows without relying on RETURNING order.
+func newLedgerIDs(ctx context.Context, tx *sql.Tx, n int) ([]string, error) {
+	var ids []string
+	err := tx.QueryRowContext(ctx, `
+		SELECT array_agg(gen_random_uuid()::text) FROM generate_series(1, $1)
+	`, n).Scan(pq.Array(&ids))
+	return ids, err
+}
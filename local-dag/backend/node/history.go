@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 200
+)
+
+// historyItem is one row of GET /api/transactions, shaped for the dashboard
+// hooks. Amount is signed from accountId's point of view when one is given.
+type historyItem struct {
+	ID       string `json:"id"`
+	TxHash   string `json:"tx_hash"`
+	Ts       string `json:"ts"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+	Scale    int    `json:"scale"`
+	TxType   string `json:"tx_type"`
+	Status   string `json:"status"`   // confirmed | pending | failed
+	Finality string `json:"finality"` // raw DAG status
+
+	CounterAmount   *int64  `json:"counter_amount,omitempty"`
+	CounterCurrency *string `json:"counter_currency,omitempty"`
+}
+
+// historyCursor is the (created_at, id) of the last row on a page.
+type historyCursor struct {
+	Ts time.Time
+	ID string
+}
+
+func encodeHistoryCursor(c historyCursor) string {
+	raw := c.Ts.UTC().Format(time.RFC3339Nano) + "|" + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeHistoryCursor(s string) (historyCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return historyCursor{}, err
+	}
+	ts, id, ok := strings.Cut(string(raw), "|")
+	if !ok || id == "" {
+		return historyCursor{}, errors.New("malformed cursor")
+	}
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return historyCursor{}, err
+	}
+	return historyCursor{Ts: t, ID: id}, nil
+}
+
+// dashboardStatus folds DAG states into the three the dashboard knows.
+func dashboardStatus(dagStatus string) string {
+	switch dagStatus {
+	case "finalized":
+		return "confirmed"
+	case "rejected", "quarantined":
+		return "failed"
+	default:
+		return "pending"
+	}
+}
+
+// HandlerListTransactions serves newest-first keyset pages over local_ledger.
+//
+// Query: limit, cursor, accountId, direction (in|out|all), type, currency,
+// minAmount, maxAmount, from, to (RFC 3339). Amount filters compare minor
+// units in each row's own currency.
+func HandlerListTransactions(c *gin.Context) {
+	limit := defaultHistoryLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_limit"})
+			return
+		}
+		limit = min(n, maxHistoryLimit)
+	}
+
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	account := c.Query("accountId")
+	direction := c.DefaultQuery("direction", "all")
+	switch direction {
+	case "all", "in", "out":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_direction"})
+		return
+	}
+	if account != "" {
+		p := arg(account)
+		switch direction {
+		case "in":
+			where = append(where, "l.to_public_id="+p)
+		case "out":
+			where = append(where, "l.from_public_id="+p)
+		default:
+			where = append(where, "(l.from_public_id="+p+" OR l.to_public_id="+p+")")
+		}
+	} else if direction != "all" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "direction_requires_account"})
+		return
+	}
+
+	if v := c.Query("type"); v != "" {
+		where = append(where, "l.tx_type="+arg(v))
+	}
+	if v := c.Query("currency"); v != "" {
+		where = append(where, "l.currency="+arg(v))
+	}
+	for _, f := range []struct{ key, op string }{{"minAmount", ">="}, {"maxAmount", "<="}} {
+		if v := c.Query(f.key); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "bad_" + f.key})
+				return
+			}
+			where = append(where, "l.amount"+f.op+arg(n))
+		}
+	}
+	for _, f := range []struct{ key, op string }{{"from", ">="}, {"to", "<"}} {
+		if v := c.Query(f.key); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "bad_" + f.key})
+				return
+			}
+			where = append(where, "l.created_at"+f.op+arg(t))
+		}
+	}
+	if v := c.Query("cursor"); v != "" {
+		cur, err := decodeHistoryCursor(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_cursor"})
+			return
+		}
+		where = append(where, "(l.created_at, l.id) < ("+arg(cur.Ts)+", "+arg(cur.ID)+"::uuid)")
+	}
+
+	q := `
+		SELECT l.id, d.tx_hash, l.created_at, l.from_public_id, l.to_public_id, l.amount, l.currency, l.scale,
+		       l.counter_amount, l.counter_currency, l.tx_type, d.status
+		FROM local_ledger l
+		JOIN local_dag_nodes d ON d.ledger_id = l.id`
+	if len(where) > 0 {
+		q += "\n\t\tWHERE " + strings.Join(where, " AND ")
+	}
+	// fetch one extra row to know whether another page exists
+	q += "\n\t\tORDER BY l.created_at DESC, l.id DESC\n\t\tLIMIT " + arg(limit+1)
+
+	rows, err := DB.QueryContext(c.Request.Context(), q, args...)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_list_transactions", "details": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	items := make([]historyItem, 0, limit)
+	var last historyCursor
+	hasMore := false
+	for rows.Next() {
+		if len(items) == limit {
+			hasMore = true
+			break
+		}
+		var it historyItem
+		var ts time.Time
+		if err := rows.Scan(&it.ID, &it.TxHash, &ts, &it.From, &it.To, &it.Amount, &it.Currency, &it.Scale,
+			&it.CounterAmount, &it.CounterCurrency, &it.TxType, &it.Finality); err != nil {
+			c.JSON(500, gin.H{"error": "db_scan_transaction", "details": err.Error()})
+			return
+		}
+		if account != "" && it.From == account && it.To != account {
+			it.Amount = -it.Amount
+		}
+		it.Ts = ts.UTC().Format(time.RFC3339Nano)
+		it.Status = dashboardStatus(it.Finality)
+		items = append(items, it)
+		last = historyCursor{Ts: ts, ID: it.ID}
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(500, gin.H{"error": "db_list_transactions", "details": err.Error()})
+		return
+	}
+
+	var next *string
+	if hasMore {
+		s := encodeHistoryCursor(last)
+		next = &s
+	}
+	// txs feeds useTransactions, transactions feeds useRecentTransactions
+	c.JSON(200, gin.H{"ok": true, "txs": items, "transactions": items, "nextCursor": next})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"hackodisha/backend/membership"
+	tpm "hackodisha/backend/tpm"
+)
+
+var errNodeKeyMismatch = errors.New("node key does not match pinned key")
+
+// Members is the configured node set (MEMBERSHIP_FILE). Signatures only
+// count if their signer is in it under its pinned parent key.
+var Members *membership.Set
+
+// loadMembers reads MEMBERSHIP_FILE and logs our own parent key, which
+// operators copy into everyone's file.
+func loadMembers(parentPubB64 string) {
+	log.Printf("membership: node=%s parent_pub_b64=%s", NodeID, parentPubB64)
+	path := os.Getenv("MEMBERSHIP_FILE")
+	if path == "" {
+		log.Printf("membership: MEMBERSHIP_FILE not set, no peer signature will verify")
+		return
+	}
+	s, err := membership.Load(path)
+	if err != nil {
+		log.Fatal("load membership failed:", err)
+	}
+	Members = s
+}
+
+// isClusterMember reports whether nodeID is a configured sibling (or us).
+func isClusterMember(nodeID string) bool {
+	_, ok := Members.ClusterMember(ClusterID, nodeID)
+	return ok
+}
+
+// signedEnvelope carries a TPM child signature together with everything a
+// sibling needs to verify it (parent pub + parent-signed attestation).
+type signedEnvelope struct {
+	NodeID       string          `json:"node_id"`
+	ParentPubB64 string          `json:"parent_pub_b64"`
+	Attestation  json.RawMessage `json:"attestation"`
+	SigB64       string          `json:"sig_b64"`
+}
+
+// signEnvelope signs msg with this node's TPM child key.
+func signEnvelope(msg []byte) (signedEnvelope, error) {
+	sig, att, err := FakeTPM.Sign(NodeID, msg)
+	if err != nil {
+		return signedEnvelope{}, err
+	}
+	attJSON, err := json.Marshal(att)
+	if err != nil {
+		return signedEnvelope{}, err
+	}
+	return signedEnvelope{
+		NodeID:       NodeID,
+		ParentPubB64: FakeTPM.ParentPublicB64(),
+		Attestation:  attJSON,
+		SigB64:       base64.StdEncoding.EncodeToString(sig),
+	}, nil
+}
+
+// verifyEnvelope checks a sibling's signature over msg: env.NodeID must be
+// a configured member of our cluster signing under its pinned parent key.
+// Quarantined siblings are refused outright.
+func verifyEnvelope(ctx context.Context, env signedEnvelope, msg []byte) error {
+	if env.NodeID == "" {
+		return errors.New("missing node_id")
+	}
+	if isQuarantined(env.NodeID) {
+		return errNodeQuarantined
+	}
+	m, ok := Members.ClusterMember(ClusterID, env.NodeID)
+	if !ok {
+		return membership.ErrNotMember
+	}
+	return verifyMemberEnvelope(ctx, m, env.NodeID, env, msg)
+}
+
+// verifyGlobalEnvelope checks a global verifier's signature over msg;
+// env.NodeID is the verifier's own id. Its child key is pinned as
+// globalNodeID(id).
+func verifyGlobalEnvelope(ctx context.Context, env signedEnvelope, msg []byte) error {
+	m, ok := Members.GlobalMember(env.NodeID)
+	if !ok {
+		return membership.ErrNotMember
+	}
+	return verifyMemberEnvelope(ctx, m, globalNodeID(env.NodeID), env, msg)
+}
+
+// verifyMemberEnvelope checks env against member m's pinned parent key, the
+// parent -> child attestation chain and the child signature over msg, then
+// pins the child key to pinID.
+func verifyMemberEnvelope(ctx context.Context, m membership.Member, pinID string, env signedEnvelope, msg []byte) error {
+	if err := m.Check(env.ParentPubB64); err != nil {
+		return err
+	}
+	parentPub, err := base64.StdEncoding.DecodeString(env.ParentPubB64)
+	if err != nil {
+		return fmt.Errorf("bad parent_pub_b64: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(env.SigB64)
+	if err != nil {
+		return fmt.Errorf("bad sig_b64: %w", err)
+	}
+	var att tpm.Attestation
+	if err := json.Unmarshal(env.Attestation, &att); err != nil {
+		return fmt.Errorf("invalid attestation json: %w", err)
+	}
+	if err := tpm.VerifyChain(parentPub, msg, sig, att); err != nil {
+		return err
+	}
+	return pinNodeKey(ctx, pinID, att.ChildPubB64)
+}
+
+// pinNodeKey records the child public key for nodeID the first time it is
+// seen and refuses any later message signed by a different key.
+func pinNodeKey(ctx context.Context, nodeID, childPubB64 string) error {
+	var pinned string
+	err := DB.QueryRowContext(ctx, `
+		INSERT INTO nodes (node_id, tpm_pub, last_seen)
+		VALUES ($1,$2,NOW())
+		ON CONFLICT (node_id) DO UPDATE
+		  SET last_seen=NOW()
+		  WHERE nodes.tpm_pub = EXCLUDED.tpm_pub
+		RETURNING tpm_pub
+	`, nodeID, childPubB64).Scan(&pinned)
+	if err == sql.ErrNoRows {
+		return errNodeKeyMismatch
+	}
+	return err
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// txTransitions is the status state machine of a local DAG node, keyed by
+// the current status. received -> local_confirmed -> submitted_global ->
+// finalized is the happy path; finality may also land straight on a
+// local_confirmed tx, and a lost spend conflict rejects a tx at any live
+// status. finalized, rejected and quarantined are terminal.
+var txTransitions = map[string][]string{
+	"":                 {"received"},
+	"received":         {"local_confirmed", "quarantined", "rejected"},
+	"local_confirmed":  {"submitted_global", "quarantined", "finalized", "rejected"},
+	"submitted_global": {"finalized", "rejected"},
+}
+
+var errBadTransition = errors.New("transition not allowed")
+
+func transitionAllowed(from, to string) bool {
+	for _, s := range txTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// needsReason reports whether entering status must be explained.
+func needsReason(status string) bool { return status == "rejected" || status == "quarantined" }
+
+// transitionMessage is what a node signs when it moves txHash between
+// statuses. from is empty for the initial "received".
+func transitionMessage(txHash, from, to, reason string, atUnixMs int64) []byte {
+	return []byte(fmt.Sprintf("transition|%s|%s|%s|%s|%d", txHash, from, to, reason, atUnixMs))
+}
+
+// recordTransition signs and stores one transition inside tx.
+func recordTransition(ctx context.Context, tx *sql.Tx, txHash, from, to, reason string) error {
+	at := time.Now().UnixMilli()
+	env, err := signEnvelope(transitionMessage(txHash, from, to, reason, at))
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO local_tx_transitions (tx_hash, from_status, to_status, reason, node_id, signature, created_at)
+		VALUES ($1, NULLIF($2,''), $3, NULLIF($4,''), $5, $6, $7)
+	`, txHash, from, to, reason, NodeID, env.SigB64, time.UnixMilli(at).UTC())
+	return err
+}
+
+// transitionTx moves txHash to status `to` if the current status is one of
+// from and the state machine allows it, recording the signed transition in
+// the same transaction. It reports false, without error, when the tx is
+// unknown or already elsewhere (e.g. a concurrent tally got there first).
+func transitionTx(ctx context.Context, txHash string, from []string, to, reason string) (bool, error) {
+	if needsReason(to) && reason == "" {
+		return false, fmt.Errorf("%w: %s requires a reason", errBadTransition, to)
+	}
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var cur string
+	err = tx.QueryRowContext(ctx, `
+		SELECT status FROM local_dag_nodes WHERE tx_hash=$1 AND status = ANY($2) FOR UPDATE
+	`, txHash, pq.Array(from)).Scan(&cur)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !transitionAllowed(cur, to) {
+		return false, fmt.Errorf("%w: %s -> %s", errBadTransition, cur, to)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE local_dag_nodes SET status=$2 WHERE tx_hash=$1`, txHash, to); err != nil {
+		return false, err
+	}
+	if err := recordTransition(ctx, tx, txHash, cur, to, reason); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	if reason != "" {
+		log.Printf("lifecycle: tx=%s %s -> %s reason=%s", txHash, cur, to, reason)
+	} else {
+		log.Printf("lifecycle: tx=%s %s -> %s", txHash, cur, to)
+	}
+	return true, nil
+}
+
+// === Handlers ===
+
+type txTransition struct {
+	From      *string `json:"from"`
+	To        string  `json:"to"`
+	Reason    *string `json:"reason,omitempty"`
+	NodeID    string  `json:"node_id"`
+	Signature string  `json:"signature"`
+	At        string  `json:"at"`
+	AtUnixMs  int64   `json:"at_unix_ms"` // the timestamp in the signed message
+}
+
+// HandlerGetTx returns a tx's current status and its signed transition
+// history, oldest first. reason is set when the tx ended rejected or
+// quarantined.
+func HandlerGetTx(c *gin.Context) {
+	ctx := c.Request.Context()
+	txHash := c.Param("hash")
+
+	var it historyItem
+	var origin string
+	var ts time.Time
+	err := DB.QueryRowContext(ctx, `
+		SELECT l.id, d.tx_hash, l.created_at, l.from_public_id, l.to_public_id, l.amount, l.currency, l.scale,
+		       l.counter_amount, l.counter_currency, l.tx_type, d.status, d.node_id
+		FROM local_dag_nodes d
+		JOIN local_ledger l ON l.id = d.ledger_id
+		WHERE d.tx_hash=$1
+	`, txHash).Scan(&it.ID, &it.TxHash, &ts, &it.From, &it.To, &it.Amount, &it.Currency, &it.Scale,
+		&it.CounterAmount, &it.CounterCurrency, &it.TxType, &it.Finality, &origin)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tx_not_found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_lookup_tx", "details": err.Error()})
+		return
+	}
+	it.Ts = ts.UTC().Format(time.RFC3339Nano)
+	it.Status = dashboardStatus(it.Finality)
+
+	rows, err := DB.QueryContext(ctx, `
+		SELECT from_status, to_status, reason, node_id, signature, created_at
+		FROM local_tx_transitions
+		WHERE tx_hash=$1
+		ORDER BY created_at, id
+	`, txHash)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_list_transitions", "details": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	transitions := []txTransition{}
+	var reason *string
+	for rows.Next() {
+		var tr txTransition
+		var at time.Time
+		if err := rows.Scan(&tr.From, &tr.To, &tr.Reason, &tr.NodeID, &tr.Signature, &at); err != nil {
+			c.JSON(500, gin.H{"error": "db_scan_transition", "details": err.Error()})
+			return
+		}
+		tr.At = at.UTC().Format(time.RFC3339Nano)
+		tr.AtUnixMs = at.UnixMilli()
+		if needsReason(tr.To) {
+			reason = tr.Reason
+		}
+		transitions = append(transitions, tr)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(500, gin.H{"error": "db_list_transitions", "details": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"ok":          true,
+		"tx":          it,
+		"status":      it.Finality,
+		"origin_node": origin,
+		"reason":      reason,
+		"transitions": transitions,
+	})
+}
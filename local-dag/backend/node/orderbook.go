@@ -0,0 +1,889 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errUnknownAsset      = errors.New("unknown asset")
+	errInsufficientAsset = errors.New("insufficient asset position")
+	errInsufficientFunds = errors.New("insufficient cash for buy order")
+	errOrderNotFound     = errors.New("order not found")
+	errOrderReplayed     = errors.New("order nonce already used")
+)
+
+// bookOrder is a resting or incoming order as the engine sees it.
+type bookOrder struct {
+	ID        string `json:"id"`
+	Seq       int64  `json:"-"`
+	AccountID string `json:"account_id"`
+	Symbol    string `json:"symbol"`
+	Side      string `json:"side"`       // buy | sell
+	OrderType string `json:"order_type"` // limit | market
+	Price     int64  `json:"price,omitempty"`
+	Quantity  int64  `json:"quantity"`
+	Remaining int64  `json:"remaining"`
+	Status    string `json:"status"`
+	Nonce     int64  `json:"nonce,omitempty"`
+	UserPub   string `json:"-"`
+	UserSig   string `json:"-"`
+}
+
+// orderSignMessage is the exact message an account signs to place o.
+func orderSignMessage(o *bookOrder) []byte {
+	body, _ := json.Marshal(struct {
+		AccountID string `json:"account_id"`
+		Symbol    string `json:"symbol"`
+		Side      string `json:"side"`
+		OrderType string `json:"order_type"`
+		Price     int64  `json:"price"`
+		Quantity  int64  `json:"quantity"`
+		Nonce     int64  `json:"nonce"`
+	}{o.AccountID, o.Symbol, o.Side, o.OrderType, o.Price, o.Quantity, o.Nonce})
+	return append([]byte("strix-order:"), body...)
+}
+
+// signedOrder is an order as its account signed it. Both legs of a fill
+// carry the two orders so siblings can check the accounts agreed to it.
+type signedOrder struct {
+	Message string `json:"message"` // orderSignMessage, verbatim
+	UserSig string `json:"user_sig,omitempty"`
+}
+
+func (o *bookOrder) signed() signedOrder {
+	return signedOrder{Message: string(orderSignMessage(o)), UserSig: o.UserSig}
+}
+
+// parseSignedOrder recovers the order behind s, which must be in the
+// canonical form orderSignMessage produces.
+func parseSignedOrder(s signedOrder) (*bookOrder, error) {
+	body, ok := strings.CutPrefix(s.Message, "strix-order:")
+	if !ok {
+		return nil, errors.New("not an order message")
+	}
+	var o bookOrder
+	if err := json.Unmarshal([]byte(body), &o); err != nil {
+		return nil, err
+	}
+	if string(orderSignMessage(&o)) != s.Message {
+		return nil, errors.New("order message not canonical")
+	}
+	o.UserSig = s.UserSig
+	return &o, nil
+}
+
+// cancelSignMessage is the exact message an account signs to cancel an order.
+func cancelSignMessage(accountID, orderID string) []byte {
+	body, _ := json.Marshal(struct {
+		AccountID string `json:"account_id"`
+		OrderID   string `json:"order_id"`
+	}{accountID, orderID})
+	return append([]byte("strix-cancel:"), body...)
+}
+
+// tradePayload is the payload both legs of a fill carry.
+type tradePayload struct {
+	Symbol      string      `json:"symbol"`
+	Price       int64       `json:"price"`
+	Quantity    int64       `json:"quantity"`
+	BuyOrderID  string      `json:"buy_order_id"`
+	SellOrderID string      `json:"sell_order_id"`
+	BuyOrder    signedOrder `json:"buy_order"`
+	SellOrder   signedOrder `json:"sell_order"`
+}
+
+// fill is one match between an incoming order and a resting one, priced at
+// the resting order's limit.
+type fill struct {
+	Price       int64  `json:"price"`
+	Quantity    int64  `json:"quantity"`
+	RestingID   string `json:"resting_order_id"`
+	CashTxHash  string `json:"cash_tx_hash"`
+	AssetTxHash string `json:"asset_tx_hash"`
+}
+
+// orderBook keeps bids best (highest) first and asks best (lowest) first;
+// equal prices keep arrival order.
+type orderBook struct {
+	bids []*bookOrder
+	asks []*bookOrder
+}
+
+func (b *orderBook) side(side string) *[]*bookOrder {
+	if side == "buy" {
+		return &b.bids
+	}
+	return &b.asks
+}
+
+// better reports whether a has priority over o on the same side.
+func better(a, o *bookOrder) bool {
+	if a.Price != o.Price {
+		if a.Side == "buy" {
+			return a.Price > o.Price
+		}
+		return a.Price < o.Price
+	}
+	return a.Seq < o.Seq
+}
+
+func (b *orderBook) rest(o *bookOrder) {
+	s := b.side(o.Side)
+	i := sort.Search(len(*s), func(i int) bool { return better(o, (*s)[i]) })
+	*s = append(*s, nil)
+	copy((*s)[i+1:], (*s)[i:])
+	(*s)[i] = o
+}
+
+func (b *orderBook) remove(id string) *bookOrder {
+	for _, s := range []*[]*bookOrder{&b.bids, &b.asks} {
+		for i, o := range *s {
+			if o.ID == id {
+				*s = append((*s)[:i], (*s)[i+1:]...)
+				return o
+			}
+		}
+	}
+	return nil
+}
+
+// crosses reports whether incoming can trade against resting.
+func crosses(incoming, resting *bookOrder) bool {
+	if incoming.OrderType == "market" {
+		return true
+	}
+	if incoming.Side == "buy" {
+		return resting.Price <= incoming.Price
+	}
+	return resting.Price >= incoming.Price
+}
+
+// matchingEngine is this node's price-time priority engine. One mutex
+// serialises all order entry so book, orders table and ledger stay in step.
+type matchingEngine struct {
+	mu    sync.Mutex
+	books map[string]*orderBook
+}
+
+var Engine = &matchingEngine{books: map[string]*orderBook{}}
+
+func (e *matchingEngine) book(symbol string) *orderBook {
+	b := e.books[symbol]
+	if b == nil {
+		b = &orderBook{}
+		e.books[symbol] = b
+	}
+	return b
+}
+
+// load rebuilds the books from resting orders after a restart.
+func (e *matchingEngine) load(ctx context.Context) error {
+	rows, err := DB.QueryContext(ctx, `
+		SELECT id, seq, account_id, symbol, side, order_type, price, quantity, remaining, status,
+		       nonce, COALESCE(user_pub,''), COALESCE(user_sig,'')
+		FROM local_orders
+		WHERE status IN ('open','partially_filled') AND order_type='limit'
+		ORDER BY seq
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for rows.Next() {
+		var o bookOrder
+		var price sql.NullInt64
+		if err := rows.Scan(&o.ID, &o.Seq, &o.AccountID, &o.Symbol, &o.Side, &o.OrderType, &price, &o.Quantity, &o.Remaining, &o.Status,
+			&o.Nonce, &o.UserPub, &o.UserSig); err != nil {
+			return err
+		}
+		o.Price = price.Int64
+		e.book(o.Symbol).rest(&o)
+	}
+	return rows.Err()
+}
+
+// place records o, matches it against the opposite side and rests any
+// limit remainder. Market remainders are cancelled. The order, every fill and
+// the cancel share one DB transaction; the book and the orders in it are only
+// touched once that commits, so a failed fill leaves both as they were. The
+// ledger legs of every fill are returned for gossip, cash leg first.
+func (e *matchingEngine) place(ctx context.Context, o *bookOrder) ([]fill, []gossipMessage, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var quote cashCurrency
+	err := DB.QueryRowContext(ctx, `
+		SELECT a.quote_currency, c.scale
+		FROM local_assets a JOIN local_currencies c ON c.code = a.quote_currency
+		WHERE a.symbol=$1
+	`, o.Symbol).Scan(&quote.Code, &quote.Scale)
+	if err == sql.ErrNoRows {
+		return nil, nil, errUnknownAsset
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// budget caps what a buy may spend; limit buys must be covered in full
+	// up front, market buys fill only as far as the cash goes.
+	var budget int64
+	if o.Side == "sell" {
+		var available int64
+		err := DB.QueryRowContext(ctx, `
+			SELECT COALESCE((SELECT quantity FROM local_asset_positions WHERE account_id=$1 AND symbol=$2), 0)
+			     - COALESCE((SELECT SUM(remaining) FROM local_orders
+			                 WHERE account_id=$1 AND symbol=$2 AND side='sell' AND status IN ('open','partially_filled')), 0)
+		`, o.AccountID, o.Symbol).Scan(&available)
+		if err != nil {
+			return nil, nil, err
+		}
+		if available < o.Quantity {
+			return nil, nil, errInsufficientAsset
+		}
+	} else {
+		if budget, err = availableCash(ctx, o.AccountID, quote.Code); err != nil {
+			return nil, nil, err
+		}
+		if o.OrderType == "limit" && budget < o.Price*o.Quantity {
+			return nil, nil, errInsufficientFunds
+		}
+	}
+
+	issueHash, err := assetIssueTx(ctx, o.Symbol)
+	if err != nil {
+		return nil, nil, err
+	}
+	parents, err := selectParents(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	if o.UserSig != "" {
+		var used bool
+		if err := tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM local_orders WHERE account_id=$1 AND nonce=$2 AND user_sig IS NOT NULL)
+		`, o.AccountID, o.Nonce).Scan(&used); err != nil {
+			return nil, nil, err
+		}
+		if used {
+			return nil, nil, errOrderReplayed
+		}
+	}
+
+	var price sql.NullInt64
+	if o.OrderType == "limit" {
+		price = sql.NullInt64{Int64: o.Price, Valid: true}
+	}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO local_orders (account_id, symbol, side, order_type, price, quantity, remaining, nonce, user_pub, user_sig)
+		VALUES ($1,$2,$3,$4,$5,$6,$6,$7,NULLIF($8,''),NULLIF($9,''))
+		RETURNING id, seq
+	`, o.AccountID, o.Symbol, o.Side, o.OrderType, price, o.Quantity, o.Nonce, o.UserPub, o.UserSig).Scan(&o.ID, &o.Seq)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	book := e.book(o.Symbol)
+	opposite := book.side("sell")
+	if o.Side == "sell" {
+		opposite = book.side("buy")
+	}
+
+	type match struct {
+		resting     *bookOrder
+		qty         int64
+		cashParents []string
+	}
+	var (
+		matches   []match
+		fills     []fill
+		legs      []gossipMessage
+		remaining = o.Quantity
+	)
+	for _, resting := range *opposite {
+		if remaining == 0 || !crosses(o, resting) {
+			break
+		}
+		if resting.AccountID == o.AccountID {
+			continue // never trade with yourself
+		}
+		qty := min(remaining, resting.Remaining)
+		if o.Side == "buy" && o.OrderType == "market" {
+			qty = min(qty, budget/resting.Price)
+			if qty == 0 {
+				break
+			}
+			budget -= qty * resting.Price
+		}
+		f, msgs, err := commitFill(ctx, tx, o, resting, resting.Price, qty, quote, parents, issueHash)
+		if err != nil {
+			return nil, nil, err
+		}
+		matches = append(matches, match{resting, qty, parents})
+		fills = append(fills, f)
+		legs = append(legs, msgs...)
+		parents = []string{f.AssetTxHash}
+		remaining -= qty
+	}
+
+	if remaining > 0 && o.OrderType == "market" {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE local_orders SET status='cancelled', updated_at=NOW() WHERE id=$1
+		`, o.ID); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	for i, m := range matches {
+		DAG.Add(fills[i].CashTxHash, m.cashParents)
+		DAG.Add(fills[i].AssetTxHash, assetLegParents(fills[i].CashTxHash, issueHash))
+		m.resting.Remaining -= m.qty
+		m.resting.Status = "partially_filled"
+		if m.resting.Remaining == 0 {
+			m.resting.Status = "filled"
+			book.remove(m.resting.ID)
+		}
+	}
+	o.Remaining, o.Status = remaining, "open"
+	switch {
+	case remaining == 0:
+		o.Status = "filled"
+	case o.OrderType == "market":
+		o.Status = "cancelled"
+	default:
+		if remaining < o.Quantity {
+			o.Status = "partially_filled"
+		}
+		book.rest(o)
+	}
+	return fills, legs, nil
+}
+
+// availableCash is accountID's balance in currency less what its resting
+// limit buys quoted in that currency have reserved.
+func availableCash(ctx context.Context, accountID, currency string) (int64, error) {
+	var available int64
+	err := DB.QueryRowContext(ctx, `
+		SELECT COALESCE((SELECT balance FROM local_balances WHERE account_id=$1 AND currency=$2), 0)
+		     - COALESCE((SELECT SUM(o.remaining * o.price)::bigint
+		                 FROM local_orders o JOIN local_assets a ON a.symbol = o.symbol
+		                 WHERE o.account_id=$1 AND a.quote_currency=$2 AND o.side='buy' AND o.order_type='limit'
+		                   AND o.status IN ('open','partially_filled')), 0)
+	`, accountID, currency).Scan(&available)
+	return available, err
+}
+
+// cancel pulls a resting order owned by accountID off the book.
+func (e *matchingEngine) cancel(ctx context.Context, id, accountID string) (*bookOrder, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	res, err := DB.ExecContext(ctx, `
+		UPDATE local_orders SET status='cancelled', updated_at=NOW()
+		WHERE id=$1 AND account_id=$2 AND status IN ('open','partially_filled')
+	`, id, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, errOrderNotFound
+	}
+	for _, b := range e.books {
+		if o := b.remove(id); o != nil {
+			o.Status = "cancelled"
+			return o, nil
+		}
+	}
+	return &bookOrder{ID: id, Status: "cancelled"}, nil
+}
+
+// marketCost is what buying qty of symbol at market would cost against the
+// resting asks of accounts other than accountID, as far as they go.
+func (e *matchingEngine) marketCost(symbol, accountID string, qty int64) int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	b := e.books[symbol]
+	if b == nil {
+		return 0
+	}
+	var cost int64
+	for _, o := range b.asks {
+		if qty == 0 {
+			break
+		}
+		if o.AccountID == accountID {
+			continue
+		}
+		n := min(qty, o.Remaining)
+		if n > (math.MaxInt64-cost)/o.Price {
+			return math.MaxInt64
+		}
+		cost += n * o.Price
+		qty -= n
+	}
+	return cost
+}
+
+// depth aggregates resting quantity per price level, best first.
+func (e *matchingEngine) depth(symbol string, levels int) (bids, asks [][2]int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	agg := func(s []*bookOrder) [][2]int64 {
+		out := [][2]int64{}
+		for _, o := range s {
+			if n := len(out); n > 0 && out[n-1][0] == o.Price {
+				out[n-1][1] += o.Remaining
+				continue
+			}
+			if len(out) == levels {
+				break
+			}
+			out = append(out, [2]int64{o.Price, o.Remaining})
+		}
+		return out
+	}
+	b := e.books[symbol]
+	if b == nil {
+		return [][2]int64{}, [][2]int64{}
+	}
+	return agg(b.bids), agg(b.asks)
+}
+
+// cashCurrency is the currency an asset is quoted and settled in.
+type cashCurrency struct {
+	Code  string
+	Scale int
+}
+
+// commitFill writes one fill inside tx: the trade_cash leg (buyer ->
+// seller, in the asset's quote currency) on parents, the trade_asset leg
+// (seller -> buyer) on the cash leg and the asset's issue_asset tx, the trade
+// row, and both orders' remaining quantity. Both legs carry the two signed
+// orders (checkTradeLeg). Naming the issue tx makes siblings hold the asset
+// before they apply the leg. In-memory orders are left to the caller.
+func commitFill(ctx context.Context, tx *sql.Tx, incoming, resting *bookOrder, price, qty int64, quote cashCurrency, parents []string, issueHash string) (fill, []gossipMessage, error) {
+	if price > 0 && qty > math.MaxInt64/price {
+		return fill{}, nil, errors.New("trade value overflows")
+	}
+	buy, sell := incoming, resting
+	if incoming.Side == "sell" {
+		buy, sell = resting, incoming
+	}
+	payload, _ := json.Marshal(tradePayload{
+		Symbol:      incoming.Symbol,
+		Price:       price,
+		Quantity:    qty,
+		BuyOrderID:  buy.ID,
+		SellOrderID: sell.ID,
+		BuyOrder:    buy.signed(),
+		SellOrder:   sell.signed(),
+	})
+
+	now := time.Now().UnixMilli()
+	cash := localTx{
+		FromPublicID: buy.AccountID,
+		ToPublicID:   sell.AccountID,
+		Amount:       price * qty,
+		Currency:     quote.Code,
+		Scale:        quote.Scale,
+		TxType:       "trade_cash",
+		TsUnixMs:     now,
+		Payload:      payload,
+		Parents:      parents,
+	}
+	cashMsg, err := signLocalTx(cash)
+	if err != nil {
+		return fill{}, nil, err
+	}
+	leg := localTx{
+		FromPublicID: sell.AccountID,
+		ToPublicID:   buy.AccountID,
+		Amount:       qty,
+		Currency:     noCurrency,
+		TxType:       "trade_asset",
+		TsUnixMs:     now,
+		Payload:      payload,
+		Parents:      assetLegParents(cashMsg.TxHash, issueHash),
+	}
+	legMsg, err := signLocalTx(leg)
+	if err != nil {
+		return fill{}, nil, err
+	}
+
+	if _, err := insertLocalTx(ctx, tx, cash, cashMsg.TxBody, cashMsg.TxHash, NodeID, cashMsg.Origin.SigB64); err != nil {
+		return fill{}, nil, err
+	}
+	if _, err := insertLocalTx(ctx, tx, leg, legMsg.TxBody, legMsg.TxHash, NodeID, legMsg.Origin.SigB64); err != nil {
+		return fill{}, nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO local_trades (symbol, buy_order_id, sell_order_id, price, quantity, cash_tx_hash, asset_tx_hash)
+		VALUES ($1,$2,$3,$4,$5,$6,$7)
+	`, incoming.Symbol, buy.ID, sell.ID, price, qty, cashMsg.TxHash, legMsg.TxHash); err != nil {
+		return fill{}, nil, err
+	}
+	for _, o := range []*bookOrder{incoming, resting} {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE local_orders
+			SET remaining = remaining - $2,
+			    status = CASE WHEN remaining - $2 = 0 THEN 'filled' ELSE 'partially_filled' END,
+			    updated_at = NOW()
+			WHERE id=$1
+		`, o.ID, qty); err != nil {
+			return fill{}, nil, err
+		}
+	}
+	return fill{
+		Price:       price,
+		Quantity:    qty,
+		RestingID:   resting.ID,
+		CashTxHash:  cashMsg.TxHash,
+		AssetTxHash: legMsg.TxHash,
+	}, []gossipMessage{cashMsg, legMsg}, nil
+}
+
+// assetLegParents are a trade_asset leg's parents: its cash leg and, unless
+// it has been pruned, the asset's issue_asset tx.
+func assetLegParents(cashHash, issueHash string) []string {
+	if issueHash == "" {
+		return []string{cashHash}
+	}
+	return []string{cashHash, issueHash}
+}
+
+// checkTradeLeg lets siblings check a gossiped trade_cash or trade_asset
+// leg against the two signed orders in its payload: each account's
+// signature, a buy and a sell of the leg's asset by different accounts, a
+// price within both limits, legs that move exactly price x quantity of cash
+// from buyer to seller and quantity units back, and, on the cash leg (one
+// per fill), no more filled in total than either order's quantity. Empty
+// means it matches.
+func checkTradeLeg(ctx context.Context, t localTx, txHash string) string {
+	if t.TxType != "trade_cash" && t.TxType != "trade_asset" {
+		return ""
+	}
+	var p tradePayload
+	if err := json.Unmarshal(t.Payload, &p); err != nil || p.Price <= 0 || p.Quantity <= 0 {
+		return "trade_payload_invalid"
+	}
+	buy, err := parseSignedOrder(p.BuyOrder)
+	if err != nil {
+		return "trade_order_invalid"
+	}
+	sell, err := parseSignedOrder(p.SellOrder)
+	if err != nil {
+		return "trade_order_invalid"
+	}
+	if buy.Side != "buy" || sell.Side != "sell" {
+		return "trade_side_mismatch"
+	}
+	if buy.Symbol != p.Symbol || sell.Symbol != p.Symbol || buy.AccountID == sell.AccountID {
+		return "trade_order_mismatch"
+	}
+	if (buy.OrderType == "limit" && p.Price > buy.Price) || (sell.OrderType == "limit" && p.Price < sell.Price) {
+		return "trade_price_outside_limit"
+	}
+	if p.Quantity > buy.Quantity || p.Quantity > sell.Quantity {
+		return "trade_quantity_exceeded"
+	}
+	if p.Quantity > math.MaxInt64/p.Price {
+		return "trade_payload_invalid"
+	}
+	switch t.TxType {
+	case "trade_cash":
+		if t.FromPublicID != buy.AccountID || t.ToPublicID != sell.AccountID || t.Amount != p.Price*p.Quantity {
+			return "trade_leg_mismatch"
+		}
+	case "trade_asset":
+		if t.FromPublicID != sell.AccountID || t.ToPublicID != buy.AccountID || t.Amount != p.Quantity {
+			return "trade_leg_mismatch"
+		}
+	}
+
+	for _, o := range []struct {
+		key   string
+		order *bookOrder
+	}{{"buy_order", buy}, {"sell_order", sell}} {
+		if o.order.UserSig == "" {
+			if UserSigRequired {
+				return "trade_order_unsigned"
+			}
+			continue
+		}
+		if _, err := verifyUserMessage(ctx, o.order.AccountID, orderSignMessage(o.order), o.order.UserSig); err != nil {
+			if err == errAuthUnavailable {
+				return "auth_unavailable"
+			}
+			return "trade_order_signature_invalid"
+		}
+		if t.TxType != "trade_cash" {
+			continue
+		}
+		var filled int64
+		if err := DB.QueryRowContext(ctx, `
+			SELECT COALESCE(SUM((l.payload->>'quantity')::bigint), 0)
+			FROM local_ledger l JOIN local_dag_nodes d ON d.ledger_id = l.id
+			WHERE l.tx_type='trade_cash' AND l.payload->$1->>'user_sig' = $2
+			  AND d.tx_hash <> $3 AND d.status NOT IN ('rejected','quarantined')
+		`, o.key, o.order.UserSig, txHash).Scan(&filled); err != nil {
+			return "trade_fills_unavailable"
+		}
+		if filled+p.Quantity > o.order.Quantity {
+			return "trade_quantity_exceeded"
+		}
+	}
+	return ""
+}
+
+// orderFraudTx is what the fraud rules see of an order: what its account
+// stands to send, to the book. A buy sends its limit value, or for a market
+// buy what the resting asks would cost, in the quote currency; a sell sends
+// asset units.
+func orderFraudTx(ctx context.Context, o *bookOrder) (localTx, error) {
+	var quote string
+	err := DB.QueryRowContext(ctx, `SELECT quote_currency FROM local_assets WHERE symbol=$1`, o.Symbol).Scan(&quote)
+	if err == sql.ErrNoRows {
+		return localTx{}, errUnknownAsset
+	}
+	if err != nil {
+		return localTx{}, err
+	}
+	payload, _ := json.Marshal(map[string]any{"symbol": o.Symbol, "side": o.Side, "order_type": o.OrderType})
+	t := localTx{
+		FromPublicID: o.AccountID,
+		ToPublicID:   "book:" + o.Symbol,
+		Amount:       o.Quantity,
+		Currency:     noCurrency,
+		TxType:       "trade_asset",
+		Nonce:        o.Nonce,
+		TsUnixMs:     time.Now().UnixMilli(),
+		Payload:      payload,
+	}
+	if o.Side == "buy" {
+		t.TxType, t.Currency = "trade_cash", quote
+		t.Amount = o.Price * o.Quantity
+		if o.OrderType == "market" {
+			t.Amount = Engine.marketCost(o.Symbol, o.AccountID, o.Quantity)
+		}
+	}
+	return t, nil
+}
+
+func scanOrder(rows *sql.Rows) (*bookOrder, error) {
+	var o bookOrder
+	var price sql.NullInt64
+	if err := rows.Scan(&o.ID, &o.Seq, &o.AccountID, &o.Symbol, &o.Side, &o.OrderType, &price, &o.Quantity, &o.Remaining, &o.Status); err != nil {
+		return nil, err
+	}
+	o.Price = price.Int64
+	return &o, nil
+}
+
+// announceLegs votes on and gossips fill legs in order, so siblings see
+// each cash leg before the asset leg that names it as parent.
+func announceLegs(legs []gossipMessage) {
+	for _, msg := range legs {
+		var t localTx
+		if err := json.Unmarshal(msg.TxBody, &t); err != nil {
+			continue
+		}
+		announceTx(msg, t.Parents)
+	}
+}
+
+// === Handlers ===
+
+// HandlerPlaceOrder accepts a limit or market order signed by its account
+// (see orderSignMessage), puts it through the fraud gate (orderFraudTx) and
+// matches it at once.
+func HandlerPlaceOrder(c *gin.Context) {
+	var req struct {
+		AccountID string `json:"account_id"`
+		Symbol    string `json:"symbol"`
+		Side      string `json:"side"`
+		OrderType string `json:"order_type"`
+		Price     int64  `json:"price"`
+		Quantity  int64  `json:"quantity"`
+		Nonce     int64  `json:"nonce"`
+		UserSig   string `json:"user_sig"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request", "details": err.Error()})
+		return
+	}
+	if req.OrderType == "" {
+		req.OrderType = "limit"
+	}
+	switch {
+	case req.AccountID == "" || req.Symbol == "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_order", "details": "account_id and symbol required"})
+		return
+	case req.Side != "buy" && req.Side != "sell":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_order", "details": "side must be buy or sell"})
+		return
+	case req.OrderType != "limit" && req.OrderType != "market":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_order", "details": "order_type must be limit or market"})
+		return
+	case req.Quantity <= 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_order", "details": "quantity must be positive"})
+		return
+	case req.OrderType == "limit" && req.Price <= 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_order", "details": "limit price must be positive"})
+		return
+	case req.OrderType == "limit" && req.Quantity > math.MaxInt64/req.Price:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_order", "details": "order value overflows"})
+		return
+	}
+	if req.OrderType == "market" {
+		req.Price = 0
+	}
+
+	o := &bookOrder{
+		AccountID: req.AccountID,
+		Symbol:    req.Symbol,
+		Side:      req.Side,
+		OrderType: req.OrderType,
+		Price:     req.Price,
+		Quantity:  req.Quantity,
+		Nonce:     req.Nonce,
+		UserSig:   req.UserSig,
+	}
+	pub, ok := checkUserRequest(c, o.AccountID, orderSignMessage(o), req.UserSig)
+	if !ok {
+		return
+	}
+	o.UserPub = pub
+	ctx := c.Request.Context()
+
+	ft, err := orderFraudTx(ctx, o)
+	if errors.Is(err, errUnknownAsset) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown_asset"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_lookup_asset", "details": err.Error()})
+		return
+	}
+	flags, ok := checkFraud(c, ft)
+	if !ok {
+		return
+	}
+
+	fills, legs, err := Engine.place(ctx, o)
+	if len(legs) > 0 {
+		go announceLegs(legs)
+	}
+	switch {
+	case errors.Is(err, errUnknownAsset):
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown_asset"})
+		return
+	case errors.Is(err, errInsufficientAsset):
+		c.JSON(http.StatusConflict, gin.H{"error": "insufficient_position"})
+		return
+	case errors.Is(err, errInsufficientFunds):
+		c.JSON(http.StatusConflict, gin.H{"error": "insufficient_funds"})
+		return
+	case errors.Is(err, errOrderReplayed):
+		c.JSON(http.StatusConflict, gin.H{"error": "nonce_used", "nonce": o.Nonce})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": "order_failed", "details": err.Error()})
+		return
+	}
+	// flags land on the legs the account sent, or on the order if none
+	for _, f := range fills {
+		sent := f.CashTxHash
+		if o.Side == "sell" {
+			sent = f.AssetTxHash
+		}
+		flagFraudHits(ctx, sent, ft, flags)
+	}
+	if len(fills) == 0 {
+		flagFraudHits(ctx, "order:"+o.ID, ft, flags)
+	}
+	if fills == nil {
+		fills = []fill{}
+	}
+	c.JSON(200, gin.H{"ok": true, "order": o, "fills": fills})
+}
+
+// HandlerCancelOrder cancels a resting order; accountId must own it and
+// userSig is its signature over cancelSignMessage.
+func HandlerCancelOrder(c *gin.Context) {
+	account := c.Query("accountId")
+	if _, ok := checkUserRequest(c, account, cancelSignMessage(account, c.Param("id")), c.Query("userSig")); !ok {
+		return
+	}
+	o, err := Engine.cancel(c.Request.Context(), c.Param("id"), account)
+	if errors.Is(err, errOrderNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "order_not_found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_cancel_order", "details": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true, "order": o})
+}
+
+// HandlerListOrders lists an account's orders, newest first.
+func HandlerListOrders(c *gin.Context) {
+	account := c.Query("accountId")
+	if account == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing_account"})
+		return
+	}
+	rows, err := DB.QueryContext(c.Request.Context(), `
+		SELECT id, seq, account_id, symbol, side, order_type, price, quantity, remaining, status
+		FROM local_orders
+		WHERE account_id=$1 AND ($2 = '' OR status=$2)
+		ORDER BY created_at DESC
+		LIMIT 200
+	`, account, c.Query("status"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_list_orders", "details": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	orders := []*bookOrder{}
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db_scan_order", "details": err.Error()})
+			return
+		}
+		orders = append(orders, o)
+	}
+	c.JSON(200, gin.H{"ok": true, "orders": orders})
+}
+
+// HandlerOrderBook returns aggregated depth for a symbol as [price, quantity]
+// levels.
+func HandlerOrderBook(c *gin.Context) {
+	bids, asks := Engine.depth(c.Param("symbol"), 20)
+	c.JSON(200, gin.H{"ok": true, "symbol": c.Param("symbol"), "bids": bids, "asks": asks})
+}
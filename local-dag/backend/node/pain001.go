@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBulkInstructions bounds one pain.001 file.
+const maxBulkInstructions = 5000
+
+// pain001Document is the part of pain.001 (any 001.001.x version; the
+// namespace is not checked) that a ledger of public IDs can act on.
+// Accounts are identified by Id/Othr/Id.
+type pain001Document struct {
+	GrpHdr struct {
+		MsgID   string `xml:"MsgId"`
+		NbOfTxs string `xml:"NbOfTxs"`
+		CtrlSum string `xml:"CtrlSum"`
+	} `xml:"CstmrCdtTrfInitn>GrpHdr"`
+	PmtInf []pain001PmtInf `xml:"CstmrCdtTrfInitn>PmtInf"`
+}
+
+type pain001PmtInf struct {
+	PmtInfID   string      `xml:"PmtInfId"`
+	DbtrAcctID string      `xml:"DbtrAcct>Id>Othr>Id"`
+	Tx         []pain001Tx `xml:"CdtTrfTxInf"`
+}
+
+type pain001Tx struct {
+	InstrID    string `xml:"PmtId>InstrId"`
+	EndToEndID string `xml:"PmtId>EndToEndId"`
+	Amt        struct {
+		Ccy   string `xml:"Ccy,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"Amt>InstdAmt"`
+	CdtrAcctID string `xml:"CdtrAcct>Id>Othr>Id"`
+	Ustrd      string `xml:"RmtInf>Ustrd"`
+}
+
+// bulkImportPayload is the payload of the bulk_import header. Field order is
+// fixed: the debtor signs it as part of the header's clientTx, so the
+// instruction digests (in file order) bound what children the batch can
+// have, and NbOfTxs and CtrlSum cap their count and total.
+type bulkImportPayload struct {
+	MsgID        string   `json:"msg_id"`
+	FileSha256   string   `json:"file_sha256"`
+	NbOfTxs      int      `json:"nb_of_txs"`
+	CtrlSum      string   `json:"ctrl_sum,omitempty"`
+	Instructions []string `json:"instructions"`
+}
+
+// bulkInstructionDigest is the hex sha256 of the JSON array [PmtInfId,
+// InstrId, EndToEndId, creditor, Ccy, amount, Ustrd], amount being the
+// decimal with no leading zeros and no trailing fractional zeros ("10.50"
+// is "10.5", "3.00" is "3").
+func bulkInstructionDigest(pmtInfID, instrID, endToEndID, creditor, ccy, amount, remittance string) string {
+	b, _ := json.Marshal([]string{pmtInfID, instrID, endToEndID, creditor, ccy, canonicalDecimal(amount), remittance})
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// canonicalDecimal trims a plain decimal to the form bulkInstructionDigest
+// uses.
+func canonicalDecimal(s string) string {
+	whole, frac, _ := strings.Cut(strings.TrimSpace(s), ".")
+	whole = strings.TrimLeft(whole, "0")
+	if whole == "" {
+		whole = "0"
+	}
+	if frac = strings.TrimRight(frac, "0"); frac != "" {
+		return whole + "." + frac
+	}
+	return whole
+}
+
+// bulkTransferPayload ties an instruction back to its file.
+type bulkTransferPayload struct {
+	Batch      string `json:"batch"` // tx_hash of the bulk_import header
+	MsgID      string `json:"msg_id"`
+	PmtInfID   string `json:"pmt_inf_id"`
+	InstrID    string `json:"instr_id,omitempty"`
+	EndToEndID string `json:"end_to_end_id"`
+	Remittance string `json:"remittance,omitempty"`
+}
+
+// bulkResult reports one instruction.
+type bulkResult struct {
+	PmtInfID   string `json:"pmt_inf_id"`
+	InstrID    string `json:"instr_id,omitempty"`
+	EndToEndID string `json:"end_to_end_id"`
+	Status     string `json:"status"` // accepted | rejected | flagged
+	TxHash     string `json:"tx_hash,omitempty"`
+	Error      string `json:"error,omitempty"`
+	RuleID     string `json:"rule_id,omitempty"`
+}
+
+// validateBulk checks the shape of bulk_import and bulk_transfer txs.
+func (t localTx) validateBulk() error {
+	switch t.TxType {
+	case "bulk_import":
+		var p bulkImportPayload
+		if t.Amount != 0 || t.FromPublicID != t.ToPublicID {
+			return errors.New("bulk_import moves nothing: amount 0, from = to")
+		}
+		if err := json.Unmarshal(t.Payload, &p); err != nil || p.MsgID == "" || len(p.FileSha256) != 64 {
+			return errors.New("payload.msg_id and payload.file_sha256 required")
+		}
+		if p.NbOfTxs <= 0 || len(p.Instructions) != p.NbOfTxs {
+			return errors.New("payload.instructions must list nb_of_txs digests")
+		}
+	case "bulk_transfer":
+		var p bulkTransferPayload
+		if t.Amount <= 0 {
+			return errors.New("bulk_transfer amount must be positive")
+		}
+		if err := json.Unmarshal(t.Payload, &p); err != nil || len(t.Parents) != 1 || p.Batch != t.Parents[0] {
+			return errors.New("bulk_transfer must have its bulk_import as sole parent")
+		}
+	}
+	return nil
+}
+
+// checkBulkParent makes sure a bulk_transfer's parent is a bulk_import by
+// the same debtor, that the debtor signed this exact instruction as part of
+// that header, and that the batch stays within its NbOfTxs and CtrlSum.
+func checkBulkParent(ctx context.Context, t localTx, txHash string) string {
+	if t.TxType != "bulk_transfer" {
+		return ""
+	}
+	var from, txType string
+	var raw []byte
+	err := DB.QueryRowContext(ctx, `
+		SELECT l.from_public_id, l.tx_type, l.payload
+		FROM local_dag_nodes d JOIN local_ledger l ON l.id = d.ledger_id
+		WHERE d.tx_hash=$1
+	`, t.Parents[0]).Scan(&from, &txType, &raw)
+	if err != nil || txType != "bulk_import" || from != t.FromPublicID {
+		return "bulk_parent_invalid"
+	}
+	var header bulkImportPayload
+	var p bulkTransferPayload
+	if json.Unmarshal(raw, &header) != nil || json.Unmarshal(t.Payload, &p) != nil {
+		return "bulk_parent_invalid"
+	}
+	digest := bulkInstructionDigest(p.PmtInfID, p.InstrID, p.EndToEndID, t.ToPublicID, t.Currency, formatMinor(t.Amount, t.Scale), p.Remittance)
+	signed := false
+	for _, d := range header.Instructions {
+		if d == digest {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		return "bulk_instruction_unsigned"
+	}
+
+	rows, err := DB.QueryContext(ctx, `
+		SELECT l.amount, l.scale, l.payload->>'end_to_end_id'
+		FROM local_dag_nodes d JOIN local_ledger l ON l.id = d.ledger_id
+		WHERE d.parents = ARRAY[$1]::text[] AND l.tx_type='bulk_transfer' AND d.tx_hash <> $2
+	`, t.Parents[0], txHash)
+	if err != nil {
+		return "bulk_parent_invalid"
+	}
+	defer rows.Close()
+	count := 1
+	sum := new(big.Rat).SetFrac(big.NewInt(t.Amount), pow10(t.Scale))
+	for rows.Next() {
+		var amount int64
+		var scale int
+		var e2e string
+		if err := rows.Scan(&amount, &scale, &e2e); err != nil {
+			return "bulk_parent_invalid"
+		}
+		if e2e == p.EndToEndID {
+			return "bulk_instruction_duplicate"
+		}
+		count++
+		sum.Add(sum, new(big.Rat).SetFrac(big.NewInt(amount), pow10(scale)))
+	}
+	if rows.Err() != nil {
+		return "bulk_parent_invalid"
+	}
+	if count > header.NbOfTxs {
+		return "bulk_count_exceeded"
+	}
+	if header.CtrlSum != "" {
+		ctrl, ok := new(big.Rat).SetString(header.CtrlSum)
+		if !ok || sum.Cmp(ctrl) > 0 {
+			return "bulk_ctrl_sum_exceeded"
+		}
+	}
+	return ""
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// parseMinor converts a decimal amount to minor units at scale, refusing
+// more fraction digits than the currency has.
+func parseMinor(s string, scale int) (int64, error) {
+	s = strings.TrimSpace(s)
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" || len(frac) > scale || strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		return 0, fmt.Errorf("bad amount %q", s)
+	}
+	frac += strings.Repeat("0", scale-len(frac))
+	v, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("bad amount %q", s)
+	}
+	return v, nil
+}
+
+// === Handler ===
+
+// HandlerImportPain001 books a pain.001 credit-transfer file. The file is
+// recorded as one bulk_import DAG node signed by the debtor; every valid
+// instruction becomes a bulk_transfer with that node as its only parent.
+//
+// Body: {from_public_id, nonce, user_sig, document}. user_sig signs the
+// header's clientTx: from = to = from_public_id, amount 0, currency of the
+// first instruction, tx_type bulk_import, payload bulkImportPayload with one
+// bulkInstructionDigest per CdtTrfTxInf in file order. Siblings only accept
+// a bulk_transfer whose digest the debtor signed there.
+// File-level problems (parse errors, NbOfTxs/CtrlSum mismatch, a second
+// debtor) reject the whole file; anything else is reported per instruction.
+func HandlerImportPain001(c *gin.Context) {
+	var req struct {
+		FromPublicID string `json:"from_public_id"`
+		Nonce        int64  `json:"nonce"`
+		TsUnixMs     int64  `json:"ts_unix_ms"`
+		UserSig      string `json:"user_sig"`
+		Document     string `json:"document"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request", "details": err.Error()})
+		return
+	}
+	var doc pain001Document
+	if err := xml.Unmarshal([]byte(req.Document), &doc); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_pain001", "details": err.Error()})
+		return
+	}
+
+	// === file-level checks ===
+	count := 0
+	ctrl := new(big.Rat)
+	firstCcy := ""
+	e2e := map[string]bool{}
+	var digests []string
+	for _, pi := range doc.PmtInf {
+		if pi.DbtrAcctID != req.FromPublicID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "debtor_mismatch", "pmt_inf_id": pi.PmtInfID, "debtor": pi.DbtrAcctID})
+			return
+		}
+		for _, tx := range pi.Tx {
+			count++
+			if tx.EndToEndID != "" && e2e[tx.EndToEndID] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "duplicate_end_to_end_id", "end_to_end_id": tx.EndToEndID})
+				return
+			}
+			e2e[tx.EndToEndID] = true
+			digests = append(digests, bulkInstructionDigest(pi.PmtInfID, tx.InstrID, tx.EndToEndID, tx.CdtrAcctID, tx.Amt.Ccy, tx.Amt.Value, tx.Ustrd))
+			if firstCcy == "" {
+				firstCcy = tx.Amt.Ccy
+			}
+			if v, ok := new(big.Rat).SetString(strings.TrimSpace(tx.Amt.Value)); ok {
+				ctrl.Add(ctrl, v)
+			}
+		}
+	}
+	if doc.GrpHdr.MsgID == "" || count == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_pain001", "details": "MsgId and at least one CdtTrfTxInf required"})
+		return
+	}
+	if count > maxBulkInstructions {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "too_many_instructions", "max": maxBulkInstructions})
+		return
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(doc.GrpHdr.NbOfTxs)); err != nil || n != count {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "nb_of_txs_mismatch", "declared": doc.GrpHdr.NbOfTxs, "found": count})
+		return
+	}
+	if doc.GrpHdr.CtrlSum != "" {
+		declared, ok := new(big.Rat).SetString(strings.TrimSpace(doc.GrpHdr.CtrlSum))
+		if !ok || declared.Cmp(ctrl) != 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ctrl_sum_mismatch", "declared": doc.GrpHdr.CtrlSum, "found": ctrl.FloatString(8)})
+			return
+		}
+	}
+	ctx := c.Request.Context()
+
+	headerScale, err := currencyScale(ctx, firstCcy)
+	if err != nil || firstCcy == noCurrency {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown_currency", "currency": firstCcy})
+		return
+	}
+
+	// === header ===
+	fileSum := sha256.Sum256([]byte(req.Document))
+	payload, _ := json.Marshal(bulkImportPayload{
+		MsgID:        doc.GrpHdr.MsgID,
+		FileSha256:   hex.EncodeToString(fileSum[:]),
+		NbOfTxs:      count,
+		CtrlSum:      strings.TrimSpace(doc.GrpHdr.CtrlSum),
+		Instructions: digests,
+	})
+	userPub, ok := checkUserSig(c, clientTx{
+		FromPublicID: req.FromPublicID,
+		ToPublicID:   req.FromPublicID,
+		Currency:     firstCcy,
+		TxType:       "bulk_import",
+		Nonce:        req.Nonce,
+		TsUnixMs:     req.TsUnixMs,
+		Payload:      payload,
+	}, req.UserSig)
+	if !ok {
+		return
+	}
+	parents, err := selectParents(ctx)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_select_parents", "details": err.Error()})
+		return
+	}
+	header := localTx{
+		FromPublicID: req.FromPublicID,
+		ToPublicID:   req.FromPublicID,
+		Currency:     firstCcy,
+		Scale:        headerScale,
+		TxType:       "bulk_import",
+		Nonce:        req.Nonce,
+		TsUnixMs:     time.Now().UnixMilli(),
+		Payload:      payload,
+		Parents:      parents,
+		UserPub:      userPub,
+	}
+	if userPub != "" {
+		header.UserSig, header.UserTsUnixMs = req.UserSig, req.TsUnixMs
+	}
+	if err := header.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_tx", "details": err.Error()})
+		return
+	}
+	if !checkNonceUnused(c, header) {
+		return
+	}
+	headerMsg, err := commitLocalTx(ctx, header)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_insert_tx", "details": err.Error()})
+		return
+	}
+	go announceTx(headerMsg, parents)
+
+	// === instructions ===
+	results := make([]bulkResult, 0, count)
+	accepted := 0
+	for _, pi := range doc.PmtInf {
+		for _, in := range pi.Tx {
+			r := bulkResult{PmtInfID: pi.PmtInfID, InstrID: in.InstrID, EndToEndID: in.EndToEndID}
+			t, err := bulkInstruction(ctx, req.FromPublicID, headerMsg.TxHash, doc.GrpHdr.MsgID, pi.PmtInfID, in)
+			if err != nil {
+				r.Status, r.Error = "rejected", err.Error()
+				results = append(results, r)
+				continue
+			}
+			hits, err := evaluateFraudRules(ctx, t)
+			if err != nil {
+				r.Status, r.Error = "rejected", "fraud_rules_unavailable"
+				results = append(results, r)
+				continue
+			}
+			if blocked := blockingHit(hits); blocked != nil {
+				recordFraudBlock(ctx, t, *blocked)
+				r.Status, r.Error, r.RuleID = "rejected", "fraud_rule_blocked", blocked.RuleID
+				results = append(results, r)
+				continue
+			}
+			msg, err := commitLocalTx(ctx, t)
+			if err != nil {
+				r.Status, r.Error = "rejected", "db_insert_tx"
+				results = append(results, r)
+				continue
+			}
+			flagFraudHits(ctx, msg.TxHash, t, hits)
+			r.Status, r.TxHash = "accepted", msg.TxHash
+			if len(hits) > 0 {
+				r.Status, r.RuleID = "flagged", hits[0].RuleID
+			}
+			accepted++
+			results = append(results, r)
+			go announceTx(msg, t.Parents)
+		}
+	}
+
+	c.JSON(200, gin.H{
+		"ok":           true,
+		"batch":        headerMsg.TxHash,
+		"msg_id":       doc.GrpHdr.MsgID,
+		"instructions": count,
+		"accepted":     accepted,
+		"rejected":     count - accepted,
+		"results":      results,
+	})
+}
+
+// bulkInstruction builds and validates the bulk_transfer for one
+// CdtTrfTxInf.
+func bulkInstruction(ctx context.Context, debtor, batch, msgID, pmtInfID string, in pain001Tx) (localTx, error) {
+	ccy := in.Amt.Ccy
+	if in.EndToEndID == "" {
+		return localTx{}, errors.New("missing EndToEndId")
+	}
+	if in.CdtrAcctID == "" {
+		return localTx{}, errors.New("missing CdtrAcct/Id/Othr/Id")
+	}
+	if ccy == noCurrency {
+		return localTx{}, errors.New("unknown currency XXX")
+	}
+	scale, err := currencyScale(ctx, ccy)
+	if err == errUnknownCurrency {
+		return localTx{}, fmt.Errorf("unknown currency %q", ccy)
+	}
+	if err != nil {
+		return localTx{}, err
+	}
+	minor, err := parseMinor(in.Amt.Value, scale)
+	if err != nil {
+		return localTx{}, err
+	}
+	payload, _ := json.Marshal(bulkTransferPayload{
+		Batch:      batch,
+		MsgID:      msgID,
+		PmtInfID:   pmtInfID,
+		InstrID:    in.InstrID,
+		EndToEndID: in.EndToEndID,
+		Remittance: in.Ustrd,
+	})
+	t := localTx{
+		FromPublicID: debtor,
+		ToPublicID:   in.CdtrAcctID,
+		Amount:       minor,
+		Currency:     ccy,
+		Scale:        scale,
+		TxType:       "bulk_transfer",
+		TsUnixMs:     time.Now().UnixMilli(),
+		Payload:      payload,
+		Parents:      []string{batch},
+	}
+	return t, t.validate()
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The global layer quarantines misbehaving local nodes and pushes the
+// decision here as a notice backed by GlobalQuorum signed votes. While a
+// sibling is quarantined, verifyEnvelope refuses everything it signs:
+// gossip, votes and checkpoint signatures.
+
+var errNodeQuarantined = errors.New("node is quarantined")
+
+// quarantineVote and quarantineNotice mirror the global node's.
+type quarantineVote struct {
+	NodeID string         `json:"node_id"` // cluster_id/node_id
+	Action string         `json:"action"`  // quarantine | clear
+	Epoch  int64          `json:"epoch"`
+	Reason string         `json:"reason,omitempty"`
+	Voter  signedEnvelope `json:"voter"`
+}
+
+type quarantineNotice struct {
+	NodeID  string           `json:"node_id"`
+	DagType string           `json:"dag_type"`
+	Action  string           `json:"action"`
+	Epoch   int64            `json:"epoch"`
+	Reason  string           `json:"reason,omitempty"`
+	Votes   []quarantineVote `json:"votes"`
+}
+
+func quarantineMessage(nodeID, action string, epoch int64) []byte {
+	return []byte(fmt.Sprintf("quarantine|%s|%s|%d", nodeID, action, epoch))
+}
+
+// quarantined holds sibling node IDs (without the cluster prefix) currently
+// under quarantine, so the envelope check stays off the database.
+var (
+	quarantineMu sync.RWMutex
+	quarantined  = map[string]bool{}
+)
+
+func isQuarantined(nodeID string) bool {
+	quarantineMu.RLock()
+	defer quarantineMu.RUnlock()
+	return quarantined[nodeID]
+}
+
+// siblingID maps a global cluster_id/node_id onto our sibling ID space; it
+// reports false for nodes of other clusters.
+func siblingID(globalID string) (string, bool) {
+	return strings.CutPrefix(globalID, ClusterID+"/")
+}
+
+// loadQuarantine fills the in-memory set at startup.
+func loadQuarantine(ctx context.Context) error {
+	rows, err := DB.QueryContext(ctx, `SELECT node_id FROM local_node_quarantine WHERE status='quarantined'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	quarantineMu.Lock()
+	defer quarantineMu.Unlock()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		if sib, ok := siblingID(id); ok {
+			quarantined[sib] = true
+		}
+	}
+	return rows.Err()
+}
+
+// verifyQuarantineNotice requires GlobalQuorum distinct global verifiers to
+// have signed the notice's decision.
+func verifyQuarantineNotice(ctx context.Context, n quarantineNotice) error {
+	if n.Action != "quarantine" && n.Action != "clear" {
+		return fmt.Errorf("unknown action %q", n.Action)
+	}
+	seen := map[string]bool{}
+	for _, v := range n.Votes {
+		if v.NodeID != n.NodeID || v.Action != n.Action || v.Epoch != n.Epoch || seen[v.Voter.NodeID] {
+			continue
+		}
+		if err := verifyGlobalEnvelope(ctx, v.Voter, quarantineMessage(v.NodeID, v.Action, v.Epoch)); err != nil {
+			continue
+		}
+		seen[v.Voter.NodeID] = true
+	}
+	if len(seen) < GlobalQuorum {
+		return fmt.Errorf("insufficient_votes: have %d need %d", len(seen), GlobalQuorum)
+	}
+	return nil
+}
+
+// === Handlers ===
+
+// HandlerPeerQuarantine applies a quarantine or clear notice from the global
+// layer. Notices for an epoch we already passed are acknowledged and ignored.
+func HandlerPeerQuarantine(c *gin.Context) {
+	var n quarantineNotice
+	if err := c.BindJSON(&n); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request", "details": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	if err := verifyQuarantineNotice(ctx, n); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "quarantine_verification_failed", "reason": err.Error()})
+		return
+	}
+	status := "quarantined"
+	if n.Action == "clear" {
+		status = "clear"
+	}
+	res, err := DB.ExecContext(ctx, `
+		INSERT INTO local_node_quarantine (node_id, status, epoch, reason, updated_at)
+		VALUES ($1,$2,$3,$4,NOW())
+		ON CONFLICT (node_id) DO UPDATE
+		  SET status=EXCLUDED.status, epoch=EXCLUDED.epoch, reason=EXCLUDED.reason, updated_at=NOW()
+		  WHERE local_node_quarantine.epoch < EXCLUDED.epoch
+	`, n.NodeID, status, n.Epoch, n.Reason)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_store_quarantine", "details": err.Error()})
+		return
+	}
+	if applied, _ := res.RowsAffected(); applied > 0 {
+		if sib, ok := siblingID(n.NodeID); ok {
+			quarantineMu.Lock()
+			if status == "quarantined" {
+				quarantined[sib] = true
+			} else {
+				delete(quarantined, sib)
+			}
+			quarantineMu.Unlock()
+		}
+		log.Printf("quarantine: node=%s status=%s epoch=%d", n.NodeID, status, n.Epoch)
+	}
+	c.JSON(200, gin.H{"ok": true})
+}
+
+// HandlerListQuarantine lists the quarantine notices this node has applied.
+func HandlerListQuarantine(c *gin.Context) {
+	rows, err := DB.QueryContext(c.Request.Context(), `
+		SELECT node_id, status, epoch, COALESCE(reason,''), updated_at
+		FROM local_node_quarantine ORDER BY updated_at DESC
+	`)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_list_quarantine", "details": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	type entry struct {
+		NodeID    string    `json:"node_id"`
+		Status    string    `json:"status"`
+		Epoch     int64     `json:"epoch"`
+		Reason    string    `json:"reason"`
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+	out := []entry{}
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.NodeID, &e.Status, &e.Epoch, &e.Reason, &e.UpdatedAt); err != nil {
+			c.JSON(500, gin.H{"error": "db_scan_quarantine", "details": err.Error()})
+			return
+		}
+		out = append(out, e)
+	}
+	c.JSON(200, gin.H{"ok": true, "nodes": out})
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Settlement forwarding to the global DAG.
+var (
+	ClusterID          string
+	GlobalPeersList    []string
+	SettlementInterval = 5 * time.Second
+	SettlementBatchMax = 100
+)
+
+// settlementItem is one locally confirmed tx plus the quorum proof for it.
+type settlementItem struct {
+	TxHash        string      `json:"tx_hash"`
+	TxBody        []byte      `json:"tx_body"`
+	NodeSignature string      `json:"node_signature"`
+	Votes         []localVote `json:"votes"`
+}
+
+// settlementBatch is signed as a whole by the originating node.
+type settlementBatch struct {
+	ClusterID       string           `json:"cluster_id"`
+	OriginatingNode string           `json:"originating_node"`
+	ClusterSize     int              `json:"cluster_size"`
+	Quorum          int              `json:"quorum"`
+	CreatedAtUnixMs int64            `json:"created_at_unix_ms"`
+	CallbackURL     string           `json:"callback_url,omitempty"`
+	Items           []settlementItem `json:"items"`
+}
+
+// settlementRequest is the body of POST /api/settlements on global nodes.
+type settlementRequest struct {
+	Batch     []byte         `json:"batch"`
+	BatchHash string         `json:"batch_hash"`
+	Origin    signedEnvelope `json:"origin"`
+}
+
+type settlementResult struct {
+	TxHash string `json:"tx_hash"`
+	Status string `json:"status"` // accepted | duplicate | rejected
+	Reason string `json:"reason,omitempty"`
+}
+
+func settlementSignMessage(batchHash string) []byte {
+	return []byte("settlement:" + batchHash)
+}
+
+// settlementLoop batches our locally confirmed txs and submits them to the
+// global layer every interval.
+func settlementLoop(interval time.Duration) {
+	if len(GlobalPeersList) == 0 {
+		log.Printf("settlement: GLOBAL_PEERS not set; forwarding disabled")
+		return
+	}
+	next := 0
+	for {
+		time.Sleep(interval)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		n, err := submitSettlementBatch(ctx, next)
+		cancel()
+		if err != nil {
+			log.Printf("settlement: submit failed: %v", err)
+		} else if n > 0 {
+			log.Printf("settlement: submitted=%d", n)
+		}
+		next++
+	}
+}
+
+// submitSettlementBatch sends one batch, failing over across global peers
+// starting at index start, and moves accepted txs to submitted_global.
+func submitSettlementBatch(ctx context.Context, start int) (int, error) {
+	items, err := loadSettlementItems(ctx, SettlementBatchMax)
+	if err != nil || len(items) == 0 {
+		return 0, err
+	}
+	batch := settlementBatch{
+		ClusterID:       ClusterID,
+		OriginatingNode: NodeID,
+		ClusterSize:     ClusterSize,
+		Quorum:          Quorum,
+		CreatedAtUnixMs: time.Now().UnixMilli(),
+		CallbackURL:     CallbackAddress,
+		Items:           items,
+	}
+	batchJSON, err := json.Marshal(batch)
+	if err != nil {
+		return 0, err
+	}
+	h := sha256.Sum256(batchJSON)
+	batchHash := hex.EncodeToString(h[:])
+	env, err := signEnvelope(settlementSignMessage(batchHash))
+	if err != nil {
+		return 0, err
+	}
+	body, _ := json.Marshal(settlementRequest{Batch: batchJSON, BatchHash: batchHash, Origin: env})
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	var lastErr error
+	for i := range GlobalPeersList {
+		peer := GlobalPeersList[(start+i)%len(GlobalPeersList)]
+		url := strings.TrimRight(peer, "/") + "/api/settlements"
+		req, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		var out struct {
+			Results []settlementResult `json:"results"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			lastErr = fmt.Errorf("%s: status %d", peer, resp.StatusCode)
+			continue
+		}
+		return applySettlementResults(ctx, out.Results)
+	}
+	return 0, lastErr
+}
+
+func applySettlementResults(ctx context.Context, results []settlementResult) (int, error) {
+	n := 0
+	for _, r := range results {
+		switch r.Status {
+		case "accepted", "duplicate":
+			if _, err := transitionTx(ctx, r.TxHash, []string{"local_confirmed"}, "submitted_global", ""); err != nil {
+				return n, err
+			}
+			n++
+		default:
+			raiseTamperAlert(ctx, r.TxHash, "settlement_rejected", map[string]any{"reason": r.Reason})
+			reason := "settlement_rejected"
+			if r.Reason != "" {
+				reason += ": " + r.Reason
+			}
+			if _, err := transitionTx(ctx, r.TxHash, []string{"local_confirmed"}, "quarantined", reason); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// loadSettlementItems returns up to limit of our own local_confirmed txs,
+// each with the latest accepting vote of every node as its quorum proof.
+func loadSettlementItems(ctx context.Context, limit int) ([]settlementItem, error) {
+	rows, err := DB.QueryContext(ctx, `
+		SELECT tx_hash, tx_body, node_signature FROM local_dag_nodes
+		WHERE node_id=$1 AND status='local_confirmed'
+		ORDER BY created_at
+		LIMIT $2
+	`, NodeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	var items []settlementItem
+	for rows.Next() {
+		var it settlementItem
+		var body string
+		if err := rows.Scan(&it.TxHash, &body, &it.NodeSignature); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		it.TxBody = []byte(body)
+		items = append(items, it)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range items {
+		votes, err := loadQuorumProof(ctx, items[i].TxHash)
+		if err != nil {
+			return nil, err
+		}
+		items[i].Votes = votes
+	}
+	return items, nil
+}
+
+// loadQuorumProof rebuilds the signed accept votes recorded for txHash.
+func loadQuorumProof(ctx context.Context, txHash string) ([]localVote, error) {
+	rows, err := DB.QueryContext(ctx, `
+		SELECT DISTINCT ON (node_id) node_id, round, signature, details
+		FROM local_attestations
+		WHERE tx_hash=$1 AND verified AND details->>'verdict'='accept'
+		ORDER BY node_id, round DESC
+	`, txHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var votes []localVote
+	for rows.Next() {
+		var v localVote
+		var details []byte
+		if err := rows.Scan(&v.Voter.NodeID, &v.Round, &v.Voter.SigB64, &details); err != nil {
+			return nil, err
+		}
+		var d struct {
+			Parents       []string        `json:"parents"`
+			ParentsDigest string          `json:"parents_digest"`
+			ParentPubB64  string          `json:"parent_pub_b64"`
+			Attestation   json.RawMessage `json:"attestation"`
+		}
+		if err := json.Unmarshal(details, &d); err != nil {
+			return nil, err
+		}
+		v.TxHash = txHash
+		v.Verdict = "accept"
+		v.Parents = d.Parents
+		v.ParentsDigest = d.ParentsDigest
+		v.Voter.ParentPubB64 = d.ParentPubB64
+		v.Voter.Attestation = d.Attestation
+		votes = append(votes, v)
+	}
+	return votes, rows.Err()
+}
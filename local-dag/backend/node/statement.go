@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// maxStatementEntries bounds one export; callers split longer ranges.
+const maxStatementEntries = 10000
+
+// statementEntry is one ledger row from the account's point of view.
+type statementEntry struct {
+	BookedAt     time.Time
+	TxHash       string
+	TxType       string
+	Status       string // raw DAG status
+	Credit       bool
+	Counterparty string
+	Amount       int64 // minor units, positive
+	BalanceAfter int64
+}
+
+// effective reports whether e moves the balance; rejected and quarantined
+// txs are listed but never booked.
+func (e statementEntry) effective() bool {
+	return dashboardStatus(e.Status) != "failed"
+}
+
+type statement struct {
+	AccountID string
+	Currency  string
+	Scale     int
+	From, To  time.Time
+	Opening   int64
+	Closing   int64
+	Entries   []statementEntry
+}
+
+var errStatementPruned = errors.New("statement range is pruned")
+
+// statementLegs is every money movement touching $1 in currency $2 that is
+// not already in the balance of the checkpoint with frontier $3, signed from
+// the account's point of view. A self-transfer nets to zero and shows as one
+// debit and one credit.
+const statementLegs = `
+	WITH RECURSIVE covered(tx_hash) AS (
+	  SELECT unnest($3::text[])
+	  UNION
+	  SELECT p FROM covered c JOIN local_dag_nodes d ON d.tx_hash = c.tx_hash CROSS JOIN LATERAL unnest(d.parents) AS p
+	), dag AS (
+	  SELECT ledger_id, tx_hash, status FROM local_dag_nodes d
+	  WHERE NOT EXISTS (SELECT 1 FROM covered c WHERE c.tx_hash = d.tx_hash)
+	), legs AS (
+	  SELECT l.id, l.created_at, d.tx_hash, l.tx_type, d.status, false AS credit, l.to_public_id AS counterparty, l.amount
+	  FROM local_ledger l JOIN dag d ON d.ledger_id = l.id
+	  WHERE l.from_public_id=$1 AND l.currency=$2 AND l.tx_type <> 'bulk_import'
+	  UNION ALL
+	  SELECT l.id, l.created_at, d.tx_hash, l.tx_type, d.status, true, l.from_public_id, COALESCE(l.counter_amount, l.amount)
+	  FROM local_ledger l JOIN dag d ON d.ledger_id = l.id
+	  WHERE l.to_public_id=$1 AND COALESCE(l.counter_currency, l.currency)=$2 AND l.tx_type <> 'bulk_import'
+	)`
+
+// statementBase returns the newest checkpoint this node has pruned under or
+// bootstrapped from, and account's balance in it. History before it is only
+// known as that balance.
+func statementBase(ctx context.Context, account, currency string) (frontier []string, at time.Time, balance int64, err error) {
+	frontier = []string{}
+	var hash string
+	var atMs int64
+	err = DB.QueryRowContext(ctx, `
+		SELECT hash, frontier, created_unix_ms FROM local_checkpoints
+		WHERE status='signed' AND pruned_at IS NOT NULL
+		ORDER BY seq DESC LIMIT 1
+	`).Scan(&hash, pq.Array(&frontier), &atMs)
+	if err == sql.ErrNoRows {
+		return frontier, time.Time{}, 0, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, 0, err
+	}
+	err = DB.QueryRowContext(ctx, `
+		SELECT COALESCE((SELECT balance FROM local_checkpoint_balances
+		                 WHERE checkpoint_hash=$1 AND account_id=$2 AND currency=$3), 0)
+	`, hash, account, currency).Scan(&balance)
+	return frontier, time.UnixMilli(atMs).UTC(), balance, err
+}
+
+// loadStatement reads the opening balance at from and every entry in
+// [from, to), oldest first. Only effective entries move the running
+// balance. A from before the pruning checkpoint moves up
+// to it. Asset legs are never in a real currency, so the currency filter
+// already leaves them out.
+func loadStatement(ctx context.Context, account, currency string, scale int, from, to time.Time) (statement, error) {
+	st := statement{AccountID: account, Currency: currency, Scale: scale, From: from, To: to}
+	frontier, baseAt, baseBalance, err := statementBase(ctx, account, currency)
+	if err != nil {
+		return st, err
+	}
+	if from.Before(baseAt) {
+		if !to.After(baseAt) {
+			return st, errStatementPruned
+		}
+		st.From = baseAt
+	}
+	err = DB.QueryRowContext(ctx, statementLegs+`
+		SELECT COALESCE(SUM(CASE WHEN credit THEN amount ELSE -amount END), 0)::bigint
+		FROM legs WHERE created_at < $4 AND status NOT IN ('rejected','quarantined')
+	`, account, currency, pq.Array(frontier), st.From).Scan(&st.Opening)
+	if err != nil {
+		return st, err
+	}
+	st.Opening += baseBalance
+
+	rows, err := DB.QueryContext(ctx, statementLegs+`
+		SELECT created_at, tx_hash, tx_type, status, credit, counterparty, amount
+		FROM legs WHERE created_at >= $4 AND created_at < $5
+		ORDER BY created_at, id, credit
+		LIMIT $6
+	`, account, currency, pq.Array(frontier), st.From, to, maxStatementEntries+1)
+	if err != nil {
+		return st, err
+	}
+	defer rows.Close()
+
+	bal := st.Opening
+	for rows.Next() {
+		var e statementEntry
+		if err := rows.Scan(&e.BookedAt, &e.TxHash, &e.TxType, &e.Status, &e.Credit, &e.Counterparty, &e.Amount); err != nil {
+			return st, err
+		}
+		switch {
+		case !e.effective():
+		case e.Credit:
+			bal += e.Amount
+		default:
+			bal -= e.Amount
+		}
+		e.BalanceAfter = bal
+		st.Entries = append(st.Entries, e)
+	}
+	st.Closing = bal
+	return st, rows.Err()
+}
+
+// formatMinor renders minor units as a decimal string at scale.
+func formatMinor(v int64, scale int) string {
+	neg := v < 0
+	if neg {
+		v = -v
+	}
+	s := strconv.FormatInt(v, 10)
+	if scale > 0 {
+		for len(s) <= scale {
+			s = "0" + s
+		}
+		s = s[:len(s)-scale] + "." + s[len(s)-scale:]
+	}
+	if neg {
+		s = "-" + s
+	}
+	return s
+}
+
+// === Renderers ===
+
+func renderStatementCSV(st statement) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"booked_at", "tx_hash", "tx_type", "status", "direction", "counterparty", "amount", "currency", "balance"})
+	_ = w.Write([]string{st.From.UTC().Format(time.RFC3339), "", "opening_balance", "", "", "", "", st.Currency, formatMinor(st.Opening, st.Scale)})
+	for _, e := range st.Entries {
+		dir, amt := "debit", -e.Amount
+		if e.Credit {
+			dir, amt = "credit", e.Amount
+		}
+		_ = w.Write([]string{
+			e.BookedAt.UTC().Format(time.RFC3339Nano), e.TxHash, e.TxType, e.Status, dir, e.Counterparty,
+			formatMinor(amt, st.Scale), st.Currency, formatMinor(e.BalanceAfter, st.Scale),
+		})
+	}
+	_ = w.Write([]string{st.To.UTC().Format(time.RFC3339), "", "closing_balance", "", "", "", "", st.Currency, formatMinor(st.Closing, st.Scale)})
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// camt.053.001.08, restricted to what a ledger of public IDs can fill in.
+type camtDocument struct {
+	XMLName xml.Name        `xml:"urn:iso:std:iso:20022:tech:xsd:camt.053.001.08 Document"`
+	Stmt    camtBkToCstmrSt `xml:"BkToCstmrStmt"`
+}
+
+type camtBkToCstmrSt struct {
+	GrpHdr struct {
+		MsgID   string `xml:"MsgId"`
+		CreDtTm string `xml:"CreDtTm"`
+	} `xml:"GrpHdr"`
+	Stmt camtStmt `xml:"Stmt"`
+}
+
+type camtStmt struct {
+	ID      string `xml:"Id"`
+	CreDtTm string `xml:"CreDtTm"`
+	FrToDt  struct {
+		FrDtTm string `xml:"FrDtTm"`
+		ToDtTm string `xml:"ToDtTm"`
+	} `xml:"FrToDt"`
+	Acct struct {
+		OthrID string `xml:"Id>Othr>Id"`
+		Ccy    string `xml:"Ccy"`
+	} `xml:"Acct"`
+	Bal    []camtBal   `xml:"Bal"`
+	Summry camtSummary `xml:"TxsSummry"`
+	Ntry   []camtNtry  `xml:"Ntry"`
+}
+
+type camtAmt struct {
+	Ccy   string `xml:"Ccy,attr"`
+	Value string `xml:",chardata"`
+}
+
+type camtBal struct {
+	Cd        string  `xml:"Tp>CdOrPrtry>Cd"` // OPBD | CLBD
+	Amt       camtAmt `xml:"Amt"`
+	CdtDbtInd string  `xml:"CdtDbtInd"`
+	DtTm      string  `xml:"Dt>DtTm"`
+}
+
+type camtSummary struct {
+	NbOfNtries string `xml:"TtlNtries>NbOfNtries"`
+	TtlCdt     string `xml:"TtlCdtNtries>Sum"`
+	TtlDbt     string `xml:"TtlDbtNtries>Sum"`
+}
+
+type camtNtry struct {
+	NtryRef   string  `xml:"NtryRef"`
+	Amt       camtAmt `xml:"Amt"`
+	CdtDbtInd string  `xml:"CdtDbtInd"`
+	Sts       string  `xml:"Sts>Cd"` // BOOK | PDNG | INFO
+	BookgDt   string  `xml:"BookgDt>DtTm"`
+	BkTxCd    string  `xml:"BkTxCd>Prtry>Cd"`
+	TxDtls    struct {
+		EndToEndID string `xml:"Refs>EndToEndId"`
+		Dbtr       string `xml:"RltdPties>Dbtr>Pty>Nm,omitempty"`
+		Cdtr       string `xml:"RltdPties>Cdtr>Pty>Nm,omitempty"`
+	} `xml:"NtryDtls>TxDtls"`
+}
+
+// camtStatus maps DAG states onto camt entry statuses.
+func camtStatus(dagStatus string) string {
+	switch dashboardStatus(dagStatus) {
+	case "confirmed":
+		return "BOOK"
+	case "failed":
+		return "INFO"
+	default:
+		return "PDNG"
+	}
+}
+
+func camtBalance(cd string, v int64, st statement, at time.Time) camtBal {
+	b := camtBal{Cd: cd, CdtDbtInd: "CRDT", DtTm: at.UTC().Format(time.RFC3339)}
+	if v < 0 {
+		b.CdtDbtInd, v = "DBIT", -v
+	}
+	b.Amt = camtAmt{Ccy: st.Currency, Value: formatMinor(v, st.Scale)}
+	return b
+}
+
+func renderStatementCamt053(st statement, id string, created time.Time) ([]byte, error) {
+	var doc camtDocument
+	doc.Stmt.GrpHdr.MsgID = id
+	doc.Stmt.GrpHdr.CreDtTm = created.UTC().Format(time.RFC3339)
+	s := &doc.Stmt.Stmt
+	s.ID = id
+	s.CreDtTm = doc.Stmt.GrpHdr.CreDtTm
+	s.FrToDt.FrDtTm = st.From.UTC().Format(time.RFC3339)
+	s.FrToDt.ToDtTm = st.To.UTC().Format(time.RFC3339)
+	s.Acct.OthrID = st.AccountID
+	s.Acct.Ccy = st.Currency
+	s.Bal = []camtBal{
+		camtBalance("OPBD", st.Opening, st, st.From),
+		camtBalance("CLBD", st.Closing, st, st.To),
+	}
+
+	var credits, debits int64
+	for _, e := range st.Entries {
+		n := camtNtry{
+			NtryRef:   e.TxHash,
+			Amt:       camtAmt{Ccy: st.Currency, Value: formatMinor(e.Amount, st.Scale)},
+			CdtDbtInd: "DBIT",
+			Sts:       camtStatus(e.Status),
+			BookgDt:   e.BookedAt.UTC().Format(time.RFC3339Nano),
+			BkTxCd:    e.TxType,
+		}
+		n.TxDtls.EndToEndID = e.TxHash
+		if e.Credit {
+			n.CdtDbtInd = "CRDT"
+			n.TxDtls.Dbtr = e.Counterparty
+		} else {
+			n.TxDtls.Cdtr = e.Counterparty
+		}
+		switch {
+		case !e.effective():
+		case e.Credit:
+			credits += e.Amount
+		default:
+			debits += e.Amount
+		}
+		s.Ntry = append(s.Ntry, n)
+	}
+	s.Summry = camtSummary{
+		NbOfNtries: strconv.Itoa(len(st.Entries)),
+		TtlCdt:     formatMinor(credits, st.Scale),
+		TtlDbt:     formatMinor(debits, st.Scale),
+	}
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+// statementSignMessage binds the exported bytes to the statement's scope
+// and the DAG entries it covers.
+func statementSignMessage(digest string, st statement, firstTx, lastTx string) []byte {
+	return []byte(fmt.Sprintf("statement|%s|%s|%s|%s|%s|%s|%s|%d",
+		digest, st.AccountID, st.Currency, st.From.UTC().Format(time.RFC3339), st.To.UTC().Format(time.RFC3339),
+		firstTx, lastTx, len(st.Entries)))
+}
+
+// === Handler ===
+
+// HandlerAccountStatement renders an account's statement for [from, to) in
+// one currency as CSV (default) or camt.053 XML.
+//
+// Query: currency (default DefaultCurrency), from/to (RFC 3339; default the
+// last 30 days; a from before pruned history starts at the pruning
+// checkpoint, and a range entirely before it is 410), format (csv|camt053).
+// The detached signature travels in
+// headers: X-Statement-Digest is the sha256 of the body,
+// X-Statement-First-Tx/-Last-Tx/-Tx-Count the covered DAG entries, and
+// X-Statement-Signature the base64 JSON signature envelope over
+// statementSignMessage.
+func HandlerAccountStatement(c *gin.Context) {
+	account := c.Param("public_id")
+	currency := c.DefaultQuery("currency", DefaultCurrency)
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "camt053" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_format"})
+		return
+	}
+	to := time.Now().UTC()
+	from := to.AddDate(0, 0, -30)
+	var err error
+	if v := c.Query("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_from"})
+			return
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_to"})
+			return
+		}
+	}
+	if !to.After(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "empty_range"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	scale, err := currencyScale(ctx, currency)
+	if err == errUnknownCurrency {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown_currency", "currency": currency})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_lookup_currency", "details": err.Error()})
+		return
+	}
+	st, err := loadStatement(ctx, account, currency, scale, from, to)
+	if err == errStatementPruned {
+		c.JSON(http.StatusGone, gin.H{"error": "range_pruned"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_statement", "details": err.Error()})
+		return
+	}
+	if len(st.Entries) > maxStatementEntries {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "range_too_large", "max_entries": maxStatementEntries})
+		return
+	}
+
+	created := time.Now().UTC()
+	var body []byte
+	contentType, ext := "text/csv; charset=utf-8", "csv"
+	if format == "camt053" {
+		id := fmt.Sprintf("STMT-%s-%s-%d", NodeID, currency, created.UnixMilli())
+		body, err = renderStatementCamt053(st, id, created)
+		contentType, ext = "application/xml; charset=utf-8", "xml"
+	} else {
+		body, err = renderStatementCSV(st)
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "render_statement", "details": err.Error()})
+		return
+	}
+
+	var firstTx, lastTx string
+	if n := len(st.Entries); n > 0 {
+		firstTx, lastTx = st.Entries[0].TxHash, st.Entries[n-1].TxHash
+	}
+	sum := sha256.Sum256(body)
+	digest := hex.EncodeToString(sum[:])
+	env, err := signEnvelope(statementSignMessage(digest, st, firstTx, lastTx))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "sign_failed", "details": err.Error()})
+		return
+	}
+	envJSON, _ := json.Marshal(env)
+
+	c.Header("X-Statement-Digest", digest)
+	c.Header("X-Statement-First-Tx", firstTx)
+	c.Header("X-Statement-Last-Tx", lastTx)
+	c.Header("X-Statement-Tx-Count", strconv.Itoa(len(st.Entries)))
+	c.Header("X-Statement-Signature", base64.StdEncoding.EncodeToString(envJSON))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="statement-%s-%s-%s.%s"`,
+		account, currency, st.From.UTC().Format("20060102"), ext))
+	c.Data(200, contentType, body)
+}
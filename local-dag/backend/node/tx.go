@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// maxParents is how many current tips a new local DAG node links to.
+const maxParents = 2
+
+var allowedTxTypes = map[string]bool{
+	"transfer":    true,
+	"stake":       true,
+	"vote":        true,
+	"trade_cash":  true, // cash leg of a fill, buyer -> seller
+	"trade_asset": true, // asset leg of a fill, seller -> buyer; amount is quantity
+	"issue_asset": true, // registers payload.symbol and credits the issuer
+	"fx_transfer": true, // credits counter_amount counter_currency; rate in payload.fx
+
+	"bulk_import":   true, // pain.001 file header: amount 0, from = to = debtor
+	"bulk_transfer": true, // one pain.001 instruction, child of its bulk_import
+}
+
+// assetTxTypes carry a symbol in their payload.
+var assetTxTypes = map[string]bool{
+	"trade_cash":  true,
+	"trade_asset": true,
+	"issue_asset": true,
+}
+
+// reservedTxTypes are built server-side and can't be submitted directly.
+var reservedTxTypes = map[string]bool{
+	"trade_cash":  true,
+	"trade_asset": true,
+	"issue_asset": true,
+	"fx_transfer": true,
+
+	"bulk_import":   true,
+	"bulk_transfer": true,
+}
+
+// localTx is the canonical form of a local transaction. Its JSON encoding is
+// stored verbatim in local_dag_nodes.tx_body and hashed into tx_hash.
+type localTx struct {
+	FromPublicID string          `json:"from_public_id"`
+	ToPublicID   string          `json:"to_public_id"`
+	Amount       int64           `json:"amount"`
+	Currency     string          `json:"currency"`
+	Scale        int             `json:"scale"`
+	TxType       string          `json:"tx_type"`
+	Nonce        int64           `json:"nonce"`
+	TsUnixMs     int64           `json:"ts_unix_ms"`
+	Payload      json.RawMessage `json:"payload,omitempty"`
+	Parents      []string        `json:"parents"`
+
+	CounterAmount   int64  `json:"counter_amount,omitempty"`
+	CounterCurrency string `json:"counter_currency,omitempty"`
+
+	// UserPub/UserSig record the sender's signature over clientTxFor(t);
+	// UserTsUnixMs is the signing time the sender put in it.
+	UserPub      string `json:"user_pub,omitempty"`
+	UserSig      string `json:"user_sig,omitempty"`
+	UserTsUnixMs int64  `json:"user_ts_unix_ms,omitempty"`
+}
+
+func (t localTx) validate() error {
+	if t.FromPublicID == "" || t.ToPublicID == "" {
+		return errors.New("from_public_id and to_public_id required")
+	}
+	if t.Amount < 0 {
+		return errors.New("amount must be non-negative")
+	}
+	if !allowedTxTypes[t.TxType] {
+		return errors.New("unsupported tx_type")
+	}
+	if !currencyCodeRe.MatchString(t.Currency) || t.Scale < 0 || t.Scale > 8 {
+		return errors.New("currency and scale required")
+	}
+	if (t.TxType == "trade_asset" || t.TxType == "issue_asset") != (t.Currency == noCurrency) {
+		return errors.New("asset legs, and only asset legs, use currency XXX")
+	}
+	if t.TxType == "fx_transfer" {
+		var p struct {
+			Fx struct {
+				Rate string `json:"rate"`
+			} `json:"fx"`
+		}
+		if !currencyCodeRe.MatchString(t.CounterCurrency) || t.CounterCurrency == t.Currency || t.CounterAmount < 0 {
+			return errors.New("fx_transfer needs a distinct counter_currency and counter_amount")
+		}
+		if err := json.Unmarshal(t.Payload, &p); err != nil || p.Fx.Rate == "" {
+			return errors.New("payload.fx.rate required")
+		}
+	} else if t.CounterCurrency != "" || t.CounterAmount != 0 {
+		return errors.New("counter_amount is only valid on fx_transfer")
+	}
+	if t.Parents == nil {
+		return errors.New("parents required")
+	}
+	if err := t.validateBulk(); err != nil {
+		return err
+	}
+	if assetTxTypes[t.TxType] {
+		var p struct {
+			Symbol string `json:"symbol"`
+		}
+		if err := json.Unmarshal(t.Payload, &p); err != nil || p.Symbol == "" {
+			return errors.New("payload.symbol required")
+		}
+	}
+	return nil
+}
+
+// encodeTx returns the canonical body and its hex tx_hash.
+func encodeTx(t localTx) ([]byte, string, error) {
+	body, err := json.Marshal(t)
+	if err != nil {
+		return nil, "", err
+	}
+	h := sha256.Sum256(body)
+	return body, hex.EncodeToString(h[:]), nil
+}
+
+// txSignMessage is the exact message a node signs to vouch for a DAG node.
+func txSignMessage(txHash string) []byte {
+	return []byte("tx:" + txHash)
+}
+
+// HandlerSubmitTx is the local fast path: verify, append to the local DAG,
+// acknowledge the client, then vote and gossip to siblings.
+func HandlerSubmitTx(c *gin.Context) {
+	var req struct {
+		FromPublicID string          `json:"from_public_id"`
+		ToPublicID   string          `json:"to_public_id"`
+		Amount       int64           `json:"amount"`
+		Currency     string          `json:"currency"`
+		TxType       string          `json:"tx_type"`
+		Nonce        int64           `json:"nonce"`
+		Payload      json.RawMessage `json:"payload"`
+		TsUnixMs     int64           `json:"ts_unix_ms"` // sender's signing time
+		UserSig      string          `json:"user_sig"`   // base64 Ed25519 over clientTxSignMessage
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request", "details": err.Error()})
+		return
+	}
+	if req.TxType == "" {
+		req.TxType = "transfer"
+	}
+	if reservedTxTypes[req.TxType] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_tx", "details": "reserved tx_type"})
+		return
+	}
+	if req.Currency == "" {
+		req.Currency = DefaultCurrency
+	}
+	ctx := c.Request.Context()
+
+	scale, err := currencyScale(ctx, req.Currency)
+	if errors.Is(err, errUnknownCurrency) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown_currency", "currency": req.Currency})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_lookup_currency", "details": err.Error()})
+		return
+	}
+
+	userPub, ok := checkUserSig(c, clientTx{
+		FromPublicID: req.FromPublicID,
+		ToPublicID:   req.ToPublicID,
+		Amount:       req.Amount,
+		Currency:     req.Currency,
+		TxType:       req.TxType,
+		Nonce:        req.Nonce,
+		TsUnixMs:     req.TsUnixMs,
+		Payload:      req.Payload,
+	}, req.UserSig)
+	if !ok {
+		return
+	}
+
+	parents, err := selectParents(ctx)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_select_parents", "details": err.Error()})
+		return
+	}
+	t := localTx{
+		FromPublicID: req.FromPublicID,
+		ToPublicID:   req.ToPublicID,
+		Amount:       req.Amount,
+		Currency:     req.Currency,
+		Scale:        scale,
+		TxType:       req.TxType,
+		Nonce:        req.Nonce,
+		TsUnixMs:     time.Now().UnixMilli(),
+		Payload:      req.Payload,
+		Parents:      parents,
+		UserPub:      userPub,
+	}
+	if userPub != "" {
+		t.UserSig, t.UserTsUnixMs = req.UserSig, req.TsUnixMs
+	}
+	if err := t.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_tx", "details": err.Error()})
+		return
+	}
+	if !checkNonceUnused(c, t) {
+		return
+	}
+	flags, ok := checkFraud(c, t)
+	if !ok {
+		return
+	}
+
+	msg, err := commitLocalTx(ctx, t)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db_insert_tx", "details": err.Error()})
+		return
+	}
+	flagFraudHits(ctx, msg.TxHash, t, flags)
+
+	c.JSON(200, gin.H{
+		"ok":      true,
+		"tx_hash": msg.TxHash,
+		"parents": parents,
+	})
+
+	go announceTx(msg, parents)
+}
+
+// commitLocalTx signs t as this node, persists it through the group-commit
+// pipeline and returns the gossip message siblings need to re-verify it.
+func commitLocalTx(ctx context.Context, t localTx) (gossipMessage, error) {
+	msg, err := signLocalTx(t)
+	if err != nil {
+		return gossipMessage{}, err
+	}
+	inserted, err := groupCommit(ctx, t, msg.TxBody, msg.TxHash, NodeID, msg.Origin.SigB64)
+	if err != nil {
+		return gossipMessage{}, err
+	}
+	if inserted {
+		resolveConflictsAfterInsert(ctx, t, msg.TxHash)
+	}
+	return msg, nil
+}
+
+// signLocalTx encodes t and signs its hash as this node, without storing it.
+func signLocalTx(t localTx) (gossipMessage, error) {
+	body, txHash, err := encodeTx(t)
+	if err != nil {
+		return gossipMessage{}, err
+	}
+	env, err := signEnvelope(txSignMessage(txHash))
+	if err != nil {
+		return gossipMessage{}, err
+	}
+	return gossipMessage{
+		TxBody: body,
+		TxHash: txHash,
+		Origin: env,
+		Round:  1,
+	}, nil
+}
+
+// insertLocalTx writes the ledger row, its DAG node, the signed "received"
+// transition and the tx log leaf. It reports false
+// (and writes nothing the caller should keep) if tx_hash is already known.
+func insertLocalTx(ctx context.Context, tx *sql.Tx, t localTx, body []byte, txHash, originNode, nodeSig string) (bool, error) {
+	payload := []byte(t.Payload)
+	if len(payload) == 0 {
+		payload = []byte(`{}`)
+	}
+
+	var counterAmount sql.NullInt64
+	var counterCurrency sql.NullString
+	if t.CounterCurrency != "" {
+		counterAmount = sql.NullInt64{Int64: t.CounterAmount, Valid: true}
+		counterCurrency = sql.NullString{String: t.CounterCurrency, Valid: true}
+	}
+
+	var ledgerID string
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO local_ledger (from_public_id, to_public_id, amount, currency, scale, counter_amount, counter_currency, tx_type, nonce, payload, created_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10::jsonb,$11)
+		RETURNING id
+	`, t.FromPublicID, t.ToPublicID, t.Amount, t.Currency, t.Scale, counterAmount, counterCurrency,
+		t.TxType, t.Nonce, string(payload), time.UnixMilli(t.TsUnixMs).UTC()).Scan(&ledgerID)
+	if err != nil {
+		return false, err
+	}
+
+	var dagNodeID string
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO local_dag_nodes (ledger_id, tx_hash, parents, dag_type, node_id, node_signature, tx_body)
+		VALUES ($1,$2,$3,'local',$4,$5,$6)
+		ON CONFLICT (tx_hash) DO NOTHING
+		RETURNING id
+	`, ledgerID, txHash, pq.Array(t.Parents), originNode, nodeSig, string(body)).Scan(&dagNodeID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := recordTransition(ctx, tx, txHash, "", "received", ""); err != nil {
+		return false, err
+	}
+	if err := appendTxLog(ctx, tx, txHash); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// selectParents returns the newest DAG tips (nodes no other node references).
+// A node bootstrapped from a checkpoint builds on its frontier until it has
+// tips of its own.
+func selectParents(ctx context.Context) ([]string, error) {
+	if parents := DAG.Tips(maxParents); len(parents) > 0 {
+		return parents, nil
+	}
+
+	var frontier []string
+	err := DB.QueryRowContext(ctx, `
+		SELECT frontier FROM local_checkpoints WHERE status='signed' ORDER BY seq DESC LIMIT 1
+	`).Scan(pq.Array(&frontier))
+	if err == sql.ErrNoRows {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(frontier) > maxParents {
+		frontier = frontier[:maxParents]
+	}
+	return frontier, nil
+}
+
+// missingParents returns the parents of t not yet present in the local DAG.
+// Pruned txs and checkpoint frontiers count as present. Only parents the
+// DAG index doesn't know reach the database.
+func missingParents(ctx context.Context, parents []string) ([]string, error) {
+	var unknown []string
+	for _, p := range parents {
+		if !DAG.Has(p) {
+			unknown = append(unknown, p)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil, nil
+	}
+	rows, err := DB.QueryContext(ctx, `
+		SELECT p FROM unnest($1::text[]) AS p
+		WHERE NOT EXISTS (SELECT 1 FROM local_dag_nodes d WHERE d.tx_hash = p)
+		  AND NOT EXISTS (SELECT 1 FROM local_pruned_txs x WHERE x.tx_hash = p)
+		  AND NOT EXISTS (SELECT 1 FROM local_checkpoints c WHERE c.status = 'signed' AND p = ANY(c.frontier))
+	`, pq.Array(unknown))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var missing []string
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			return nil, err
+		}
+		missing = append(missing, h)
+	}
+	return missing, rows.Err()
+}
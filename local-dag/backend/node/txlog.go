@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"hackodisha/backend/merkle"
+	"hackodisha/backend/txproof"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// The tx log is this node's append-only Merkle accumulator over the DAG
+// entries it has stored, in the order it stored them. local_tx_log_nodes
+// keeps every perfect subtree hash, so roots and audit paths for any size
+// need O(log² n) lookups.
+
+// appendTxLog appends txHashes, in order, as the next leaves inside tx. The
+// head row lock serialises appends, which keeps leaf indexes gapless. Leaves
+// and completed subtrees are each written with one statement.
+func appendTxLog(ctx context.Context, tx *sql.Tx, txHashes ...string) error {
+	if len(txHashes) == 0 {
+		return nil
+	}
+	var start uint64
+	if err := tx.QueryRowContext(ctx, `
+		UPDATE local_tx_log_head SET size = size + $1 WHERE id = 1 RETURNING size - $1
+	`, len(txHashes)).Scan(&start); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO local_tx_log (leaf_index, tx_hash)
+		SELECT $1 + u.ord - 1, u.tx_hash FROM unnest($2::text[]) WITH ORDINALITY AS u(tx_hash, ord)
+	`, start, pq.Array(txHashes)); err != nil {
+		return err
+	}
+
+	type nodeKey struct {
+		level uint
+		index uint64
+	}
+	written := map[nodeKey][]byte{}
+	var levels, indexes []int64
+	var hashes []string
+	for i, txHash := range txHashes {
+		index := start + uint64(i)
+		h := merkle.LeafHash(txproof.LeafData(txHash))
+		var level uint
+		for {
+			written[nodeKey{level, index}] = h
+			levels = append(levels, int64(level))
+			indexes = append(indexes, int64(index))
+			hashes = append(hashes, hex.EncodeToString(h))
+			if index&1 == 0 {
+				break
+			}
+			// a right child completes its parent
+			left, ok := written[nodeKey{level, index - 1}]
+			if !ok {
+				var leftHex string
+				if err := tx.QueryRowContext(ctx, `
+					SELECT hash FROM local_tx_log_nodes WHERE level=$1 AND idx=$2
+				`, level, index-1).Scan(&leftHex); err != nil {
+					return err
+				}
+				left, _ = hex.DecodeString(leftHex)
+			}
+			h = merkle.NodeHash(left, h)
+			level++
+			index >>= 1
+		}
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO local_tx_log_nodes (level, idx, hash)
+		SELECT * FROM unnest($1::smallint[], $2::bigint[], $3::text[])
+	`, pq.Array(levels), pq.Array(indexes), pq.Array(hashes))
+	return err
+}
+
+// backfillTxLog appends DAG entries stored before the accumulator existed,
+// oldest first.
+func backfillTxLog(ctx context.Context) error {
+	rows, err := DB.QueryContext(ctx, `
+		SELECT d.tx_hash FROM local_dag_nodes d
+		WHERE NOT EXISTS (SELECT 1 FROM local_tx_log l WHERE l.tx_hash = d.tx_hash)
+		ORDER BY d.created_at, d.tx_hash
+	`)
+	if err != nil {
+		return err
+	}
+	var hashes []string
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			rows.Close()
+			return err
+		}
+		hashes = append(hashes, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(hashes) == 0 {
+		return err
+	}
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := appendTxLog(ctx, tx, hashes...); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("txlog: backfilled %d entries", len(hashes))
+	return nil
+}
+
+// txLogNodes reads perfect subtree hashes for the merkle package.
+func txLogNodes(ctx context.Context) merkle.NodeFunc {
+	return func(level uint, index uint64) ([]byte, error) {
+		var h string
+		err := DB.QueryRowContext(ctx, `
+			SELECT hash FROM local_tx_log_nodes WHERE level=$1 AND idx=$2
+		`, level, index).Scan(&h)
+		if err != nil {
+			return nil, err
+		}
+		return hex.DecodeString(h)
+	}
+}
+
+// signedTxLogRoot returns the current root, signed by this node.
+func signedTxLogRoot(ctx context.Context) (txproof.SignedRoot, error) {
+	var size uint64
+	if err := DB.QueryRowContext(ctx, `SELECT size FROM local_tx_log_head WHERE id = 1`).Scan(&size); err != nil {
+		return txproof.SignedRoot{}, err
+	}
+	root, err := merkle.RangeRoot(size, txLogNodes(ctx))
+	if err != nil {
+		return txproof.SignedRoot{}, err
+	}
+	sr := txproof.SignedRoot{
+		TreeSize:       size,
+		Root:           hex.EncodeToString(root),
+		SignedAtUnixMs: time.Now().UnixMilli(),
+	}
+	env, err := signEnvelope(txproof.RootMessage(sr.TreeSize, sr.Root, sr.SignedAtUnixMs))
+	if err != nil {
+		return txproof.SignedRoot{}, err
+	}
+	sr.Signer = txproof.Signer(env)
+	return sr, nil
+}
+
+// === Handlers ===
+
+// HandlerTxLogRoot serves the current signed root.
+func HandlerTxLogRoot(c *gin.Context) {
+	sr, err := signedTxLogRoot(c.Request.Context())
+	if err != nil {
+		c.JSON(500, gin.H{"error": "txlog_root", "details": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true, "signed_root": sr})
+}
+
+// errTxNotLogged means the tx log holds no leaf for a tx_hash.
+var errTxNotLogged = errors.New("tx not in tx log")
+
+// txInclusionProof returns txHash's audit path against a freshly signed root.
+func txInclusionProof(ctx context.Context, txHash string) (txproof.Proof, error) {
+	var index uint64
+	err := DB.QueryRowContext(ctx, `SELECT leaf_index FROM local_tx_log WHERE tx_hash=$1`, txHash).Scan(&index)
+	if err == sql.ErrNoRows {
+		return txproof.Proof{}, errTxNotLogged
+	}
+	if err != nil {
+		return txproof.Proof{}, err
+	}
+	sr, err := signedTxLogRoot(ctx)
+	if err != nil {
+		return txproof.Proof{}, err
+	}
+	path, err := merkle.InclusionPath(index, sr.TreeSize, txLogNodes(ctx))
+	if err != nil {
+		return txproof.Proof{}, err
+	}
+	p := txproof.Proof{TxHash: txHash, LeafIndex: index, Path: make([]string, len(path)), SignedRoot: sr}
+	for i, h := range path {
+		p.Path[i] = hex.EncodeToString(h)
+	}
+	return p, nil
+}
+
+// HandlerTxProof serves the inclusion path of a tx_hash against a freshly
+// signed root; txproof.Verify checks the response offline.
+func HandlerTxProof(c *gin.Context) {
+	p, err := txInclusionProof(c.Request.Context(), c.Param("hash"))
+	if err == errTxNotLogged {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tx_not_found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "txlog_proof", "details": err.Error()})
+		return
+	}
+	c.JSON(200, p)
+}
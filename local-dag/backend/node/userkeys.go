@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Client signature checks against the auth DAG.
+var (
+	AuthPeersList   []string
+	UserKeyTTL      = 60 * time.Second
+	UserSigRequired = true
+)
+
+var (
+	errUserUnknown      = errors.New("user_unknown")
+	errUserKeyRevoked   = errors.New("user_key_revoked")
+	errUserKeyRotated   = errors.New("user_key_rotated")
+	errUserSigInvalid   = errors.New("user_signature_invalid")
+	errUserSigMissing   = errors.New("user_signature_missing")
+	errAuthUnavailable  = errors.New("auth_unavailable")
+	errUserSigMalformed = errors.New("user_signature_malformed")
+)
+
+// userSigTxTypes are client-initiated and must carry the sender's signature.
+var userSigTxTypes = map[string]bool{
+	"transfer":    true,
+	"stake":       true,
+	"vote":        true,
+	"fx_transfer": true,
+	"bulk_import": true, // bulk_transfer children are authorised through it
+}
+
+// clientTx is what the sender signs: the request as the node will apply it,
+// with currency and tx_type filled in. Field order is fixed by this struct;
+// Payload is compacted JSON (absent for fx_transfer, whose payload the node
+// builds). TsUnixMs is the sender's own signing time; it breaks ties between
+// conflicting spends (conflictWinner).
+type clientTx struct {
+	FromPublicID    string          `json:"from_public_id"`
+	ToPublicID      string          `json:"to_public_id"`
+	Amount          int64           `json:"amount"`
+	Currency        string          `json:"currency"`
+	CounterCurrency string          `json:"counter_currency,omitempty"`
+	TxType          string          `json:"tx_type"`
+	Nonce           int64           `json:"nonce"`
+	TsUnixMs        int64           `json:"ts_unix_ms,omitempty"`
+	Payload         json.RawMessage `json:"payload,omitempty"`
+}
+
+// clientTxFor rebuilds the signed client form of a stored tx.
+func clientTxFor(t localTx) clientTx {
+	ct := clientTx{
+		FromPublicID:    t.FromPublicID,
+		ToPublicID:      t.ToPublicID,
+		Amount:          t.Amount,
+		Currency:        t.Currency,
+		CounterCurrency: t.CounterCurrency,
+		TxType:          t.TxType,
+		Nonce:           t.Nonce,
+		TsUnixMs:        t.UserTsUnixMs,
+	}
+	if t.TxType != "fx_transfer" {
+		ct.Payload = t.Payload
+	}
+	return ct
+}
+
+// clientTxSignMessage is the exact message a client signs.
+func clientTxSignMessage(ct clientTx) ([]byte, error) {
+	if len(ct.Payload) > 0 {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, ct.Payload); err != nil {
+			return nil, err
+		}
+		ct.Payload = buf.Bytes()
+	}
+	body, err := json.Marshal(ct)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("strix-tx:"), body...), nil
+}
+
+// verifyEd25519 checks a base64 signature over msg with a base64 public key.
+func verifyEd25519(pubB64, sigB64 string, msg []byte) bool {
+	pub, err := base64.StdEncoding.DecodeString(pubB64)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(sigB64)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(pub), msg, sig)
+}
+
+// === Key cache ===
+
+// userKey is an account's key state as reported by the auth DAG.
+type userKey struct {
+	PublicID    string   `json:"public_id"`
+	UserPub     string   `json:"user_pub"`
+	Status      string   `json:"status"`       // active | revoked
+	RetiredKeys []string `json:"retired_keys"` // earlier keys, rotated away
+
+	fetchedAt time.Time
+	missing   bool // auth answered 404
+}
+
+var (
+	userKeysMu sync.Mutex
+	userKeys   = map[string]userKey{}
+)
+
+// resolveUserKey returns publicID's key, from cache unless fresh is set or
+// the entry is older than UserKeyTTL. Unknown accounts are cached too so a
+// flood of bad senders doesn't hammer the auth nodes.
+func resolveUserKey(ctx context.Context, publicID string, fresh bool) (userKey, error) {
+	userKeysMu.Lock()
+	k, ok := userKeys[publicID]
+	userKeysMu.Unlock()
+	if ok && !fresh && time.Since(k.fetchedAt) < UserKeyTTL {
+		if k.missing {
+			return k, errUserUnknown
+		}
+		return k, nil
+	}
+
+	k, err := fetchUserKey(ctx, publicID)
+	if err != nil && err != errUserUnknown {
+		return k, err
+	}
+	userKeysMu.Lock()
+	userKeys[publicID] = k
+	userKeysMu.Unlock()
+	return k, err
+}
+
+// fetchUserKey asks each auth peer in turn.
+func fetchUserKey(ctx context.Context, publicID string) (userKey, error) {
+	client := &http.Client{Timeout: 3 * time.Second}
+	for _, peer := range AuthPeersList {
+		u := strings.TrimRight(peer, "/") + "/api/accounts/" + url.PathEscape(publicID) + "/key"
+		req, _ := http.NewRequestWithContext(ctx, "GET", u, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			continue
+		}
+		var k userKey
+		err = json.NewDecoder(resp.Body).Decode(&k)
+		_ = resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			return userKey{PublicID: publicID, fetchedAt: time.Now(), missing: true}, errUserUnknown
+		case resp.StatusCode != http.StatusOK || err != nil || k.UserPub == "":
+			continue
+		}
+		k.fetchedAt = time.Now()
+		return k, nil
+	}
+	return userKey{}, errAuthUnavailable
+}
+
+func invalidateUserKey(publicID string) {
+	userKeysMu.Lock()
+	delete(userKeys, publicID)
+	userKeysMu.Unlock()
+}
+
+// verifyUserSig checks that the current, unrevoked key of ct's sender signed
+// ct.
+func verifyUserSig(ctx context.Context, ct clientTx, sigB64 string) (string, error) {
+	if sigB64 == "" {
+		return "", errUserSigMissing
+	}
+	msg, err := clientTxSignMessage(ct)
+	if err != nil {
+		return "", errUserSigMalformed
+	}
+	return verifyUserMessage(ctx, ct.FromPublicID, msg, sigB64)
+}
+
+// verifyUserMessage checks that publicID's current, unrevoked key signed
+// msg. A cached key that fails is refreshed once in case the user rotated; a
+// signature that then verifies under a retired key is user_key_rotated.
+func verifyUserMessage(ctx context.Context, publicID string, msg []byte, sigB64 string) (string, error) {
+	if sigB64 == "" {
+		return "", errUserSigMissing
+	}
+	for _, fresh := range []bool{false, true} {
+		k, err := resolveUserKey(ctx, publicID, fresh)
+		if err != nil {
+			return "", err
+		}
+		if k.Status == "revoked" {
+			return "", errUserKeyRevoked
+		}
+		if verifyEd25519(k.UserPub, sigB64, msg) {
+			return k.UserPub, nil
+		}
+		if fresh {
+			for _, old := range k.RetiredKeys {
+				if verifyEd25519(old, sigB64, msg) {
+					return "", errUserKeyRotated
+				}
+			}
+		}
+	}
+	return "", errUserSigInvalid
+}
+
+// userSigStatus maps verification errors to HTTP statuses.
+func userSigStatus(err error) int {
+	switch err {
+	case errAuthUnavailable:
+		return http.StatusServiceUnavailable
+	case errUserSigMissing, errUserSigMalformed:
+		return http.StatusBadRequest
+	default:
+		return http.StatusForbidden
+	}
+}
+
+// checkUserSig is the handler-side gate: it verifies sig for ct and, on
+// success, returns the key that signed it. Disabled when UserSigRequired is
+// false.
+func checkUserSig(c *gin.Context, ct clientTx, sigB64 string) (string, bool) {
+	if !UserSigRequired {
+		return "", true
+	}
+	pub, err := verifyUserSig(c.Request.Context(), ct, sigB64)
+	if err != nil {
+		c.JSON(userSigStatus(err), gin.H{"error": err.Error(), "from_public_id": ct.FromPublicID})
+		return "", false
+	}
+	return pub, true
+}
+
+// checkUserRequest is checkUserSig for signed requests that are not txs
+// (orders, cancels): msg is what publicID signed.
+func checkUserRequest(c *gin.Context, publicID string, msg []byte, sigB64 string) (string, bool) {
+	if !UserSigRequired {
+		return "", true
+	}
+	pub, err := verifyUserMessage(c.Request.Context(), publicID, msg, sigB64)
+	if err != nil {
+		c.JSON(userSigStatus(err), gin.H{"error": err.Error(), "account_id": publicID})
+		return "", false
+	}
+	return pub, true
+}
+
+// checkEmbeddedUserSig lets siblings re-check the client signature recorded
+// in a gossiped tx against the key the auth DAG has registered for the
+// sender, not the user_pub the origin embedded next to it.
+func checkEmbeddedUserSig(ctx context.Context, t localTx) error {
+	if t.UserSig == "" {
+		if UserSigRequired && userSigTxTypes[t.TxType] {
+			return errUserSigMissing
+		}
+		return nil
+	}
+	msg, err := clientTxSignMessage(clientTxFor(t))
+	if err != nil {
+		return errUserSigMalformed
+	}
+	pub, err := verifyUserMessage(ctx, t.FromPublicID, msg, t.UserSig)
+	if err != nil {
+		return err
+	}
+	if pub != t.UserPub {
+		return errUserSigInvalid
+	}
+	return nil
+}
+
+// HandlerAdminInvalidateUserKey drops a cached key, e.g. right after a
+// revocation, instead of waiting out UserKeyTTL.
+func HandlerAdminInvalidateUserKey(c *gin.Context) {
+	invalidateUserKey(c.Param("public_id"))
+	c.JSON(200, gin.H{"ok": true})
+}
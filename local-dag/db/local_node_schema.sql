@@ -1,6 +1,21 @@
 -- Enable pgcrypto for UUID generation
 CREATE EXTENSION IF NOT EXISTS "pgcrypto";
 
+-------------------------------------------------
+-- Currencies
+-- ISO 4217 code and minor-unit scale. XXX ("no currency") denominates
+-- asset legs, whose amount is a unit count.
+-------------------------------------------------
+CREATE TABLE IF NOT EXISTS local_currencies (
+  code CHAR(3) PRIMARY KEY,
+  scale SMALLINT NOT NULL CHECK (scale BETWEEN 0 AND 8)
+);
+
+INSERT INTO local_currencies (code, scale) VALUES
+  ('USD', 2), ('EUR', 2), ('GBP', 2), ('INR', 2), ('JPY', 0),
+  ('CHF', 2), ('SGD', 2), ('BHD', 3), ('KWD', 3), ('XXX', 0)
+ON CONFLICT (code) DO NOTHING;
+
 -------------------------------------------------
 -- Local Ledger
 -- High-speed transactions between public IDs
@@ -9,12 +24,25 @@ CREATE TABLE IF NOT EXISTS local_ledger (
   id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
   from_public_id TEXT NOT NULL,
   to_public_id TEXT NOT NULL,
-  amount BIGINT NOT NULL,
-  tx_type TEXT NOT NULL,            -- e.g. transfer, stake, vote
+  amount BIGINT NOT NULL,           -- in minor units of currency
+  currency CHAR(3) NOT NULL REFERENCES local_currencies(code),
+  scale SMALLINT NOT NULL,          -- minor-unit scale at the time of the tx
+  counter_amount BIGINT,            -- fx_transfer: amount credited to the payee
+  counter_currency CHAR(3) REFERENCES local_currencies(code),
+  tx_type TEXT NOT NULL,            -- e.g. transfer, stake, vote, fx_transfer
+  nonce BIGINT NOT NULL DEFAULT 0,  -- sender-chosen sequence number
   payload JSONB,
   created_at TIMESTAMPTZ DEFAULT now()
 );
 
+-- Keyset pagination for transaction history, newest first
+CREATE INDEX IF NOT EXISTS idx_local_ledger_created
+  ON local_ledger (created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_local_ledger_from_created
+  ON local_ledger (from_public_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_local_ledger_to_created
+  ON local_ledger (to_public_id, created_at DESC, id DESC);
+
 -------------------------------------------------
 -- Local DAG Nodes
 -- Each DAG node links transactions in the local layer
@@ -27,9 +55,42 @@ CREATE TABLE IF NOT EXISTS local_dag_nodes (
   dag_type TEXT NOT NULL CHECK (dag_type = 'local'),
   node_id TEXT NOT NULL,
   node_signature TEXT NOT NULL,
+  tx_body TEXT NOT NULL,            -- canonical JSON hashed into tx_hash
+  status TEXT NOT NULL DEFAULT 'received', -- received, local_confirmed, submitted_global, finalized, rejected, quarantined
   created_at TIMESTAMPTZ DEFAULT now()
 );
 
+CREATE INDEX IF NOT EXISTS idx_local_dag_nodes_ledger
+  ON local_dag_nodes (ledger_id);
+
+CREATE INDEX IF NOT EXISTS idx_local_dag_nodes_status
+  ON local_dag_nodes (status, node_id, created_at);
+
+-- Tip selection scans parents arrays
+CREATE INDEX IF NOT EXISTS idx_local_dag_nodes_parents
+  ON local_dag_nodes USING GIN (parents);
+
+-------------------------------------------------
+-- Transaction Status Transitions
+-- One row per status change of a local DAG node, signed by the node that
+-- made it over "transition|tx_hash|from|to|reason|at_unix_ms" (from and
+-- reason empty when NULL). Rejections and quarantines carry a reason.
+-------------------------------------------------
+CREATE TABLE IF NOT EXISTS local_tx_transitions (
+  id BIGSERIAL PRIMARY KEY,
+  tx_hash TEXT NOT NULL REFERENCES local_dag_nodes(tx_hash),
+  from_status TEXT,                 -- NULL for the initial 'received'
+  to_status TEXT NOT NULL,
+  reason TEXT,
+  node_id TEXT NOT NULL,
+  signature TEXT NOT NULL,          -- base64 TPM child signature
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  CHECK (to_status NOT IN ('rejected', 'quarantined') OR reason IS NOT NULL)
+);
+
+CREATE INDEX IF NOT EXISTS idx_local_tx_transitions_tx
+  ON local_tx_transitions (tx_hash, created_at, id);
+
 -------------------------------------------------
 -- Registered Nodes (with TPM keys)
 -------------------------------------------------
@@ -65,6 +126,9 @@ CREATE TABLE IF NOT EXISTS local_verification_log (
   verified_at TIMESTAMPTZ DEFAULT now()
 );
 
+CREATE INDEX IF NOT EXISTS idx_local_verification_log_tx
+  ON local_verification_log (tx_hash, consensus_round);
+
 -------------------------------------------------
 -- Local Attestations
 -- TPM-signed proofs of participation in local DAG consensus
@@ -79,3 +143,413 @@ CREATE TABLE IF NOT EXISTS local_attestations (
   verified_at TIMESTAMPTZ,
   details JSONB
 );
+
+-- One vote per node per tx per round
+CREATE UNIQUE INDEX IF NOT EXISTS idx_local_attestations_vote
+  ON local_attestations (node_id, tx_hash, round);
+
+-------------------------------------------------
+-- Balances
+-- Per-account, per-currency balances kept current by a trigger on
+-- local_ledger. An fx_transfer debits the payer in currency and credits the
+-- payee in counter_currency.
+-------------------------------------------------
+CREATE TABLE IF NOT EXISTS local_balances (
+  account_id TEXT NOT NULL,
+  currency CHAR(3) NOT NULL REFERENCES local_currencies(code),
+  balance BIGINT NOT NULL DEFAULT 0,
+  updated_at TIMESTAMPTZ DEFAULT now(),
+  PRIMARY KEY (account_id, currency)
+);
+
+CREATE OR REPLACE FUNCTION local_apply_balances() RETURNS trigger AS $$
+BEGIN
+  -- asset legs move units, not money; bulk_import headers move nothing
+  IF NEW.tx_type IN ('issue_asset','trade_asset','bulk_import') THEN
+    RETURN NEW;
+  END IF;
+
+  INSERT INTO local_balances (account_id, currency, balance)
+  VALUES (NEW.from_public_id, NEW.currency, -NEW.amount)
+  ON CONFLICT (account_id, currency) DO UPDATE
+    SET balance = local_balances.balance - NEW.amount, updated_at = now();
+
+  INSERT INTO local_balances (account_id, currency, balance)
+  VALUES (NEW.to_public_id, COALESCE(NEW.counter_currency, NEW.currency), COALESCE(NEW.counter_amount, NEW.amount))
+  ON CONFLICT (account_id, currency) DO UPDATE
+    SET balance = local_balances.balance + EXCLUDED.balance, updated_at = now();
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_local_apply_balances ON local_ledger;
+CREATE TRIGGER trg_local_apply_balances
+  AFTER INSERT ON local_ledger
+  FOR EACH ROW EXECUTE FUNCTION local_apply_balances();
+
+-------------------------------------------------
+-- FX Rates
+-- 1 base = rate quote. Loaded from FX_RATES_FILE at startup or set through
+-- the admin API; the rate used is copied into each fx_transfer payload.
+-------------------------------------------------
+CREATE TABLE IF NOT EXISTS local_fx_rates (
+  base CHAR(3) NOT NULL REFERENCES local_currencies(code),
+  quote CHAR(3) NOT NULL REFERENCES local_currencies(code),
+  rate NUMERIC(24,12) NOT NULL CHECK (rate > 0),
+  source TEXT NOT NULL,             -- file | admin
+  updated_at TIMESTAMPTZ DEFAULT now(),
+  PRIMARY KEY (base, quote)
+);
+
+-------------------------------------------------
+-- Account Rollups
+-- Per-account, per-currency counts and sums in 15-minute UTC buckets, kept
+-- current by a trigger on local_ledger. Every real time zone offset is a
+-- multiple of 15 minutes, so day/week/month totals in any zone are sums of
+-- whole buckets.
+-------------------------------------------------
+CREATE TABLE IF NOT EXISTS local_account_rollups (
+  account_id TEXT NOT NULL,
+  currency CHAR(3) NOT NULL,
+  bucket_start TIMESTAMPTZ NOT NULL,
+  count_in BIGINT NOT NULL DEFAULT 0,
+  sum_in BIGINT NOT NULL DEFAULT 0,
+  count_out BIGINT NOT NULL DEFAULT 0,
+  sum_out BIGINT NOT NULL DEFAULT 0,
+  PRIMARY KEY (account_id, currency, bucket_start)
+);
+
+CREATE OR REPLACE FUNCTION local_rollup_ledger() RETURNS trigger AS $$
+DECLARE
+  bucket TIMESTAMPTZ := to_timestamp(floor(extract(epoch FROM COALESCE(NEW.created_at, now())) / 900) * 900);
+BEGIN
+  -- asset legs move units, not money; bulk_import headers move nothing
+  IF NEW.tx_type IN ('issue_asset','trade_asset','bulk_import') THEN
+    RETURN NEW;
+  END IF;
+
+  INSERT INTO local_account_rollups (account_id, currency, bucket_start, count_out, sum_out)
+  VALUES (NEW.from_public_id, NEW.currency, bucket, 1, NEW.amount)
+  ON CONFLICT (account_id, currency, bucket_start) DO UPDATE
+    SET count_out = local_account_rollups.count_out + 1,
+        sum_out = local_account_rollups.sum_out + EXCLUDED.sum_out;
+
+  INSERT INTO local_account_rollups (account_id, currency, bucket_start, count_in, sum_in)
+  VALUES (NEW.to_public_id, COALESCE(NEW.counter_currency, NEW.currency), bucket, 1, COALESCE(NEW.counter_amount, NEW.amount))
+  ON CONFLICT (account_id, currency, bucket_start) DO UPDATE
+    SET count_in = local_account_rollups.count_in + 1,
+        sum_in = local_account_rollups.sum_in + EXCLUDED.sum_in;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_local_rollup_ledger ON local_ledger;
+CREATE TRIGGER trg_local_rollup_ledger
+  AFTER INSERT ON local_ledger
+  FOR EACH ROW EXECUTE FUNCTION local_rollup_ledger();
+
+-- Backfill once, for ledgers that predate the rollup table
+INSERT INTO local_account_rollups (account_id, currency, bucket_start, count_in, sum_in, count_out, sum_out)
+SELECT account_id, currency, bucket_start, SUM(count_in), SUM(sum_in), SUM(count_out), SUM(sum_out)
+FROM (
+  SELECT from_public_id AS account_id, currency,
+         to_timestamp(floor(extract(epoch FROM created_at) / 900) * 900) AS bucket_start,
+         0 AS count_in, 0 AS sum_in, 1 AS count_out, amount AS sum_out
+  FROM local_ledger WHERE created_at IS NOT NULL AND tx_type NOT IN ('issue_asset','trade_asset','bulk_import')
+  UNION ALL
+  SELECT to_public_id, COALESCE(counter_currency, currency),
+         to_timestamp(floor(extract(epoch FROM created_at) / 900) * 900),
+         1, COALESCE(counter_amount, amount), 0, 0
+  FROM local_ledger WHERE created_at IS NOT NULL AND tx_type NOT IN ('issue_asset','trade_asset','bulk_import')
+) legs
+WHERE NOT EXISTS (SELECT 1 FROM local_account_rollups)
+GROUP BY account_id, currency, bucket_start;
+
+-------------------------------------------------
+-- Assets
+-- The registry and positions are derived from the ledger: issue_asset
+-- registers a symbol and credits the issuer, trade_asset moves quantity
+-- between accounts. Siblings replaying the same txs agree on both.
+-------------------------------------------------
+CREATE TABLE IF NOT EXISTS local_assets (
+  symbol TEXT PRIMARY KEY,
+  name TEXT NOT NULL,
+  issuer_public_id TEXT NOT NULL,
+  quote_currency CHAR(3) NOT NULL REFERENCES local_currencies(code), -- prices and cash legs
+  created_at TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS local_asset_positions (
+  account_id TEXT NOT NULL,
+  symbol TEXT NOT NULL REFERENCES local_assets(symbol),
+  quantity BIGINT NOT NULL DEFAULT 0,
+  updated_at TIMESTAMPTZ DEFAULT now(),
+  PRIMARY KEY (account_id, symbol)
+);
+
+CREATE OR REPLACE FUNCTION local_apply_asset_leg() RETURNS trigger AS $$
+DECLARE
+  sym TEXT := NEW.payload->>'symbol';
+BEGIN
+  IF NEW.tx_type = 'issue_asset' THEN
+    INSERT INTO local_assets (symbol, name, issuer_public_id, quote_currency)
+    VALUES (sym, COALESCE(NEW.payload->>'name', sym), NEW.to_public_id, NEW.payload->>'quote_currency')
+    ON CONFLICT (symbol) DO NOTHING;
+  ELSIF NEW.tx_type = 'trade_asset' THEN
+    INSERT INTO local_asset_positions (account_id, symbol, quantity)
+    VALUES (NEW.from_public_id, sym, -NEW.amount)
+    ON CONFLICT (account_id, symbol) DO UPDATE
+      SET quantity = local_asset_positions.quantity - NEW.amount, updated_at = now();
+  ELSE
+    RETURN NEW;
+  END IF;
+
+  INSERT INTO local_asset_positions (account_id, symbol, quantity)
+  VALUES (NEW.to_public_id, sym, NEW.amount)
+  ON CONFLICT (account_id, symbol) DO UPDATE
+    SET quantity = local_asset_positions.quantity + NEW.amount, updated_at = now();
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_local_apply_asset_leg ON local_ledger;
+CREATE TRIGGER trg_local_apply_asset_leg
+  AFTER INSERT ON local_ledger
+  FOR EACH ROW EXECUTE FUNCTION local_apply_asset_leg();
+
+-------------------------------------------------
+-- Reversals
+-- Balances, positions and rollup buckets are applied when a tx is stored. A
+-- tx that ends rejected or quarantined (e.g. the loser of a same-nonce
+-- conflict) has them reversed in the same transaction as its status change,
+-- so only effective txs move money or units or count in aggregates.
+-------------------------------------------------
+CREATE OR REPLACE FUNCTION local_reverse_ineffective() RETURNS trigger AS $$
+DECLARE
+  l local_ledger%ROWTYPE;
+  bucket TIMESTAMPTZ;
+BEGIN
+  IF NEW.status NOT IN ('rejected','quarantined') OR OLD.status IN ('rejected','quarantined') THEN
+    RETURN NEW;
+  END IF;
+  SELECT * INTO l FROM local_ledger WHERE id = NEW.ledger_id;
+  IF NOT FOUND OR l.tx_type = 'bulk_import' THEN
+    RETURN NEW;
+  END IF;
+
+  IF l.tx_type IN ('issue_asset','trade_asset') THEN
+    UPDATE local_asset_positions SET quantity = quantity - l.amount, updated_at = now()
+    WHERE account_id = l.to_public_id AND symbol = l.payload->>'symbol';
+    IF l.tx_type = 'trade_asset' THEN
+      UPDATE local_asset_positions SET quantity = quantity + l.amount, updated_at = now()
+      WHERE account_id = l.from_public_id AND symbol = l.payload->>'symbol';
+    END IF;
+    RETURN NEW;
+  END IF;
+
+  UPDATE local_balances SET balance = balance + l.amount, updated_at = now()
+  WHERE account_id = l.from_public_id AND currency = l.currency;
+  UPDATE local_balances SET balance = balance - COALESCE(l.counter_amount, l.amount), updated_at = now()
+  WHERE account_id = l.to_public_id AND currency = COALESCE(l.counter_currency, l.currency);
+
+  -- undo local_rollup_ledger
+  IF l.created_at IS NOT NULL THEN
+    bucket := to_timestamp(floor(extract(epoch FROM l.created_at) / 900) * 900);
+    UPDATE local_account_rollups SET count_out = count_out - 1, sum_out = sum_out - l.amount
+    WHERE account_id = l.from_public_id AND currency = l.currency AND bucket_start = bucket;
+    UPDATE local_account_rollups SET count_in = count_in - 1, sum_in = sum_in - COALESCE(l.counter_amount, l.amount)
+    WHERE account_id = l.to_public_id AND currency = COALESCE(l.counter_currency, l.currency) AND bucket_start = bucket;
+  END IF;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_local_reverse_ineffective ON local_dag_nodes;
+CREATE TRIGGER trg_local_reverse_ineffective
+  AFTER UPDATE OF status ON local_dag_nodes
+  FOR EACH ROW EXECUTE FUNCTION local_reverse_ineffective();
+
+-------------------------------------------------
+-- Orders
+-- This node's order book. Resting orders are reloaded into the matching
+-- engine on startup; fills land in the ledger as linked cash/asset legs.
+-------------------------------------------------
+CREATE TABLE IF NOT EXISTS local_orders (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  seq BIGSERIAL,                    -- time priority among equal prices
+  account_id TEXT NOT NULL,
+  symbol TEXT NOT NULL REFERENCES local_assets(symbol),
+  side TEXT NOT NULL CHECK (side IN ('buy','sell')),
+  order_type TEXT NOT NULL CHECK (order_type IN ('limit','market')),
+  price BIGINT,                     -- minor units per unit; NULL for market
+  quantity BIGINT NOT NULL CHECK (quantity > 0),
+  remaining BIGINT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open','partially_filled','filled','cancelled')),
+  nonce BIGINT NOT NULL DEFAULT 0,
+  user_pub TEXT,                    -- key that signed the order
+  user_sig TEXT,                    -- account's signature (orderSignMessage)
+  created_at TIMESTAMPTZ DEFAULT now(),
+  updated_at TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_local_orders_open
+  ON local_orders (symbol, seq) WHERE status IN ('open','partially_filled');
+CREATE INDEX IF NOT EXISTS idx_local_orders_account
+  ON local_orders (account_id, created_at DESC);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_local_orders_nonce
+  ON local_orders (account_id, nonce) WHERE user_sig IS NOT NULL;
+
+-------------------------------------------------
+-- Trades
+-- One row per fill, pointing at its two ledger legs
+-------------------------------------------------
+CREATE TABLE IF NOT EXISTS local_trades (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  symbol TEXT NOT NULL,
+  buy_order_id UUID NOT NULL REFERENCES local_orders(id),
+  sell_order_id UUID NOT NULL REFERENCES local_orders(id),
+  price BIGINT NOT NULL,
+  quantity BIGINT NOT NULL,
+  cash_tx_hash TEXT NOT NULL,
+  asset_tx_hash TEXT NOT NULL,
+  created_at TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_local_trades_symbol
+  ON local_trades (symbol, created_at DESC);
+
+-------------------------------------------------
+-- Checkpoints
+-- Balances implied by a settled DAG frontier, with a Merkle root over the
+-- sorted "account_id|currency|balance" leaves. status becomes 'signed' once
+-- a quorum of nodes has TPM-signed
+-- "checkpoint|seq|base|proposer|frontier_digest|root|count|created_unix_ms";
+-- hash is its sha256. base is the previous signed checkpoint the balances
+-- build on. bootstrap marks the checkpoint this node started from; pruned_at
+-- is set once the txs it covers have been deleted here.
+-------------------------------------------------
+CREATE TABLE IF NOT EXISTS local_checkpoints (
+  hash TEXT PRIMARY KEY,
+  seq BIGINT NOT NULL,
+  base TEXT,                        -- NULL for the first checkpoint
+  proposer TEXT NOT NULL,
+  frontier TEXT[] NOT NULL,
+  balances_root TEXT NOT NULL,
+  balance_count INT NOT NULL,
+  created_unix_ms BIGINT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'proposed', -- proposed | signed
+  bootstrap BOOLEAN NOT NULL DEFAULT false,
+  signed_at TIMESTAMPTZ,
+  pruned_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_local_checkpoints_signed
+  ON local_checkpoints (status, seq DESC, signed_at DESC);
+
+CREATE INDEX IF NOT EXISTS idx_local_checkpoints_frontier
+  ON local_checkpoints USING GIN (frontier);
+
+CREATE TABLE IF NOT EXISTS local_checkpoint_balances (
+  checkpoint_hash TEXT NOT NULL REFERENCES local_checkpoints(hash),
+  account_id TEXT NOT NULL,
+  currency CHAR(3) NOT NULL,
+  balance BIGINT NOT NULL,
+  PRIMARY KEY (checkpoint_hash, account_id, currency)
+);
+
+CREATE TABLE IF NOT EXISTS local_checkpoint_signatures (
+  checkpoint_hash TEXT NOT NULL REFERENCES local_checkpoints(hash),
+  node_id TEXT NOT NULL,
+  envelope JSONB NOT NULL,          -- signedEnvelope, verifiable without our nodes table
+  PRIMARY KEY (checkpoint_hash, node_id)
+);
+
+-------------------------------------------------
+-- Pruned Txs
+-- Txs covered by an older retained checkpoint are deleted from the ledger,
+-- the DAG and every per-tx table; their effect lives on in that
+-- checkpoint's balances. Only the hash is kept, so children naming them as
+-- parents still resolve, plus sender, nonce and final status so a pruned
+-- nonce stays spent.
+-------------------------------------------------
+CREATE TABLE IF NOT EXISTS local_pruned_txs (
+  tx_hash TEXT PRIMARY KEY,
+  checkpoint_hash TEXT NOT NULL REFERENCES local_checkpoints(hash),
+  from_public_id TEXT NOT NULL,
+  nonce BIGINT NOT NULL,
+  tx_type TEXT NOT NULL,
+  status TEXT NOT NULL,
+  user_signed BOOLEAN NOT NULL DEFAULT false, -- carried a user_sig; only these spend a nonce
+  pruned_at TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_local_pruned_txs_from_nonce
+  ON local_pruned_txs (from_public_id, nonce);
+
+-------------------------------------------------
+-- Tx Log
+-- Append-only Merkle accumulator (RFC 6962 shape) over the DAG entries this
+-- node stored, in storage order. Leaves are H(0x00 || "tx|" || tx_hash);
+-- local_tx_log_nodes holds every perfect subtree hash. Pruning does not
+-- touch it.
+-------------------------------------------------
+CREATE TABLE IF NOT EXISTS local_tx_log_head (
+  id SMALLINT PRIMARY KEY CHECK (id = 1),
+  size BIGINT NOT NULL DEFAULT 0
+);
+
+INSERT INTO local_tx_log_head (id, size) VALUES (1, 0) ON CONFLICT (id) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS local_tx_log (
+  leaf_index BIGINT PRIMARY KEY,
+  tx_hash TEXT NOT NULL UNIQUE,
+  created_at TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS local_tx_log_nodes (
+  level SMALLINT NOT NULL,
+  idx BIGINT NOT NULL,
+  hash TEXT NOT NULL,               -- hex
+  PRIMARY KEY (level, idx)
+);
+
+-------------------------------------------------
+-- Spend Conflicts
+-- User-signed txs sharing from_public_id and nonce conflict. Each node
+-- orders them the same way (conflicting ancestor, then the earlier
+-- ts_unix_ms the user signed, then tx_hash), rejects the losers and signs
+-- "conflict|winner|loser|from|nonce|rule|at_unix_ms" for each pair.
+-------------------------------------------------
+CREATE INDEX IF NOT EXISTS idx_local_ledger_from_nonce
+  ON local_ledger (from_public_id, nonce);
+
+CREATE TABLE IF NOT EXISTS local_tx_conflicts (
+  id BIGSERIAL PRIMARY KEY,
+  winner_tx_hash TEXT NOT NULL,
+  loser_tx_hash TEXT NOT NULL,
+  from_public_id TEXT NOT NULL,
+  nonce BIGINT NOT NULL,
+  rule TEXT NOT NULL CHECK (rule IN ('finalized', 'topological', 'timestamp', 'hash')),
+  node_id TEXT NOT NULL,
+  signature TEXT NOT NULL,          -- base64 TPM child signature
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  UNIQUE (winner_tx_hash, loser_tx_hash)
+);
+
+CREATE INDEX IF NOT EXISTS idx_local_tx_conflicts_loser
+  ON local_tx_conflicts (loser_tx_hash);
+
+-------------------------------------------------
+-- Node Quarantine
+-- Notices from the global layer, each backed by a quorum of signed
+-- verifier votes. A quarantined sibling's gossip, votes and checkpoint
+-- signatures are refused until a later epoch clears it.
+-------------------------------------------------
+CREATE TABLE IF NOT EXISTS local_node_quarantine (
+  node_id TEXT PRIMARY KEY,          -- cluster_id/node_id as the global layer names it
+  status TEXT NOT NULL CHECK (status IN ('quarantined','clear')),
+  epoch BIGINT NOT NULL,
+  reason TEXT,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);